@@ -0,0 +1,557 @@
+// Package command builds the monad CLI. Programs registering their own steps
+// run it from their main package in place of cmd/monad.
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bkeane/monad/cmd/monad/desc"
+	"github.com/bkeane/monad/cmd/monad/pkg"
+	"github.com/bkeane/monad/pkg/basis"
+	"github.com/bkeane/monad/pkg/config"
+	"github.com/bkeane/monad/pkg/flag"
+	"github.com/bkeane/monad/pkg/format"
+	"github.com/bkeane/monad/pkg/gc"
+	"github.com/bkeane/monad/pkg/journal"
+	"github.com/bkeane/monad/pkg/lock"
+	monadlog "github.com/bkeane/monad/pkg/log"
+	"github.com/bkeane/monad/pkg/orphans"
+	"github.com/bkeane/monad/pkg/scaffold"
+	"github.com/bkeane/monad/pkg/workspace"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v3"
+)
+
+func init() {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	if value, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		if level, err := zerolog.ParseLevel(value); err == nil {
+			zerolog.SetGlobalLevel(level)
+		}
+	}
+}
+
+// Run runs the monad CLI with args, which start with the program name as os.Args does
+func Run(ctx context.Context, args []string) error {
+	flag.DisableDefaults()
+	flag.Files(func() string { return os.Getenv("MONAD_CHDIR") }, workspace.Files...)
+	flag.Profiles("MONAD_PROFILE", pkg.Branch)
+
+	return root().Run(ctx, args)
+}
+
+func root() *cli.Command {
+	return &cli.Command{
+		Name:   "monad",
+		Usage:  "service management",
+		Flags:  flag.Flags[basis.Basis](),
+		Before: flag.Before[basis.Basis](),
+		Commands: []*cli.Command{
+			{
+				Name:        "init",
+				Usage:       "scaffold a service",
+				UsageText:   "monad init <LANGUAGE> [LOCATION]",
+				Description: desc.Init(),
+				Flags:       flag.Flags[scaffold.Scaffold](),
+				Before:      flag.Before[scaffold.Scaffold](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					language := cmd.Args().Get(0)
+					targetDir := cmd.Args().Get(1)
+
+					if language == "" {
+						return fmt.Errorf("language is required")
+					}
+
+					scaffold, err := pkg.Scaffold(ctx)
+					if err != nil {
+						return err
+					}
+
+					return scaffold.Create(language, targetDir)
+				},
+			},
+			{
+				Name:        "deploy",
+				Usage:       "deploy a service",
+				Description: desc.Deploy(),
+				Flags:       flag.Flags[pkg.Deploy](),
+				Before:      flag.Before[pkg.Deploy](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					output, err := pkg.Output(ctx)
+					if err != nil {
+						return err
+					}
+
+					workspace, err := pkg.Workspace(ctx)
+					if err != nil {
+						return err
+					}
+
+					if workspace.Selected() {
+						batch, err := workspace.Run(ctx, "deploy")
+						return errors.Join(err, output.WriteBatch(os.Stdout, batch))
+					}
+
+					saga, err := pkg.Saga(ctx)
+					if err != nil {
+						return err
+					}
+
+					report, err := saga.Do(ctx)
+					return errors.Join(err, output.Write(os.Stdout, report))
+				},
+			},
+			{
+				Name:        "plan",
+				Usage:       "preview changes deploy would make",
+				Description: desc.Plan(),
+				Flags:       flag.Flags[config.Config](),
+				Before:      flag.Before[config.Config](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					saga, err := pkg.Saga(ctx)
+					if err != nil {
+						return err
+					}
+
+					plans, err := saga.Plan(ctx)
+					if err != nil {
+						return err
+					}
+
+					for _, plan := range plans {
+						fmt.Print(plan)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:        "drift",
+				Usage:       "report resources changed outside of deploy",
+				Description: desc.Drift(),
+				Flags:       flag.Flags[pkg.Drift](),
+				Before:      flag.Before[pkg.Drift](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					saga, err := pkg.Saga(ctx)
+					if err != nil {
+						return err
+					}
+
+					plans, err := saga.Drift(ctx)
+					if err != nil {
+						return err
+					}
+
+					for _, plan := range plans {
+						fmt.Print(plan)
+					}
+
+					if len(plans) > 0 {
+						return fmt.Errorf("%d of the selected steps drifted from the config", len(plans))
+					}
+
+					log.Info().Msg("no drift")
+					return nil
+				},
+			},
+			{
+				Name:        "describe",
+				Usage:       "list the resources of a deployed service",
+				Description: desc.Describe(),
+				Flags:       flag.Flags[pkg.Describe](),
+				Before:      flag.Before[pkg.Describe](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					output, err := pkg.Output(ctx)
+					if err != nil {
+						return err
+					}
+
+					saga, err := pkg.Saga(ctx)
+					if err != nil {
+						return err
+					}
+
+					inventories, err := saga.Describe(ctx)
+					if err != nil {
+						return err
+					}
+
+					if output.ReportFormat != "" {
+						return output.WriteInventory(os.Stdout, inventories)
+					}
+
+					for _, inventory := range inventories {
+						fmt.Print(inventory)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:        "destroy",
+				Usage:       "destroy a service",
+				Description: desc.Destroy(),
+				Flags:       flag.Flags[pkg.Destroy](),
+				Before:      flag.Before[pkg.Destroy](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					output, err := pkg.Output(ctx)
+					if err != nil {
+						return err
+					}
+
+					workspace, err := pkg.Workspace(ctx)
+					if err != nil {
+						return err
+					}
+
+					if workspace.Selected() {
+						batch, err := workspace.Run(ctx, "destroy")
+						return errors.Join(err, output.WriteBatch(os.Stdout, batch))
+					}
+
+					saga, err := pkg.Saga(ctx)
+					if err != nil {
+						return err
+					}
+
+					report, err := saga.Undo(ctx)
+					return errors.Join(err, output.Write(os.Stdout, report))
+				},
+			},
+			{
+				Name:        "rollback",
+				Usage:       "roll back a service to a previous deploy",
+				Description: desc.Rollback(),
+				Flags:       flag.Flags[pkg.Rollback](),
+				Before:      flag.Before[pkg.Rollback](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					rollback, err := pkg.Rollbacker(ctx)
+					if err != nil {
+						return err
+					}
+
+					return rollback.Do(ctx)
+				},
+			},
+			{
+				Name:        "gc",
+				Usage:       "destroy deployments of deleted branches",
+				Description: desc.GC(),
+				Flags:       flag.Flags[pkg.GC](),
+				Before:      flag.Before[pkg.GC](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					collector, err := pkg.Collector(ctx)
+					if err != nil {
+						return err
+					}
+
+					results, err := collector.Do(ctx)
+					if len(results) > 0 {
+						fmt.Println(gc.Table(results))
+					}
+
+					return err
+				},
+			},
+			{
+				Name:        "orphans",
+				Usage:       "find resources left behind by deleted functions",
+				Description: desc.Orphans(),
+				Flags:       flag.Flags[pkg.Orphans](),
+				Before:      flag.Before[pkg.Orphans](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					orphaner, err := pkg.Orphaner(ctx)
+					if err != nil {
+						return err
+					}
+
+					results, err := orphaner.Do(ctx)
+					if len(results) > 0 {
+						fmt.Println(orphans.Table(results))
+					}
+
+					return err
+				},
+			},
+			{
+				Name:        "list",
+				Usage:       "list services",
+				Description: desc.List(),
+				Flags:       flag.Flags[pkg.List](),
+				Before:      flag.Before[pkg.List](),
+				Action: func(ctx context.Context, c *cli.Command) error {
+					state, err := pkg.State(ctx)
+					if err != nil {
+						return err
+					}
+
+					workspace, err := pkg.Workspace(ctx)
+					if err != nil {
+						return err
+					}
+
+					if workspace.Selected() {
+						names, err := workspace.Names()
+						if err != nil {
+							return err
+						}
+
+						state.Services(names)
+					}
+
+					output, err := pkg.Format(ctx)
+					if err != nil {
+						return err
+					}
+
+					rows, err := state.Rows(ctx)
+					if err != nil {
+						return err
+					}
+
+					return format.Write(os.Stdout, output, rows)
+				},
+			},
+			{
+				Name:        "history",
+				Usage:       "list deploys and destroys of a service",
+				Description: desc.History(),
+				Flags:       flag.Flags[journal.Journal](),
+				Before:      flag.Before[journal.Journal](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					journal, err := pkg.Journal(ctx)
+					if err != nil {
+						return err
+					}
+
+					table, err := journal.Table(ctx)
+					if err != nil {
+						return err
+					}
+
+					fmt.Println(table)
+
+					return nil
+				},
+			},
+			{
+				Name:        "unlock",
+				Usage:       "release an abandoned deploy lock",
+				Description: desc.Unlock(),
+				Flags:       flag.Flags[lock.Lock](),
+				Before:      flag.Before[lock.Lock](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					lock, err := pkg.Lock(ctx)
+					if err != nil {
+						return err
+					}
+
+					lease, err := lock.Force(ctx)
+					if err != nil {
+						return err
+					}
+
+					if lease == nil {
+						log.Info().Msg("not locked")
+						return nil
+					}
+
+					log.Info().
+						Str("action", "delete").
+						Str("key", lease.Key).
+						Str("owner", lease.Owner).
+						Time("acquired", lease.Acquired).
+						Msg("lock")
+
+					return nil
+				},
+			},
+			{
+				Name:   "ecr",
+				Usage:  "service artifacts",
+				Flags:  flag.Flags[basis.Basis](),
+				Before: flag.Before[basis.Basis](),
+				Commands: []*cli.Command{
+					{
+						Name:  "login",
+						Usage: "login to ecr",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							registry, err := pkg.Registry(ctx)
+							if err != nil {
+								return err
+							}
+
+							return registry.Login(ctx)
+						},
+					},
+					{
+						Name:  "init",
+						Usage: "initialize image repository",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							registry, err := pkg.Registry(ctx)
+							if err != nil {
+								return err
+							}
+
+							return registry.CreateRepository(ctx)
+						},
+					},
+					{
+						Name:  "destroy",
+						Usage: "destroy image repository",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							registry, err := pkg.Registry(ctx)
+							if err != nil {
+								return err
+							}
+
+							return registry.DeleteRepository(ctx)
+						},
+					},
+					{
+						Name:  "untag",
+						Usage: "untag image",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							registry, err := pkg.Registry(ctx)
+							if err != nil {
+								return err
+							}
+
+							return registry.Untag(ctx)
+						},
+					},
+					{
+						Name:  "tag",
+						Usage: "print basis image",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							basis, err := pkg.Basis(ctx)
+							if err != nil {
+								return err
+							}
+
+							registry, err := basis.Registry()
+							if err != nil {
+								return err
+							}
+
+							fmt.Println(registry.ImageUrl())
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:   "render",
+				Usage:  "contextual templating",
+				Flags:  flag.Flags[basis.Basis](),
+				Before: flag.Before[basis.Basis](),
+				Commands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "list available key/values",
+						Flags:  flag.Flags[format.Format](),
+						Before: flag.Before[format.Format](),
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							basis, err := pkg.Basis(ctx)
+							if err != nil {
+								return err
+							}
+
+							output, err := pkg.Format(ctx)
+							if err != nil {
+								return err
+							}
+
+							rows, err := basis.Rows()
+							if err != nil {
+								return err
+							}
+
+							return format.Write(os.Stdout, output, rows)
+						},
+					},
+					{
+						Name:      "file",
+						Usage:     "render file to stdout",
+						UsageText: "monad render file <PATH>",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							file := cmd.Args().First()
+							if file == "" {
+								return fmt.Errorf("file required")
+							}
+
+							basis, err := pkg.Basis(ctx)
+							if err != nil {
+								return err
+							}
+
+							content, err := os.ReadFile(file)
+							if err != nil {
+								return err
+							}
+
+							result, err := basis.Render(string(content))
+							if err != nil {
+								return err
+							}
+
+							fmt.Print(result)
+							return nil
+						},
+					},
+					{
+						Name:      "string",
+						Usage:     "render string to stdout",
+						UsageText: "monad render string <STRING>",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							input := cmd.Args().First()
+							if input == "" {
+								return fmt.Errorf("input required")
+							}
+
+							basis, err := pkg.Basis(ctx)
+							if err != nil {
+								return err
+							}
+
+							result, err := basis.Render(input)
+							if err != nil {
+								return err
+							}
+
+							fmt.Print(result)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:        "logs",
+				Usage:       "fetch service logs",
+				Description: desc.Logs(),
+				Flags:       flag.Flags[monadlog.LogGroup](),
+				Before:      flag.Before[monadlog.LogGroup](),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					logs, err := pkg.Log(ctx)
+					if err != nil {
+						return err
+					}
+
+					if logs.LogGroupTail {
+						return logs.Tail(ctx)
+					}
+
+					return logs.Dump(ctx)
+
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bkeane/monad/pkg/config"
+	"github.com/bkeane/monad/pkg/step"
+
+	v5 "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type queue struct {
+	mounted bool
+}
+
+func (q *queue) Mount(ctx context.Context) error {
+	q.mounted = true
+	return nil
+}
+
+func (q *queue) Unmount(ctx context.Context) error {
+	q.mounted = false
+	return nil
+}
+
+// service points monad at a checkout of acme/shop and at an AWS endpoint answering
+// only what deriving the builtin steps requires
+func service(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := v5.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+
+	_, err = worktree.Commit("initial", &v5.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{"https://github.com/acme/shop.git"}})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		switch {
+		case string(body) == "Action=GetCallerIdentity&Version=2011-06-15":
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult><Arn>arn:aws:iam::123456789012:user/test</Arn><Account>123456789012</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`)
+		case r.Header.Get("X-Amz-Target") == "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken":
+			fmt.Fprint(w, `{"authorizationData":[{"authorizationToken":"QVdTOnRva2Vu","proxyEndpoint":"https://123456789012.dkr.ecr.us-east-1.amazonaws.com"}]}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Header.Get("X-Amz-Target"), body)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	// monad changes into MONAD_CHDIR, so the working directory is restored afterwards
+	t.Chdir(dir)
+	t.Setenv("MONAD_CHDIR", dir)
+	t.Setenv("MONAD_JOURNAL", t.TempDir())
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_MAX_ATTEMPTS", "1")
+}
+
+func TestRun_RegisteredStep(t *testing.T) {
+	q := &queue{}
+	require.NoError(t, step.Register(step.Definition{
+		Name: "queue",
+		Derive: func(ctx context.Context, config *config.Config) (step.Step, error) {
+			return q, nil
+		},
+	}))
+
+	service(t)
+
+	err := Run(context.Background(), []string{"monad", "deploy", "--only", "queue"})
+	require.NoError(t, err)
+	assert.True(t, q.mounted, "the registered step mounts with the saga")
+}
@@ -44,5 +44,294 @@ Examples:
   monad list --branch='*'                 # All branches (note quotes)
   monad list --owner='*' --repo='*' --branch='*'  # All deployments
 
-Use --owner='*', --repo='*', --branch='*' for unfiltered results (quotes required).`
+Use --owner='*', --repo='*', --branch='*' for unfiltered results (quotes required).
+
+Owner, repo, branch and --service take a glob whose * matches any characters,
+slashes included, or a regular expression between slashes. Other characters
+match themselves. --service and --sha only filter when given, the sha matching
+by prefix:
+  monad list --branch 'feat/*' --service 'api-*'
+  monad list --repo '/^shop-(api|web)$/' --sha 3f2a1c
+  monad list --branch '*' --sort=-modified --limit 5   # Five latest deploys
+
+In a monorepo --all lists only the services found below the current directory,
+and --services only those whose name or directory match:
+  monad list --all
+  monad list --services 'services/api-*'
+
+Add the time each function was last modified and the digest of its image with:
+  monad list --columns modified --columns digest
+
+Write the listing for scripts as json, yaml, csv or tsv, or one line per service
+with a Go template:
+  monad list --output json
+  monad list --format '{{.Service}} {{.Branch}} {{.Sha}}'
+
+The env var of --output is MONAD_LIST_OUTPUT, as MONAD_OUTPUT and the output key
+of monad.yaml set the format of deploy reports.
+
+Use monad describe for everything deployed for one service.`
+}
+
+// Describe returns a description for the describe command
+func Describe() string {
+	return `List every resource monad deployed for the service of the current directory.
+
+Each step reports what it finds in AWS without writing to it:
+  iam          role, boundary and policy document
+  cloudwatch   log group and its retention
+  lambda       function image digest, architecture, memory, timeout and disk
+  apigateway   routes with their authorization and invoke url
+  eventbridge  rules with their bus and pattern or schedule
+
+Pass the flags the service was deployed with, such as --branch, --api and --bus,
+so that its resources are found. Use --output json, yaml or markdown for a
+machine readable inventory.`
+}
+
+// Deploy returns a description for the deploy command with the hooks file format
+func Deploy() string {
+	return `Deploy the service of the current directory.
+
+Flags may instead be checked in to a monad.yaml or monad.toml file in the
+service directory, keyed by flag name. A flag given on the command line or as a
+MONAD_* env var takes precedence over the file:
+
+  memory: 512
+  timeout: 30
+  route:
+    - ANY /{{.Service.Name}}/{proxy+}
+  auth: [aws_iam]
+  vpc-sg: [default]
+
+Profiles in the file override its values. One is selected with --profile, or
+else by the first of the branches patterns matching the git branch, where *
+matches any branch. The selected profile is available to templates as
+{{.Profile.Name}}:
+
+  profiles:
+    prod:
+      memory: 1024
+      retention: 90
+    dev:
+      memory: 128
+  branches:
+    - match: main
+      profile: prod
+    - match: "*"
+      profile: dev
+
+By default api gateway routes and eventbridge rules are deleted and recreated on
+every deploy. With --reconcile only routes, integrations, permissions and rules
+that differ from the config are created, updated or deleted, so unchanged routes
+keep serving and rules keep firing throughout. The function and its role are
+also left untouched when their image, configuration and policy are unchanged.
+
+With --alias each deploy publishes a function version and points the alias at
+it, and api gateway integrations and eventbridge rules invoke the alias. Given
+--canary 10,50 an existing alias routes 10% then 50% of its traffic to the new
+version, waiting --canary-wait seconds at each, before cutting over entirely.
+With --health the new version is checked at each percentage as well, and one
+failing a check is shifted out of the alias again, failing the deploy.
+
+With --health the deployed routes are requested, or the function invoked with
+--health-payload, until the response matches --health-status and --health-body.
+Should that not happen within --health-timeout, the function is reverted to the
+image and configuration it had before the deploy and the deploy fails. Routes
+behind a jwt or custom authorizer are skipped, as monad holds no token for them.
+
+With --all every directory below the current one holding a Dockerfile and a
+monad.yaml or monad.toml is deployed as a service, or with --services only those
+whose name or directory match the given globs. Up to --concurrency services are
+deployed at once, each reading its own config file, and a combined report is
+written with --output. Other flags apply to every service.
+
+A service deploys after the services named by depends_on in its config file,
+given they are deployed with it, and is skipped should any of them fail:
+
+  depends_on: [bus, authorizer]
+
+Hooks run shell commands or http requests before and after the deploy, or
+around a single step. They are read from the --hooks file, which is templated
+like any other monad file:
+
+  hooks:
+    - name: migrate
+      when: pre            # pre or post
+      step: lambda         # omit to run around the whole deploy
+      command: ./migrate.sh
+    - name: smoke
+      when: post
+      action: deploy       # deploy (default) or destroy
+      http:
+        url: https://example.com/smoke
+        method: POST       # defaults to POST with a json body of the variables
+      timeout: 60          # seconds, defaults to 300
+
+Hooks receive the MONAD_* template variables, MONAD_HOOK_ACTION, MONAD_HOOK_WHEN,
+MONAD_HOOK_STEP, and the outputs of each step, e.g. MONAD_LAMBDA_FUNCTION_ARN.
+A failing hook fails the deploy.`
+}
+
+// Destroy returns a description for the destroy command
+func Destroy() string {
+	return `Destroy the service of the current directory.
+
+With --all every service found below the current directory is destroyed, or
+with --services only those whose name or directory match the given globs, up to
+--concurrency at once. Services are found as for deploy --all and are destroyed
+before the services they depend on.`
+}
+
+// Plan returns a description for the plan command
+func Plan() string {
+	return `Preview the changes deploy would make without writing to AWS.
+
+Each step reports a diff against the deployed service:
+  + resource is created
+  - resource is removed
+  ~ resource is updated
+
+Accepts the same flags as deploy.`
+}
+
+// Drift returns a description for the drift command
+func Drift() string {
+	return `Compare the deployed resources of the service with what deploy would make of the config.
+
+The function configuration and env, role and policy documents, log group
+retention, api routes and their authorization, and eventbridge rule patterns
+are each compared and reported like plan:
+  + resource is missing
+  - resource is not in the config
+  ~ resource was changed
+
+Run from the deployed commit with the flags it was deployed with, any change
+was made outside of monad, such as an edit in the console. Drift exits non-zero
+when any step has changed, so it can gate CI. Use --only and --skip to limit the
+steps compared.`
+}
+
+// History returns a description for the history command
+func History() string {
+	return `List the deploys and destroys recorded in the journal of the current service, newest first.
+
+Every step transition of deploy and destroy is journaled. A deploy that failed
+or was interrupted can be continued from its last successful step:
+  monad deploy --resume
+
+Only the latest run is resumed. After a finished run --resume deploys every
+step, and it is refused after an unfinished destroy or rollback.
+
+Journals are kept in the user cache directory unless --journal names another
+directory or an s3://bucket/prefix shared between CI runs. In S3 each run is
+an object of its own, and the 50 most recent runs of a service are kept.`
+}
+
+// Rollback returns a description for the rollback command
+func Rollback() string {
+	return `Return the function of the current service to the image of an earlier deploy.
+
+The deploys to return to are read from the journal. Without --to the function
+goes back to the last successful deploy of a sha other than the one it runs now:
+  monad rollback
+  monad rollback --to 3f2c1ab
+
+Should the journal not hold that deploy, as when it is kept on the machine that
+deployed rather than in s3, the versions deploys published with --alias are
+looked through instead. Only a change of role is then checked before rolling
+back, as the policy and routes of earlier deploys are not known.
+
+Only the image is replaced unless --rollback-env is given, which also restores
+the memory, timeout, disk and env of that deploy. This requires the deploy to
+have published a version with --alias. When an alias is in use it is pointed at
+the restored version with all of its traffic.
+
+The role and routes are left as they are. Should the role policy or the routes
+have changed since the deploy rolled back to, the rollback is refused unless
+--force is given. Rollbacks are journaled and hold the deploy lock.`
+}
+
+// GC returns a description for the gc command
+func GC() string {
+	return `Destroy the deployments of the current repo whose branch was deleted from the remote.
+
+The branches of every deployed service of the repo are compared with those of
+the git remote, origin unless --remote names another remote or a url. Https
+remotes are read with GITHUB_TOKEN when set. Deployments of branches no longer
+on the remote are destroyed like monad destroy would: routes, rules, role, log
+group and function. Every step is destroyed and no hooks run, as the hooks,
+--only and --skip of the current checkout belong to its own deployment. The
+current branch is never collected.
+
+  monad gc --dry-run        # report stale deployments only
+  monad gc --min-age 7      # keep those deployed within the last week
+
+Run gc with the flags the services were deployed with, such as --api and --bus,
+so that their routes and rules are found.`
+}
+
+// Orphans returns a description for the orphans command
+func Orphans() string {
+	return `Find the resources monad created whose function no longer exists.
+
+A partial deploy or destroy can leave behind roles, policies, log groups, api
+routes and integrations, and eventbridge rules. These are found by their monad
+tags, or for integrations and their routes by the request parameters monad sets,
+and reported when the function they were created for is missing. Functions whose
+role is missing are reported too.
+
+Only resources of the current repo are reported, or of every repo with
+--owner='*' --repo='*'. Resources created or modified within --grace minutes are
+left out, as a deploy creates the role, policy and log group of a service before
+its function. Nothing is deleted unless --delete names the kinds to delete, and
+functions are deleted only when named, as they may still be serving:
+
+  monad orphans                        # report orphaned resources
+  monad orphans --delete '*'           # delete all but functions
+  monad orphans --delete route,rule    # delete routes and rules
+  monad orphans --delete function      # delete functions whose role is missing
+
+Resources are deleted as monad destroy would, routes before their integration
+and roles before their policy. The lock of the service a resource is tagged with
+is held while it is deleted, and a resource whose function or role has been
+deployed since the scan is skipped.`
+}
+
+// Unlock returns a description for the unlock command
+func Unlock() string {
+	return `Remove the deploy lock of the current service regardless of who holds it.
+
+Deploy and destroy hold a lock keyed on the service resource name, renewing
+its lease while they run. A lock left by a killed process expires after its
+lease, or can be removed immediately with this command.
+
+Deploys are only locked when --lock names where: a dynamodb://table shared
+between CI runners, or a directory serializing runs on one machine. The table
+requires a string partition key named "key". Without --lock a warning is
+logged and deploys of the same service may run at once.`
+}
+
+// Logs returns a description for the logs command
+func Logs() string {
+	return `Fetch the logs of the current service from its CloudWatch log group.
+
+Logs of the last 30 seconds are shown unless --ago or --since and --until give
+the time range. --until alone ends that default range at the given time, and
+--since must come before --until. --tail follows new logs as they arrive:
+
+  monad logs --ago 1h
+  monad logs --since '2025-06-01 12:00' --until '2025-06-01 13:00'
+  monad logs --tail --ago 5m
+
+--filter takes a CloudWatch filter pattern and --request-id isolates a single
+invocation, showing every line its log stream holds from START to END. Both
+apply to fetched and followed logs alike:
+
+  monad logs --filter ERROR
+  monad logs --filter '{ $.level = "error" }' --tail
+  monad logs --request-id 8f5e3a3c-5a0e-4c5b-9d6e-0f1a2b3c4d5e
+
+With --output json, JSON messages are pretty printed and colored by level.
+--output is read from MONAD_LOG_OUTPUT and never from monad.yaml.`
 }
@@ -2,21 +2,112 @@ package pkg
 
 import (
 	"context"
+	"errors"
+	"os"
 
+	"github.com/bkeane/monad/internal/git"
 	"github.com/bkeane/monad/pkg/basis"
 	"github.com/bkeane/monad/pkg/config"
+	"github.com/bkeane/monad/pkg/format"
+	"github.com/bkeane/monad/pkg/gc"
+	"github.com/bkeane/monad/pkg/hook"
+	"github.com/bkeane/monad/pkg/journal"
+	"github.com/bkeane/monad/pkg/lock"
 	"github.com/bkeane/monad/pkg/log"
+	"github.com/bkeane/monad/pkg/orphans"
 	"github.com/bkeane/monad/pkg/registry"
+	"github.com/bkeane/monad/pkg/report"
+	"github.com/bkeane/monad/pkg/rollback"
 	"github.com/bkeane/monad/pkg/saga"
 	"github.com/bkeane/monad/pkg/scaffold"
 	"github.com/bkeane/monad/pkg/state"
 	"github.com/bkeane/monad/pkg/step"
+	"github.com/bkeane/monad/pkg/workspace"
 )
 
+// Deploy aggregates the flag definitions of the deploy command
+type Deploy struct {
+	Config    *config.Config
+	Saga      *saga.Saga
+	Journal   *journal.Journal
+	Lock      *lock.Lock
+	Hooks     *hook.Hooks
+	Output    *report.Output
+	Workspace *workspace.Workspace
+}
+
+// Destroy aggregates the flag definitions of the destroy command
+type Destroy struct {
+	Selection *saga.Selection
+	Journal   *journal.Journal
+	Lock      *lock.Lock
+	Hooks     *hook.Hooks
+	Output    *report.Output
+	Workspace *workspace.Workspace
+}
+
+// Describe aggregates the flag definitions of the describe command
+type Describe struct {
+	Config *config.Config
+	Output *report.Output
+}
+
+// List aggregates the flag definitions of the list command
+type List struct {
+	State     *state.State
+	Workspace *workspace.Workspace
+	Format    *format.Format
+}
+
+// Drift aggregates the flag definitions of the drift command
+type Drift struct {
+	Config    *config.Config
+	Selection *saga.Selection
+}
+
+// GC aggregates the flag definitions of the gc command
+type GC struct {
+	GC      *gc.GC
+	Journal *journal.Journal
+	Lock    *lock.Lock
+}
+
+// Orphans aggregates the flag definitions of the orphans command
+type Orphans struct {
+	Orphans *orphans.Orphans
+	Lock    *lock.Lock
+}
+
+// Rollback aggregates the flag definitions of the rollback command
+type Rollback struct {
+	Rollback *rollback.Rollback
+	Journal  *journal.Journal
+	Lock     *lock.Lock
+}
+
 func Basis(ctx context.Context) (*basis.Basis, error) {
 	return basis.Derive(ctx)
 }
 
+// Branch returns the git branch config file profiles are selected by
+func Branch() (string, error) {
+	if branch := os.Getenv("MONAD_BRANCH"); branch != "" {
+		return branch, nil
+	}
+
+	dir := os.Getenv("MONAD_CHDIR")
+	if dir == "" {
+		dir = "."
+	}
+
+	git, err := git.Parse(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return git.Branch, nil
+}
+
 func Config(ctx context.Context) (*config.Config, error) {
 	basis, err := Basis(ctx)
 	if err != nil {
@@ -27,7 +118,17 @@ func Config(ctx context.Context) (*config.Config, error) {
 }
 
 func Saga(ctx context.Context) (*saga.Saga, error) {
-	config, err := Config(ctx)
+	basis, err := Basis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return SagaOf(ctx, basis)
+}
+
+// SagaOf returns the saga of the service of the given basis
+func SagaOf(ctx context.Context, basis *basis.Basis) (*saga.Saga, error) {
+	config, err := config.Derive(ctx, basis)
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +138,196 @@ func Saga(ctx context.Context) (*saga.Saga, error) {
 		return nil, err
 	}
 
-	return saga.Derive(ctx, steps), nil
+	journal, err := journal.Derive(ctx, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := lock.Derive(ctx, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks, err := hook.Derive(ctx, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	return saga.Derive(ctx, steps, saga.Dependencies{
+		Journal: journal,
+		Locker:  lock,
+		Hooks:   hooks,
+	})
+}
+
+func Journal(ctx context.Context) (*journal.Journal, error) {
+	basis, err := Basis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return journal.Derive(ctx, basis)
+}
+
+func Rollbacker(ctx context.Context) (*rollback.Rollback, error) {
+	basis, err := Basis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := journal.Derive(ctx, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := lock.Derive(ctx, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	return rollback.Derive(ctx, basis, journal, lock)
+}
+
+func Workspace(ctx context.Context) (*workspace.Workspace, error) {
+	if _, err := Basis(ctx); err != nil {
+		return nil, err
+	}
+
+	return workspace.Derive(ctx)
+}
+
+func Collector(ctx context.Context) (*gc.GC, error) {
+	basis, err := Basis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, err := state.Init(ctx, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	// stale deployments are destroyed by the saga of their own basis, as monad destroy would
+	destroy := func(ctx context.Context, deployment *state.StateMetadata) error {
+		deployed := basis.Deployment(deployment.Owner, deployment.Repo, deployment.Branch, deployment.Sha, deployment.Service)
+
+		config, err := config.Derive(ctx, deployed)
+		if err != nil {
+			return err
+		}
+
+		steps, err := step.Derive(ctx, config)
+		if err != nil {
+			return err
+		}
+
+		saga, err := collected(ctx, deployed, steps)
+		if err != nil {
+			return err
+		}
+
+		_, err = saga.Undo(ctx)
+		return err
+	}
+
+	return gc.Derive(ctx, basis, lister, destroy)
+}
+
+// collected returns the saga undoing a stale deployment in full. The hooks and the --only
+// and --skip of the current checkout belong to its own deployment, so none of them apply.
+func collected(ctx context.Context, deployed *basis.Basis, steps saga.StepCollection) (*saga.Saga, error) {
+	journal, err := journal.Derive(ctx, deployed)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := lock.Derive(ctx, deployed)
+	if err != nil {
+		return nil, err
+	}
+
+	collected, err := saga.Derive(ctx, steps, saga.Dependencies{
+		Journal: journal,
+		Locker:  lock,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	collected.Selection = saga.Selection{}
+	return collected, nil
+}
+
+func Orphaner(ctx context.Context) (*orphans.Orphans, error) {
+	basis, err := Basis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := basis.Caller()
+	if err != nil {
+		return nil, err
+	}
+
+	// orphans are deleted by the steps of the service they are tagged with, holding its lock
+	// as deploys and destroys would, or of the current service for untagged routes and
+	// integrations, which only need a client
+	deleter := func(ctx context.Context, resource orphans.Resource) error {
+		deployed := basis
+		if d := resource.Deployment; d != nil {
+			deployed = basis.Deployment(d.Owner, d.Repo, d.Branch, d.Sha, d.Service)
+		}
+
+		config, err := config.Derive(ctx, deployed)
+		if err != nil {
+			return err
+		}
+
+		steps, err := step.Derive(ctx, config)
+		if err != nil {
+			return err
+		}
+
+		if resource.Deployment == nil {
+			return orphans.Delete(ctx, steps, resource)
+		}
+
+		lock, err := lock.Derive(ctx, deployed)
+		if err != nil {
+			return err
+		}
+
+		locked, err := lock.Lock(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = orphans.Delete(locked, steps, resource)
+		if locked.Err() != nil && ctx.Err() == nil {
+			err = errors.Join(context.Cause(locked), err)
+		}
+
+		return errors.Join(err, lock.Unlock(context.WithoutCancel(ctx)))
+	}
+
+	return orphans.Derive(ctx, basis, orphans.NewScanner(caller.AwsConfig()), deleter)
+}
+
+func Output(ctx context.Context) (*report.Output, error) {
+	return report.Derive()
+}
+
+func Format(ctx context.Context) (*format.Format, error) {
+	return format.Derive()
+}
+
+func Lock(ctx context.Context) (*lock.Lock, error) {
+	basis, err := Basis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock.Derive(ctx, basis)
 }
 
 func Scaffold(ctx context.Context) (*scaffold.Scaffold, error) {
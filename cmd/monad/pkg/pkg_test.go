@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bkeane/monad/pkg/basis"
+	"github.com/bkeane/monad/pkg/step"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStep struct {
+	name      string
+	unmounted *[]string
+}
+
+func (f *fakeStep) Mount(ctx context.Context) error { return nil }
+
+func (f *fakeStep) Unmount(ctx context.Context) error {
+	*f.unmounted = append(*f.unmounted, f.name)
+	return nil
+}
+
+type fakeSteps map[string]step.Step
+
+var dependencies = map[string][]string{"lambda": {"iam"}}
+
+func (f fakeSteps) Names() []string                   { return []string{"iam", "lambda"} }
+func (f fakeSteps) Step(name string) step.Step        { return f[name] }
+func (f fakeSteps) Dependencies(name string) []string { return dependencies[name] }
+
+func TestCollected(t *testing.T) {
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "ran")
+	hooks := filepath.Join(dir, "hooks.yaml")
+	require.NoError(t, os.WriteFile(hooks, []byte("hooks:\n  - name: cleanup\n    action: destroy\n    when: pre\n    command: touch "+ran+"\n"), 0644))
+
+	t.Setenv("MONAD_HOOKS", hooks)
+	t.Setenv("MONAD_SKIP", "lambda")
+	t.Setenv("MONAD_JOURNAL", t.TempDir())
+
+	current, err := basis.Derive(context.Background())
+	require.NoError(t, err)
+
+	var unmounted []string
+	steps := fakeSteps{
+		"iam":    &fakeStep{name: "iam", unmounted: &unmounted},
+		"lambda": &fakeStep{name: "lambda", unmounted: &unmounted},
+	}
+
+	saga, err := collected(context.Background(), current.Deployment("acme", "shop", "feature", "abc123", "api"), steps)
+	require.NoError(t, err)
+
+	_, err = saga.Undo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lambda", "iam"}, unmounted, "--skip of the current checkout does not apply")
+	assert.NoFileExists(t, ran, "hooks of the current checkout do not run")
+}
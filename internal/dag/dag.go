@@ -0,0 +1,242 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Graph is a set of named nodes and the nodes each depends upon.
+// Iteration is deterministic and follows insertion order.
+type Graph struct {
+	names        []string
+	dependencies map[string][]string
+}
+
+func New() *Graph {
+	return &Graph{
+		dependencies: map[string][]string{},
+	}
+}
+
+// Add inserts a node along with the names of the nodes it depends upon
+func (g *Graph) Add(name string, dependencies ...string) error {
+	if _, exists := g.dependencies[name]; exists {
+		return fmt.Errorf("duplicate node %s", name)
+	}
+
+	g.names = append(g.names, name)
+	g.dependencies[name] = slices.Clone(dependencies)
+	return nil
+}
+
+// Names returns all nodes in insertion order
+func (g *Graph) Names() []string {
+	return slices.Clone(g.names)
+}
+
+// Has reports whether the graph contains the named node
+func (g *Graph) Has(name string) bool {
+	_, exists := g.dependencies[name]
+	return exists
+}
+
+// Dependencies returns the direct dependencies of the named node
+func (g *Graph) Dependencies(name string) []string {
+	return slices.Clone(g.dependencies[name])
+}
+
+// Dependents returns the nodes that directly depend upon the named node
+func (g *Graph) Dependents(name string) []string {
+	var dependents []string
+	for _, candidate := range g.names {
+		if slices.Contains(g.dependencies[candidate], name) {
+			dependents = append(dependents, candidate)
+		}
+	}
+	return dependents
+}
+
+// Reverse returns a graph in which every dependency points the other way
+func (g *Graph) Reverse() *Graph {
+	reversed := New()
+	for _, name := range g.names {
+		reversed.names = append(reversed.names, name)
+		reversed.dependencies[name] = g.Dependents(name)
+	}
+	return reversed
+}
+
+// Subset returns a graph of only the given nodes, dropping edges to nodes outside of it
+func (g *Graph) Subset(names []string) *Graph {
+	subset := New()
+	for _, name := range g.names {
+		if !slices.Contains(names, name) {
+			continue
+		}
+
+		var dependencies []string
+		for _, dependency := range g.dependencies[name] {
+			if slices.Contains(names, dependency) {
+				dependencies = append(dependencies, dependency)
+			}
+		}
+
+		subset.names = append(subset.names, name)
+		subset.dependencies[name] = dependencies
+	}
+	return subset
+}
+
+// Sort returns the nodes in topological order, dependencies first.
+// Unknown dependencies and cycles are reported as errors.
+func (g *Graph) Sort() ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := map[string]int{}
+	var sorted []string
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			start := slices.Index(path, name)
+			cycle := append(slices.Clone(path[start:]), name)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dependency := range g.dependencies[name] {
+			if !g.Has(dependency) {
+				return fmt.Errorf("%s depends on unknown %s", name, dependency)
+			}
+
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		sorted = append(sorted, name)
+		return nil
+	}
+
+	for _, name := range g.names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+// Walk calls fn for every node once all of its dependencies have succeeded.
+// Independent nodes run concurrently, bounded by limit when it is above zero.
+// Nodes whose dependencies failed are skipped. Errors are joined and returned.
+func (g *Graph) Walk(ctx context.Context, limit int, fn func(ctx context.Context, name string) error) error {
+	if _, err := g.Sort(); err != nil {
+		return err
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+
+	remaining := map[string]int{}
+	for _, name := range g.names {
+		remaining[name] = len(g.dependencies[name])
+	}
+
+	var ready []string
+	for _, name := range g.names {
+		if remaining[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	results := make(chan result)
+	failed := map[string]bool{}
+	running := 0
+	done := 0
+
+	var semaphore chan struct{}
+	if limit > 0 {
+		semaphore = make(chan struct{}, limit)
+	}
+
+	var wg sync.WaitGroup
+	var errs []error
+
+	for done < len(g.names) {
+		for _, name := range ready {
+			running++
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				if semaphore != nil {
+					semaphore <- struct{}{}
+					defer func() { <-semaphore }()
+				}
+				results <- result{name: name, err: fn(ctx, name)}
+			}(name)
+		}
+		ready = nil
+
+		if running == 0 {
+			break
+		}
+
+		completed := <-results
+		running--
+		done++
+
+		if completed.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", completed.name, completed.err))
+			done += g.skip(completed.name, failed)
+			continue
+		}
+
+		for _, dependent := range g.Dependents(completed.name) {
+			if failed[dependent] {
+				continue
+			}
+
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// skip marks every transitive dependent of name as failed and returns how many were newly marked
+func (g *Graph) skip(name string, failed map[string]bool) int {
+	count := 0
+	for _, dependent := range g.Dependents(name) {
+		if failed[dependent] {
+			continue
+		}
+
+		failed[dependent] = true
+		count++
+		count += g.skip(dependent, failed)
+	}
+	return count
+}
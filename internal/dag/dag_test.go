@@ -0,0 +1,178 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func saga(t *testing.T) *Graph {
+	g := New()
+	require.NoError(t, g.Add("iam"))
+	require.NoError(t, g.Add("cloudwatch"))
+	require.NoError(t, g.Add("lambda", "iam", "cloudwatch"))
+	require.NoError(t, g.Add("apigateway", "lambda"))
+	require.NoError(t, g.Add("eventbridge", "lambda"))
+	return g
+}
+
+func TestGraph_Sort(t *testing.T) {
+	sorted, err := saga(t).Sort()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iam", "cloudwatch", "lambda", "apigateway", "eventbridge"}, sorted)
+}
+
+func TestGraph_SortReverse(t *testing.T) {
+	sorted, err := saga(t).Reverse().Sort()
+	require.NoError(t, err)
+	assert.Less(t, indexOf(sorted, "apigateway"), indexOf(sorted, "lambda"))
+	assert.Less(t, indexOf(sorted, "eventbridge"), indexOf(sorted, "lambda"))
+	assert.Less(t, indexOf(sorted, "lambda"), indexOf(sorted, "iam"))
+}
+
+func TestGraph_SortCycle(t *testing.T) {
+	g := New()
+	require.NoError(t, g.Add("a", "b"))
+	require.NoError(t, g.Add("b", "c"))
+	require.NoError(t, g.Add("c", "a"))
+
+	_, err := g.Sort()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a -> b -> c -> a")
+}
+
+func TestGraph_SortUnknown(t *testing.T) {
+	g := New()
+	require.NoError(t, g.Add("a", "missing"))
+
+	_, err := g.Sort()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown missing")
+}
+
+func TestGraph_AddDuplicate(t *testing.T) {
+	g := New()
+	require.NoError(t, g.Add("a"))
+	assert.Error(t, g.Add("a"))
+}
+
+func TestGraph_Subset(t *testing.T) {
+	subset := saga(t).Subset([]string{"lambda", "apigateway"})
+	assert.Equal(t, []string{"lambda", "apigateway"}, subset.Names())
+	assert.Empty(t, subset.Dependencies("lambda"))
+	assert.Equal(t, []string{"lambda"}, subset.Dependencies("apigateway"))
+}
+
+func TestGraph_WalkOrder(t *testing.T) {
+	g := saga(t)
+
+	var mu sync.Mutex
+	var order []string
+	err := g.Walk(context.Background(), 0, func(ctx context.Context, name string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, order, 5)
+
+	for _, name := range g.Names() {
+		for _, dependency := range g.Dependencies(name) {
+			assert.Less(t, indexOf(order, dependency), indexOf(order, name))
+		}
+	}
+}
+
+func TestGraph_WalkConcurrent(t *testing.T) {
+	g := New()
+	require.NoError(t, g.Add("root"))
+	require.NoError(t, g.Add("a", "root"))
+	require.NoError(t, g.Add("b", "root"))
+
+	var active, peak int32
+	err := g.Walk(context.Background(), 0, func(ctx context.Context, name string) error {
+		current := atomic.AddInt32(&active, 1)
+		for {
+			previous := atomic.LoadInt32(&peak)
+			if current <= previous || atomic.CompareAndSwapInt32(&peak, previous, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), peak)
+}
+
+func TestGraph_WalkLimit(t *testing.T) {
+	g := New()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, g.Add(name))
+	}
+
+	var active, peak int32
+	err := g.Walk(context.Background(), 1, func(ctx context.Context, name string) error {
+		current := atomic.AddInt32(&active, 1)
+		if current > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, current)
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), peak)
+}
+
+func TestGraph_WalkSkipsDependentsOfFailure(t *testing.T) {
+	g := saga(t)
+
+	var mu sync.Mutex
+	var ran []string
+	err := g.Walk(context.Background(), 0, func(ctx context.Context, name string) error {
+		mu.Lock()
+		ran = append(ran, name)
+		mu.Unlock()
+
+		if name == "iam" {
+			return errors.New("denied")
+		}
+		return nil
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "iam: denied")
+	assert.ElementsMatch(t, []string{"iam", "cloudwatch"}, ran)
+}
+
+func TestGraph_WalkJoinsErrors(t *testing.T) {
+	g := New()
+	require.NoError(t, g.Add("a"))
+	require.NoError(t, g.Add("b"))
+
+	err := g.Walk(context.Background(), 0, func(ctx context.Context, name string) error {
+		return errors.New("failed")
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a: failed")
+	assert.Contains(t, err.Error(), "b: failed")
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -7,7 +7,11 @@ import (
 	"strings"
 
 	v5 "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
 )
 
 type Git struct {
@@ -66,6 +70,53 @@ func Parse(path string) (Git, error) {
 	return g, nil
 }
 
+// RemoteBranches returns the branch names of the named remote of the repository containing
+// path, or of the repository at the url given as remote when no such remote exists.
+// Https remotes are authenticated with GITHUB_TOKEN when set.
+func RemoteBranches(path string, remote string) ([]string, error) {
+	_, repo, err := find(path)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := []string{remote}
+	if named, err := repo.Remote(remote); err == nil {
+		urls = named.Config().URLs
+	}
+
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("remote %s has no url", remote)
+	}
+
+	list := v5.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: remote,
+		URLs: urls,
+	})
+
+	refs, err := list.List(&v5.ListOptions{Auth: auth(urls[0])})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches of %s: %w", remote, err)
+	}
+
+	var branches []string
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			branches = append(branches, ref.Name().Short())
+		}
+	}
+
+	return branches, nil
+}
+
+func auth(url string) transport.AuthMethod {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" || !strings.HasPrefix(url, "https://") {
+		return nil
+	}
+
+	return &http.BasicAuth{Username: "x-access-token", Password: token}
+}
+
 func find(path string) (root string, repo *v5.Repository, err error) {
 	// Validate initial path exists
 	if _, err := os.Stat(path); err != nil {
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
 	"text/template"
 
@@ -14,9 +15,9 @@ import (
 	"github.com/bkeane/monad/pkg/basis/registry"
 	"github.com/bkeane/monad/pkg/basis/resource"
 	"github.com/bkeane/monad/pkg/basis/service"
+	"github.com/bkeane/monad/pkg/format"
 
 	env "github.com/caarlos0/env/v11"
-	"github.com/charmbracelet/lipgloss/table"
 )
 
 //
@@ -25,6 +26,7 @@ import (
 
 type Basis struct {
 	Chdir         string `env:"MONAD_CHDIR" flag:"--chdir" usage:"Change working directory" hint:"path"`
+	Profile       string `env:"MONAD_PROFILE" flag:"--profile" usage:"Config file profile" hint:"name"`
 	GitBasis      *git.Basis
 	CallerBasis   *caller.Basis
 	ServiceBasis  *service.Basis
@@ -50,6 +52,10 @@ type TemplateData struct {
 		Name string
 	}
 
+	Profile struct {
+		Name string
+	}
+
 	Resource struct {
 		Name string
 		Path string
@@ -61,6 +67,24 @@ type TemplateData struct {
 	}
 }
 
+// Env returns the template data as environment variables, e.g. {{.Git.Sha}} as MONAD_GIT_SHA
+func (d TemplateData) Env() map[string]string {
+	vars := map[string]string{}
+
+	sections := reflect.ValueOf(d)
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		prefix := "MONAD_" + strings.ToUpper(sections.Type().Field(i).Name)
+
+		for j := 0; j < section.NumField(); j++ {
+			name := prefix + "_" + strings.ToUpper(section.Type().Field(j).Name)
+			vars[name] = section.Field(j).String()
+		}
+	}
+
+	return vars
+}
+
 //
 // Derive
 //
@@ -78,11 +102,39 @@ func Derive(ctx context.Context) (*Basis, error) {
 		if err := os.Chdir(basis.Chdir); err != nil {
 			return nil, fmt.Errorf("failed to change directory to %s: %w", basis.Chdir, err)
 		}
+
+		// Pin the directory so that deriving again does not change directory relative to it
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.Setenv("MONAD_CHDIR", wd); err != nil {
+			return nil, err
+		}
 	}
 
 	return basis, nil
 }
 
+// Deployment returns a basis for the deployment of the named service at the given branch
+// and sha of owner/repo, such as that of another branch, sharing the caller and defaults of b
+func (b *Basis) Deployment(owner, repo, branch, sha, name string) *Basis {
+	return &Basis{
+		Chdir:   b.Chdir,
+		Profile: b.Profile,
+		GitBasis: &git.Basis{
+			GitOwner:  owner,
+			GitRepo:   repo,
+			GitBranch: branch,
+			GitSha:    sha,
+		},
+		CallerBasis:  b.CallerBasis,
+		ServiceBasis: &service.Basis{ServiceName: name},
+		DefaultBasis: b.DefaultBasis,
+	}
+}
+
 //
 // Accessors
 //
@@ -191,32 +243,33 @@ func (b *Basis) Defaults() (*defaults.Basis, error) {
 // Templating
 //
 
-func (b *Basis) Render(input string) (string, error) {
+// Data returns the values available to templates
+func (b *Basis) Data() (TemplateData, error) {
 	data := TemplateData{}
 
 	caller, err := b.Caller()
 	if err != nil {
-		return "", err
+		return data, err
 	}
 
 	git, err := b.Git()
 	if err != nil {
-		return "", err
+		return data, err
 	}
 
 	service, err := b.Service()
 	if err != nil {
-		return "", err
+		return data, err
 	}
 
 	resource, err := b.Resource()
 	if err != nil {
-		return "", err
+		return data, err
 	}
 
 	registry, err := b.Registry()
 	if err != nil {
-		return "", err
+		return data, err
 	}
 
 	data.Account.Id = caller.AccountId()
@@ -226,11 +279,21 @@ func (b *Basis) Render(input string) (string, error) {
 	data.Git.Branch = git.Branch()
 	data.Git.Sha = git.Sha()
 	data.Service.Name = service.Name()
+	data.Profile.Name = b.Profile
 	data.Resource.Name = resource.Name()
 	data.Resource.Path = resource.Path()
 	data.Ecr.Id = registry.Id()
 	data.Ecr.Region = registry.Region()
 
+	return data, nil
+}
+
+func (b *Basis) Render(input string) (string, error) {
+	data, err := b.Data()
+	if err != nil {
+		return "", err
+	}
+
 	tmpl, err := template.New("template").Parse(input)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %w", err)
@@ -244,7 +307,14 @@ func (b *Basis) Render(input string) (string, error) {
 	return buf.String(), nil
 }
 
-func (b *Basis) Table() (string, error) {
+// Variable is a template variable along with its value
+type Variable struct {
+	Template string `json:"template" yaml:"template"`
+	Value    string `json:"value" yaml:"value"`
+}
+
+// Variables renders every template variable available to templates
+func (b *Basis) Variables() ([]Variable, error) {
 	vars := []string{
 		"{{.Account.Id}}",
 		"{{.Account.Region}}",
@@ -253,22 +323,49 @@ func (b *Basis) Table() (string, error) {
 		"{{.Git.Branch}}",
 		"{{.Git.Sha}}",
 		"{{.Service.Name}}",
+		"{{.Profile.Name}}",
 		"{{.Resource.Name}}",
 		"{{.Resource.Path}}",
 		"{{.Ecr.Id}}",
 		"{{.Ecr.Region}}",
 	}
 
-	tbl := table.New()
-	tbl.Headers("Template", "Value")
-
+	var variables []Variable
 	for _, v := range vars {
 		val, err := b.Render(v)
 		if err != nil {
-			return "", fmt.Errorf("failed to render %s: %w", v, err)
+			return nil, fmt.Errorf("failed to render %s: %w", v, err)
 		}
-		tbl.Row(v, strings.TrimSpace(val))
+		variables = append(variables, Variable{Template: v, Value: strings.TrimSpace(val)})
+	}
+
+	return variables, nil
+}
+
+// Rows returns the template variables as rows
+func (b *Basis) Rows() (format.Rows[Variable], error) {
+	variables, err := b.Variables()
+	if err != nil {
+		return format.Rows[Variable]{}, err
+	}
+
+	return format.Rows[Variable]{
+		Headers: []string{"Template", "Value"},
+		Records: variables,
+		Row:     func(v Variable) []string { return []string{v.Template, v.Value} },
+	}, nil
+}
+
+func (b *Basis) Table() (string, error) {
+	rows, err := b.Rows()
+	if err != nil {
+		return "", err
+	}
+
+	var values [][]string
+	for _, v := range rows.Records {
+		values = append(values, rows.Row(v))
 	}
 
-	return tbl.Render(), nil
+	return format.Table(rows.Headers, values), nil
 }
@@ -437,4 +437,22 @@ func setupMockAWSClient() *MockSTSClient {
 		}, nil)
 
 	return mockClient
-}
\ No newline at end of file
+}
+
+func TestTemplateData_Env(t *testing.T) {
+	var data TemplateData
+	data.Account.Id = "123456789012"
+	data.Git.Sha = "abc123"
+	data.Service.Name = "svc"
+	data.Profile.Name = "prod"
+	data.Resource.Path = "repo/main/svc"
+
+	vars := data.Env()
+	assert.Len(t, vars, 12)
+	assert.Equal(t, "123456789012", vars["MONAD_ACCOUNT_ID"])
+	assert.Equal(t, "abc123", vars["MONAD_GIT_SHA"])
+	assert.Equal(t, "svc", vars["MONAD_SERVICE_NAME"])
+	assert.Equal(t, "prod", vars["MONAD_PROFILE_NAME"])
+	assert.Equal(t, "repo/main/svc", vars["MONAD_RESOURCE_PATH"])
+	assert.Equal(t, "", vars["MONAD_ECR_REGION"])
+}
@@ -19,6 +19,7 @@ type Basis struct {
 	GitRepo   string `env:"MONAD_REPO" flag:"--repo" usage:"Git repository name" hint:"name"`
 	GitBranch string `env:"MONAD_BRANCH" flag:"--branch" usage:"Git branch name" hint:"name"`
 	GitSha    string `env:"MONAD_SHA" flag:"--sha" usage:"Git commit SHA" hint:"hash"`
+	sha       bool
 }
 
 //
@@ -33,6 +34,8 @@ func Derive() (*Basis, error) {
 		return nil, err
 	}
 
+	basis.sha = basis.GitSha != ""
+
 	basis.cwd, err = os.Getwd()
 	if err != nil {
 		return nil, err
@@ -110,3 +113,9 @@ func (g *Basis) Branch() string {
 func (g *Basis) Sha() string {
 	return g.GitSha
 }
+
+// ExplicitSha reports whether the sha was given by flag, env or profile rather than
+// read from HEAD
+func (g *Basis) ExplicitSha() bool {
+	return g.sha
+}
@@ -24,6 +24,7 @@ func TestDerive_WithEnvironmentVariables(t *testing.T) {
 	assert.Equal(t, "testrepo", basis.Repo())
 	assert.Equal(t, "testbranch", basis.Branch())
 	assert.Equal(t, "abcd1234", basis.Sha())
+	assert.True(t, basis.ExplicitSha())
 }
 
 func TestDerive_WithPartialEnvironmentVariables(t *testing.T) {
@@ -69,6 +70,7 @@ func TestDerive_FromGitRepository(t *testing.T) {
 	assert.NotEmpty(t, basis.Repo())
 	assert.NotEmpty(t, basis.Branch())
 	assert.NotEmpty(t, basis.Sha())
+	assert.False(t, basis.ExplicitSha(), "the sha of HEAD is not explicit")
 
 	// Validate the values are reasonable
 	assert.Greater(t, len(basis.Sha()), 10, "SHA should be reasonably long")
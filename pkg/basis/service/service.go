@@ -14,6 +14,7 @@ import (
 
 type Basis struct {
 	ServiceName string `env:"MONAD_SERVICE" flag:"--service" usage:"Service name" hint:"name"`
+	explicit    bool
 }
 
 //
@@ -28,6 +29,8 @@ func Derive() (*Basis, error) {
 		return nil, err
 	}
 
+	basis.explicit = basis.ServiceName != ""
+
 	if basis.ServiceName == "" {
 		wd, err := os.Getwd()
 		if err != nil {
@@ -62,3 +65,9 @@ func (s *Basis) Validate() error {
 func (s *Basis) Name() string {
 	return s.ServiceName
 }
+
+// Explicit reports whether the name was given by flag, env or profile rather than
+// derived from the working directory
+func (s *Basis) Explicit() bool {
+	return s.explicit
+}
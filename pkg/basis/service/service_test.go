@@ -442,4 +442,18 @@ func TestDerive_UnicodeDirectoryNames(t *testing.T) {
 			assert.Equal(t, tt.dirName, basis.Name())
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestDerive_Explicit(t *testing.T) {
+	t.Setenv("MONAD_SERVICE", "api")
+
+	basis, err := Derive()
+	require.NoError(t, err)
+	assert.True(t, basis.Explicit())
+
+	t.Setenv("MONAD_SERVICE", "")
+
+	basis, err = Derive()
+	require.NoError(t, err)
+	assert.False(t, basis.Explicit(), "names taken from the working directory are not explicit")
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/bkeane/monad/pkg/config/cloudwatch"
 	"github.com/bkeane/monad/pkg/config/ecr"
 	"github.com/bkeane/monad/pkg/config/eventbridge"
+	"github.com/bkeane/monad/pkg/config/health"
 	"github.com/bkeane/monad/pkg/config/iam"
 	"github.com/bkeane/monad/pkg/config/lambda"
 	"github.com/bkeane/monad/pkg/config/vpc"
@@ -44,6 +45,7 @@ type Config struct {
 	ApiGatewayConfig  *apigateway.Config
 	CloudWatchConfig  *cloudwatch.Config
 	EventBridgeConfig *eventbridge.Config
+	HealthConfig      *health.Config
 	IamConfig         *iam.Config
 	LambdaConfig      *lambda.Config
 	EcrConfig         *ecr.Config
@@ -191,3 +193,21 @@ func (c *Config) Vpc(ctx context.Context) (*vpc.Config, error) {
 
 	return c.VpcConfig, nil
 }
+
+func (c *Config) Health(ctx context.Context) (*health.Config, error) {
+	var err error
+
+	if c.HealthConfig == nil {
+		c.HealthConfig, err = health.Derive(ctx, c.basis)
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.HealthConfig.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.HealthConfig, nil
+}
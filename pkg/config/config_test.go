@@ -23,6 +23,7 @@ func TestDerive_Success(t *testing.T) {
 	assert.Nil(t, config.ApiGatewayConfig)
 	assert.Nil(t, config.CloudWatchConfig)
 	assert.Nil(t, config.EventBridgeConfig)
+	assert.Nil(t, config.HealthConfig)
 	assert.Nil(t, config.IamConfig)
 	assert.Nil(t, config.LambdaConfig)
 	assert.Nil(t, config.EcrConfig)
@@ -64,6 +65,10 @@ func TestConfig_LazyInitializationFailures(t *testing.T) {
 	_, err = config.Vpc(ctx)
 	assert.Error(t, err)
 	assert.Nil(t, config.VpcConfig)
+
+	_, err = config.Health(ctx)
+	assert.Error(t, err)
+	assert.Nil(t, config.HealthConfig)
 }
 
 func TestConfig_MultipleInstancesIndependent(t *testing.T) {
@@ -242,6 +247,7 @@ func TestConfig_IndependentComponents(t *testing.T) {
 		{"CloudWatch", func(ctx context.Context) (interface{}, error) { return config.CloudWatch(ctx) }},
 		{"Iam", func(ctx context.Context) (interface{}, error) { return config.Iam(ctx) }},
 		{"Vpc", func(ctx context.Context) (interface{}, error) { return config.Vpc(ctx) }},
+		{"Health", func(ctx context.Context) (interface{}, error) { return config.Health(ctx) }},
 	}
 
 	successCount := 0
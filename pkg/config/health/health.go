@@ -0,0 +1,111 @@
+package health
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bkeane/monad/pkg/basis/caller"
+	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+type Basis interface {
+	Caller() (*caller.Basis, error)
+}
+
+// Convention
+
+type Config struct {
+	HealthCheck   bool   `env:"MONAD_HEALTH" flag:"--health" usage:"Check the service after deploy, reverting the function on failure"`
+	HealthPath    string `env:"MONAD_HEALTH_PATH" flag:"--health-path" usage:"Path requested beneath each route (default /)" hint:"path"`
+	HealthPayload string `env:"MONAD_HEALTH_PAYLOAD" flag:"--health-payload" usage:"Invoke the function with this payload instead of requesting routes" hint:"json"`
+	HealthStatus  int32  `env:"MONAD_HEALTH_STATUS" flag:"--health-status" usage:"Expected response status (default 200)" hint:"code"`
+	HealthBody    string `env:"MONAD_HEALTH_BODY" flag:"--health-body" usage:"Text the response body must contain" hint:"text"`
+	HealthTimeout int32  `env:"MONAD_HEALTH_TIMEOUT" flag:"--health-timeout" usage:"Retry the check until it passes or this elapses (default 60)" hint:"sec"`
+	caller        *caller.Basis
+}
+
+//
+// Derive
+//
+
+func Derive(ctx context.Context, basis Basis) (*Config, error) {
+	var err error
+	var cfg Config
+
+	// Parse environment variables into struct fields
+	if err = env.Parse(&cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.caller, err = basis.Caller()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.HealthPath == "" {
+		cfg.HealthPath = "/"
+	}
+
+	if cfg.HealthStatus == 0 {
+		cfg.HealthStatus = 200
+	}
+
+	if cfg.HealthTimeout == 0 {
+		cfg.HealthTimeout = 60
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+//
+// Validate
+//
+
+func (c *Config) Validate() error {
+	return v.ValidateStruct(c,
+		v.Field(&c.caller, v.Required),
+		v.Field(&c.HealthPath, v.Required, v.By(absolute)),
+		v.Field(&c.HealthStatus, v.Required, v.Min(int32(100)), v.Max(int32(599))),
+		v.Field(&c.HealthTimeout, v.Required, v.Min(int32(1))),
+	)
+}
+
+// Accessors
+
+// Enabled reports whether deploys are checked
+func (c *Config) Enabled() bool { return c.HealthCheck }
+
+// Path returns the path requested beneath each route prefix
+func (c *Config) Path() string { return c.HealthPath }
+
+// Payload returns the payload the function is invoked with, or empty when routes are requested
+func (c *Config) Payload() string { return c.HealthPayload }
+
+// Status returns the expected response status
+func (c *Config) Status() int32 { return c.HealthStatus }
+
+// Body returns the text the response body must contain
+func (c *Config) Body() string { return c.HealthBody }
+
+// Timeout returns the seconds a failing check is retried for
+func (c *Config) Timeout() int32 { return c.HealthTimeout }
+
+// AwsConfig returns the AWS configuration requests are signed with
+func (c *Config) AwsConfig() aws.Config { return c.caller.AwsConfig() }
+
+//
+// Helpers
+//
+
+func absolute(value interface{}) error {
+	path, _ := value.(string)
+	if path != "" && path[0] != '/' {
+		return v.NewError("validation_absolute", "must begin with /")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package health
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bkeane/monad/pkg/basis/mock"
+)
+
+func TestDerive_Defaults(t *testing.T) {
+	setup := mock.NewTestSetup()
+	setup.Apply(t)
+
+	config, err := Derive(context.Background(), setup.Basis)
+	require.NoError(t, err)
+
+	assert.False(t, config.Enabled())
+	assert.Equal(t, "/", config.Path())
+	assert.Equal(t, int32(200), config.Status())
+	assert.Equal(t, int32(60), config.Timeout())
+	assert.Empty(t, config.Payload())
+	assert.Equal(t, "us-east-1", config.AwsConfig().Region)
+}
+
+func TestDerive_Overrides(t *testing.T) {
+	setup := mock.NewTestSetup()
+	setup.ApplyWithOverrides(t, map[string]string{
+		"MONAD_HEALTH":         "true",
+		"MONAD_HEALTH_PATH":    "/health",
+		"MONAD_HEALTH_PAYLOAD": `{"ping":true}`,
+		"MONAD_HEALTH_STATUS":  "204",
+		"MONAD_HEALTH_BODY":    "ok",
+		"MONAD_HEALTH_TIMEOUT": "5",
+	})
+
+	config, err := Derive(context.Background(), setup.Basis)
+	require.NoError(t, err)
+
+	assert.True(t, config.Enabled())
+	assert.Equal(t, "/health", config.Path())
+	assert.Equal(t, `{"ping":true}`, config.Payload())
+	assert.Equal(t, int32(204), config.Status())
+	assert.Equal(t, "ok", config.Body())
+	assert.Equal(t, int32(5), config.Timeout())
+}
+
+func TestDerive_Invalid(t *testing.T) {
+	setup := mock.NewTestSetup()
+	setup.ApplyWithOverrides(t, map[string]string{
+		"MONAD_HEALTH_PATH": "health",
+	})
+
+	_, err := Derive(context.Background(), setup.Basis)
+	assert.ErrorContains(t, err, "must begin with /")
+
+	setup.ApplyWithOverrides(t, map[string]string{
+		"MONAD_HEALTH_PATH":   "/",
+		"MONAD_HEALTH_STATUS": "42",
+	})
+
+	_, err = Derive(context.Background(), setup.Basis)
+	assert.ErrorContains(t, err, "HealthStatus")
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/bkeane/monad/pkg/basis/caller"
@@ -28,17 +30,21 @@ type Basis interface {
 //
 
 type Config struct {
-	client        *lambda.Client
-	LambdaRegion  string `env:"MONAD_LAMBDA_REGION"`
-	LambdaStorage int32  `env:"MONAD_STORAGE" flag:"--disk" usage:"Lambda storage" hint:"mb"`
-	LambdaMemory  int32  `env:"MONAD_MEMORY" flag:"--memory" usage:"Lambda memory" hint:"mb"`
-	LambdaTimeout int32  `env:"MONAD_TIMEOUT" flag:"--timeout" usage:"Lambda timeout" hint:"sec"`
-	LambdaRetries int32  `env:"MONAD_RETRIES" flag:"--retry" usage:"Lambda async invoke retries" hint:"count"`
-	LambdaEnvPath string `env:"MONAD_ENV" flag:"--env" usage:"Lambda env template file path" hint:"path"`
-	caller        *caller.Basis
-	defaults      *defaults.Basis
-	resource      *resource.Basis
-	env           map[string]string
+	client           *lambda.Client
+	LambdaRegion     string   `env:"MONAD_LAMBDA_REGION"`
+	LambdaStorage    int32    `env:"MONAD_STORAGE" flag:"--disk" usage:"Lambda storage" hint:"mb"`
+	LambdaMemory     int32    `env:"MONAD_MEMORY" flag:"--memory" usage:"Lambda memory" hint:"mb"`
+	LambdaTimeout    int32    `env:"MONAD_TIMEOUT" flag:"--timeout" usage:"Lambda timeout" hint:"sec"`
+	LambdaRetries    int32    `env:"MONAD_RETRIES" flag:"--retry" usage:"Lambda async invoke retries" hint:"count"`
+	LambdaEnvPath    string   `env:"MONAD_ENV" flag:"--env" usage:"Lambda env template file path" hint:"path"`
+	LambdaAlias      string   `env:"MONAD_ALIAS" flag:"--alias" usage:"Publish a version per deploy and point this alias at it" hint:"name"`
+	LambdaCanary     []string `env:"MONAD_CANARY" flag:"--canary" usage:"Alias traffic percentages shifted through before cutover" hint:"percent"`
+	LambdaCanaryWait int32    `env:"MONAD_CANARY_WAIT" flag:"--canary-wait" usage:"Wait at each canary percentage (default 60)" hint:"sec"`
+	caller           *caller.Basis
+	defaults         *defaults.Basis
+	resource         *resource.Basis
+	env              map[string]string
+	canary           []int32
 }
 
 //
@@ -90,6 +96,18 @@ func Derive(ctx context.Context, basis Basis) (*Config, error) {
 		cfg.LambdaRetries = int32(0)
 	}
 
+	if cfg.LambdaCanaryWait == 0 {
+		cfg.LambdaCanaryWait = int32(60)
+	}
+
+	for _, percent := range cfg.LambdaCanary {
+		weight, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(percent, "%")), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("canary percentage %q must be a number", percent)
+		}
+		cfg.canary = append(cfg.canary, int32(weight))
+	}
+
 	// Env derivation
 	var envTemplate string
 
@@ -134,6 +152,10 @@ func (c *Config) Validate() error {
 		v.Field(&c.LambdaTimeout, v.Required),
 		v.Field(&c.LambdaRetries, v.Min(int32(0))),
 		v.Field(&c.env, v.Required),
+		v.Field(&c.LambdaAlias, v.Match(aliasPattern).Error("must be letters, digits, - or _ and not only digits")),
+		v.Field(&c.LambdaCanary, v.When(c.LambdaAlias == "", v.Empty.Error("requires an alias"))),
+		v.Field(&c.LambdaCanaryWait, v.Min(int32(0))),
+		v.Field(&c.canary, v.Each(v.Min(int32(1)), v.Max(int32(99))), v.By(ascending)),
 	)
 }
 
@@ -170,6 +192,32 @@ func (c *Config) FunctionArn() string {
 		c.LambdaRegion, c.caller.AccountId(), c.FunctionName())
 }
 
+// Alias returns the alias pointed at each published version, or empty when $LATEST is deployed
+func (c *Config) Alias() string { return c.LambdaAlias }
+
+// Canary returns the percentages of alias traffic shifted to a new version before cutover
+func (c *Config) Canary() []int32 { return c.canary }
+
+// CanaryWait returns the seconds spent at each canary percentage
+func (c *Config) CanaryWait() int32 { return c.LambdaCanaryWait }
+
+// TargetArn returns the ARN integrations and rules invoke, qualified by the alias when there is one
+func (c *Config) TargetArn() string {
+	if c.LambdaAlias == "" {
+		return c.FunctionArn()
+	}
+	return c.FunctionArn() + ":" + c.LambdaAlias
+}
+
+// FunctionArns returns the unqualified function arn along with the alias arn when there is one,
+// as deploys made before the alias was configured target the unqualified function
+func (c *Config) FunctionArns() []string {
+	if c.LambdaAlias == "" {
+		return []string{c.FunctionArn()}
+	}
+	return []string{c.FunctionArn(), c.TargetArn()}
+}
+
 // Env returns a map derived from the given env document
 func (c *Config) Env() map[string]string {
 	return c.env
@@ -179,3 +227,20 @@ func (c *Config) Env() map[string]string {
 func (c *Config) Tags() map[string]string {
 	return c.resource.Tags()
 }
+
+//
+// Helpers
+//
+
+// aliasPattern matches lambda alias names, which may not be entirely numeric as versions are
+var aliasPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]*[a-zA-Z_-][a-zA-Z0-9_-]*$`)
+
+func ascending(value interface{}) error {
+	weights, _ := value.([]int32)
+	for i := 1; i < len(weights); i++ {
+		if weights[i] <= weights[i-1] {
+			return fmt.Errorf("must be ascending")
+		}
+	}
+	return nil
+}
@@ -225,4 +225,50 @@ func TestDerive_WithZeroValues(t *testing.T) {
 	assert.Equal(t, int32(3), config.Timeout(), "Should default timeout to 3")
 	assert.Equal(t, int32(512), config.EphemeralStorage(), "Should default storage to 512")
 	assert.Equal(t, int32(0), config.Retries(), "Retries can be 0")
-}
\ No newline at end of file
+}
+
+func TestDerive_Alias(t *testing.T) {
+	setup := mock.NewLambdaTestSetup()
+	setup.Apply(t)
+
+	config, err := Derive(context.Background(), setup.Basis)
+	require.NoError(t, err)
+	assert.Empty(t, config.Alias())
+	assert.Equal(t, config.FunctionArn(), config.TargetArn())
+	assert.Equal(t, []string{config.FunctionArn()}, config.FunctionArns())
+
+	setup.ApplyWithOverrides(t, map[string]string{
+		"MONAD_ALIAS":       "live",
+		"MONAD_CANARY":      "10,50%",
+		"MONAD_CANARY_WAIT": "30",
+	})
+
+	config, err = Derive(context.Background(), setup.Basis)
+	require.NoError(t, err)
+	assert.Equal(t, "live", config.Alias())
+	assert.Equal(t, config.FunctionArn()+":live", config.TargetArn())
+	assert.Equal(t, []string{config.FunctionArn(), config.FunctionArn() + ":live"}, config.FunctionArns())
+	assert.Equal(t, []int32{10, 50}, config.Canary())
+	assert.Equal(t, int32(30), config.CanaryWait())
+}
+
+func TestDerive_InvalidCanary(t *testing.T) {
+	setup := mock.NewLambdaTestSetup()
+
+	cases := map[string]map[string]string{
+		"requires an alias":   {"MONAD_CANARY": "10"},
+		"must be ascending":   {"MONAD_ALIAS": "live", "MONAD_CANARY": "50,10"},
+		"must be no greater":  {"MONAD_ALIAS": "live", "MONAD_CANARY": "100"},
+		"must be a number":    {"MONAD_ALIAS": "live", "MONAD_CANARY": "half"},
+		"and not only digits": {"MONAD_ALIAS": "42"},
+	}
+
+	for message, overrides := range cases {
+		t.Run(message, func(t *testing.T) {
+			setup.ApplyWithOverrides(t, overrides)
+
+			_, err := Derive(context.Background(), setup.Basis)
+			assert.ErrorContains(t, err, message)
+		})
+	}
+}
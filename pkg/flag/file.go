@@ -0,0 +1,368 @@
+package flag
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Global configuration for config file behavior
+var (
+	fileNames     []string
+	fileDir       func() string
+	profileEnv    string
+	profileBranch func() (string, error)
+	known         = make(map[string]bool)
+	loaded        = make(map[string]bool)
+)
+
+// Document is the content of a config file
+type Document struct {
+	Values    map[string]string            // flag values keyed by flag name
+	Profiles  map[string]map[string]string // flag values of each named profile
+	Branches  []Branch                     // profiles selected by branch, first match wins
+	DependsOn []string                     // services deployed before this one in a monorepo
+}
+
+// Branch selects a profile for the branches matching a pattern
+type Branch struct {
+	Match   string `yaml:"match" toml:"match"`
+	Profile string `yaml:"profile" toml:"profile"`
+}
+
+// Files enables reading flag values from the first of the named files found in the
+// directory returned by dir when Before runs. Values are keyed by flag name without
+// dashes and only fill flags that were neither given nor set in the environment,
+// nor tagged file:"-". Keys which are not the name of any flag returned by Flags are rejected.
+func Files(dir func() string, names ...string) {
+	fileDir = dir
+	fileNames = names
+}
+
+// Profiles enables selecting a profile of the config file by the value of env, or
+// failing that by matching the branch returned by branch against the file's branches.
+// The values of the selected profile override the file's own, and its name is
+// exported to env.
+func Profiles(env string, branch func() (string, error)) {
+	profileEnv = env
+	profileBranch = branch
+}
+
+// File reads the first of the named files found in dir.
+// Lists are joined with commas as they are when a slice flag is exported.
+// Returns the path of the file read, or an empty path and document when none exist.
+func File(dir string, names ...string) (string, *Document, error) {
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+
+		content, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		document, err := decode(path, content)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		return path, document, nil
+	}
+
+	return "", &Document{Values: map[string]string{}}, nil
+}
+
+// Select returns the name and values of the profile, or when empty that of the first
+// branch pattern matching the branch returned by branch. A pattern of * matches any
+// branch, otherwise patterns are matched as by path.Match. The values of the profile
+// are merged over the document's own. No profile is selected when none match.
+func (d *Document) Select(profile string, branch func() (string, error)) (string, map[string]string, error) {
+	if profile == "" && len(d.Branches) > 0 && branch != nil {
+		name, err := branch()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to resolve branch for profile: %w", err)
+		}
+
+		for _, b := range d.Branches {
+			matched, err := path.Match(b.Match, name)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid branch pattern %q: %w", b.Match, err)
+			}
+
+			if b.Match == "*" || matched {
+				profile = b.Profile
+				break
+			}
+		}
+	}
+
+	if profile == "" {
+		return "", d.Values, nil
+	}
+
+	overrides, ok := d.Profiles[profile]
+	if !ok {
+		return "", nil, fmt.Errorf("profile %s is not defined", profile)
+	}
+
+	values := make(map[string]string, len(d.Values)+len(overrides))
+	for key, value := range d.Values {
+		values[key] = value
+	}
+	for key, value := range overrides {
+		values[key] = value
+	}
+
+	return profile, values, nil
+}
+
+// Load exports values of the config file to the env vars of T's flags which are unset.
+// Uses the same type traversal as Flags to find flag-to-env mappings.
+// This function is kept for direct testing - use Files() and Before() for CLI integration.
+func Load[T any](values map[string]string) error {
+	visited := make(map[reflect.Type]bool)
+	var zero T
+	typ := reflect.TypeOf(zero)
+	return loadType(typ, visited, values)
+}
+
+// load reads the enabled config file and exports its values for the flags of typ
+func load(typ reflect.Type) error {
+	if len(fileNames) == 0 {
+		return nil
+	}
+
+	var dir string
+	if fileDir != nil {
+		dir = fileDir()
+	}
+
+	path, document, err := File(dir, fileNames...)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	var unknown []string
+	for _, values := range append([]map[string]string{document.Values}, slices.Collect(maps.Values(document.Profiles))...) {
+		for key := range values {
+			if !known[key] && !slices.Contains(unknown, key) {
+				unknown = append(unknown, key)
+			}
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys in %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	var profile string
+	if profileEnv != "" {
+		profile = os.Getenv(profileEnv)
+	}
+
+	profile, values, err := document.Select(profile, profileBranch)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if profile != "" && profileEnv != "" && os.Getenv(profileEnv) != profile {
+		if err := os.Setenv(profileEnv, profile); err != nil {
+			return fmt.Errorf("failed to export profile to %s: %w", profileEnv, err)
+		}
+		loaded[profileEnv] = true
+	}
+
+	return loadType(typ, make(map[reflect.Type]bool), values)
+}
+
+// loadType traverses a type definition to find flag-to-env mappings and export file values
+func loadType(typ reflect.Type, visited map[reflect.Type]bool, values map[string]string) error {
+	if typ == nil {
+		return nil
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+
+	if visited[typ] {
+		return nil
+	}
+	visited[typ] = true
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		flagTag := field.Tag.Get("flag")
+		envVar := field.Tag.Get("env")
+
+		// Flags and env take precedence, so only unset env vars are filled. Fields tagged
+		// file:"-" are never filled, so that a key may belong to the flag of one command only.
+		if flagTag != "" && flagTag != "-" && envVar != "" && field.Tag.Get("file") != "-" {
+			if _, ok := os.LookupEnv(envVar); !ok {
+				for _, name := range strings.Split(flagTag, ",") {
+					key := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(name), "--"), "-")
+					if value, ok := values[key]; ok {
+						if err := os.Setenv(envVar, value); err != nil {
+							return fmt.Errorf("failed to export %s to %s: %w", key, envVar, err)
+						}
+						loaded[envVar] = true
+						break
+					}
+				}
+			}
+		}
+
+		fieldType := field.Type
+
+		if fieldType.Kind() == reflect.Ptr && fieldType.Elem().Kind() == reflect.Struct {
+			if err := loadType(fieldType.Elem(), visited, values); err != nil {
+				return err
+			}
+		} else if fieldType.Kind() == reflect.Struct {
+			if err := loadType(fieldType, visited, values); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Environ returns the environment without the env vars exported from a config file,
+// such that a child process started in another directory reads its own config file.
+func Environ() []string {
+	var environ []string
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if !loaded[name] {
+			environ = append(environ, variable)
+		}
+	}
+	return environ
+}
+
+// decode parses yaml or toml content by file extension into a document
+func decode(path string, content []byte) (*Document, error) {
+	var raw struct {
+		Profiles  map[string]map[string]any `yaml:"profiles" toml:"profiles"`
+		Branches  []Branch                  `yaml:"branches" toml:"branches"`
+		DependsOn []string                  `yaml:"depends_on" toml:"depends_on"`
+	}
+	var values map[string]any
+
+	switch filepath.Ext(path) {
+	case ".toml":
+		if err := toml.Unmarshal(content, &raw); err != nil {
+			return nil, err
+		}
+		if err := toml.Unmarshal(content, &values); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &raw); err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file type %s", filepath.Ext(path))
+	}
+
+	delete(values, "profiles")
+	delete(values, "branches")
+	delete(values, "depends_on")
+
+	document := &Document{
+		Profiles:  make(map[string]map[string]string, len(raw.Profiles)),
+		Branches:  raw.Branches,
+		DependsOn: raw.DependsOn,
+	}
+
+	var err error
+	if document.Values, err = flatten(values); err != nil {
+		return nil, err
+	}
+
+	for name, profile := range raw.Profiles {
+		if document.Profiles[name], err = flatten(profile); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+
+	for _, branch := range document.Branches {
+		if _, ok := document.Profiles[branch.Profile]; !ok {
+			return nil, fmt.Errorf("branch %s: profile %s is not defined", branch.Match, branch.Profile)
+		}
+	}
+
+	return document, nil
+}
+
+// flatten returns the string representation of values keyed by flag name
+func flatten(document map[string]any) (map[string]string, error) {
+	values := make(map[string]string, len(document))
+	for key, value := range document {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				s, err := scalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", key, err)
+				}
+				items = append(items, s)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			s, err := scalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			values[key] = s
+		}
+	}
+
+	return values, nil
+}
+
+// scalar returns the string representation of a string, number or bool value
+func scalar(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("expected a string, number, bool or list of them, got %T", value)
+	}
+}
@@ -0,0 +1,392 @@
+package flag
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+type FileConfig struct {
+	Name    string   `env:"TEST_FILE_NAME" flag:"--name" usage:"Application name"`
+	Port    int      `env:"TEST_FILE_PORT" flag:"--port" usage:"Server port"`
+	Verbose bool     `env:"TEST_FILE_VERBOSE" flag:"--verbose" usage:"Verbose mode"`
+	Routes  []string `env:"TEST_FILE_ROUTES" flag:"--route" usage:"Routes"`
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test reading yaml and toml files into flag keyed values
+func TestFile(t *testing.T) {
+	yamlDir := t.TempDir()
+	writeFile(t, yamlDir, "monad.yaml", "name: app\nport: 8080\nverbose: true\nroute:\n  - GET /a\n  - ANY /b/{proxy+}\n")
+
+	tomlDir := t.TempDir()
+	writeFile(t, tomlDir, "monad.toml", "name = \"app\"\nport = 8080\nverbose = true\nroute = [\"GET /a\", \"ANY /b/{proxy+}\"]\n")
+
+	for _, dir := range []string{yamlDir, tomlDir} {
+		path, document, err := File(dir, "monad.yaml", "monad.toml")
+		if err != nil {
+			t.Fatalf("File failed: %v", err)
+		}
+
+		if filepath.Dir(path) != dir {
+			t.Errorf("Expected file in %s, got %s", dir, path)
+		}
+
+		expected := map[string]string{
+			"name":    "app",
+			"port":    "8080",
+			"verbose": "true",
+			"route":   "GET /a,ANY /b/{proxy+}",
+		}
+
+		for key, value := range expected {
+			if document.Values[key] != value {
+				t.Errorf("%s: expected %s=%q, got %q", path, key, value, document.Values[key])
+			}
+		}
+	}
+}
+
+// Test a missing file yields no values
+func TestFileMissing(t *testing.T) {
+	path, document, err := File(t.TempDir(), "monad.yaml", "monad.toml")
+	if err != nil {
+		t.Fatalf("File failed: %v", err)
+	}
+
+	if path != "" || len(document.Values) != 0 {
+		t.Errorf("Expected no file, got %s with %v", path, document.Values)
+	}
+}
+
+// Test nested values are rejected
+func TestFileNested(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "monad.yaml", "name:\n  nested: value\n")
+
+	if _, _, err := File(dir, "monad.yaml"); err == nil {
+		t.Error("Expected error for nested value")
+	}
+}
+
+// Test reading profiles and the branches selecting them
+func TestFileProfiles(t *testing.T) {
+	yamlDir := t.TempDir()
+	writeFile(t, yamlDir, "monad.yaml", `port: 8080
+profiles:
+  prod:
+    port: 443
+    route: [ANY /prod]
+  dev:
+    verbose: true
+branches:
+  - match: main
+    profile: prod
+  - match: "*"
+    profile: dev
+`)
+
+	tomlDir := t.TempDir()
+	writeFile(t, tomlDir, "monad.toml", `port = 8080
+
+[profiles.prod]
+port = 443
+route = ["ANY /prod"]
+
+[profiles.dev]
+verbose = true
+
+[[branches]]
+match = "main"
+profile = "prod"
+
+[[branches]]
+match = "*"
+profile = "dev"
+`)
+
+	for _, dir := range []string{yamlDir, tomlDir} {
+		path, document, err := File(dir, "monad.yaml", "monad.toml")
+		if err != nil {
+			t.Fatalf("File failed: %v", err)
+		}
+
+		if len(document.Values) != 1 || document.Values["port"] != "8080" {
+			t.Errorf("%s: expected only port=8080 outside profiles, got %v", path, document.Values)
+		}
+
+		if document.Profiles["prod"]["port"] != "443" || document.Profiles["prod"]["route"] != "ANY /prod" {
+			t.Errorf("%s: unexpected prod profile %v", path, document.Profiles["prod"])
+		}
+
+		if document.Profiles["dev"]["verbose"] != "true" {
+			t.Errorf("%s: unexpected dev profile %v", path, document.Profiles["dev"])
+		}
+
+		expected := []Branch{{Match: "main", Profile: "prod"}, {Match: "*", Profile: "dev"}}
+		if len(document.Branches) != 2 || document.Branches[0] != expected[0] || document.Branches[1] != expected[1] {
+			t.Errorf("%s: expected branches %v, got %v", path, expected, document.Branches)
+		}
+	}
+}
+
+// Test branches naming an undefined profile are rejected
+func TestFileUndefinedProfile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "monad.yaml", "branches:\n  - match: main\n    profile: prod\n")
+
+	if _, _, err := File(dir, "monad.yaml"); err == nil {
+		t.Error("Expected error for undefined profile")
+	}
+}
+
+// Test selecting a profile by name or branch
+func TestDocumentSelect(t *testing.T) {
+	document := &Document{
+		Values: map[string]string{"port": "8080", "name": "app"},
+		Profiles: map[string]map[string]string{
+			"prod":    {"port": "443"},
+			"feature": {"port": "8081"},
+			"dev":     {},
+		},
+		Branches: []Branch{
+			{Match: "main", Profile: "prod"},
+			{Match: "feature/*", Profile: "feature"},
+			{Match: "*", Profile: "dev"},
+		},
+	}
+
+	tests := []struct {
+		profile  string
+		branch   string
+		expected string
+		port     string
+	}{
+		{profile: "", branch: "main", expected: "prod", port: "443"},
+		{profile: "", branch: "feature/login", expected: "feature", port: "8081"},
+		{profile: "", branch: "fix/nested/name", expected: "dev", port: "8080"},
+		{profile: "feature", branch: "main", expected: "feature", port: "8081"},
+	}
+
+	for _, test := range tests {
+		profile, values, err := document.Select(test.profile, func() (string, error) {
+			return test.branch, nil
+		})
+		if err != nil {
+			t.Fatalf("Select failed: %v", err)
+		}
+
+		if profile != test.expected {
+			t.Errorf("%s: expected profile %s, got %s", test.branch, test.expected, profile)
+		}
+
+		if values["port"] != test.port || values["name"] != "app" {
+			t.Errorf("%s: expected port=%s name=app, got %v", test.branch, test.port, values)
+		}
+	}
+
+	if _, _, err := document.Select("staging", nil); err == nil {
+		t.Error("Expected error for undefined profile")
+	}
+
+	document.Branches = document.Branches[:1]
+	profile, values, err := document.Select("", func() (string, error) { return "other", nil })
+	if err != nil || profile != "" || values["port"] != "8080" {
+		t.Errorf("Expected no profile for unmatched branch, got %s %v %v", profile, values, err)
+	}
+}
+
+// Test Before resolves flag > env > file
+func TestBeforeFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "monad.yaml", "name: file\nport: 9090\nverbose: true\nroute: [GET /file]\n")
+
+	Files(func() string { return dir }, "monad.yaml")
+	defer Files(nil)
+
+	for _, key := range []string{"TEST_FILE_NAME", "TEST_FILE_PORT", "TEST_FILE_VERBOSE", "TEST_FILE_ROUTES"} {
+		os.Unsetenv(key)
+		defer os.Unsetenv(key)
+	}
+	os.Setenv("TEST_FILE_PORT", "7070")
+
+	cmd := &cli.Command{
+		Name:   "test",
+		Flags:  Flags[FileConfig](),
+		Before: Before[FileConfig](),
+		Action: func(ctx context.Context, c *cli.Command) error {
+			return nil
+		},
+	}
+
+	err := cmd.Run(context.Background(), []string{"test", "--name", "flag"})
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"TEST_FILE_NAME":    "flag",
+		"TEST_FILE_PORT":    "7070",
+		"TEST_FILE_VERBOSE": "true",
+		"TEST_FILE_ROUTES":  "GET /file",
+	}
+
+	for key, value := range expected {
+		if got := os.Getenv(key); got != value {
+			t.Errorf("Expected %s=%s, got %s", key, value, got)
+		}
+	}
+}
+
+type SkippedConfig struct {
+	Name   string `env:"TEST_FILE_NAME" flag:"--name" usage:"Application name"`
+	Output string `env:"TEST_FILE_OUTPUT" flag:"--output" usage:"Output format" file:"-"`
+}
+
+// Test Before leaves fields tagged file:"-" unset
+func TestBeforeFileSkipped(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "monad.yaml", "name: file\noutput: markdown\n")
+
+	Files(func() string { return dir }, "monad.yaml")
+	defer Files(nil)
+
+	for _, key := range []string{"TEST_FILE_NAME", "TEST_FILE_OUTPUT"} {
+		os.Unsetenv(key)
+		defer os.Unsetenv(key)
+	}
+
+	cmd := &cli.Command{
+		Name:   "test",
+		Flags:  Flags[SkippedConfig](),
+		Before: Before[SkippedConfig](),
+		Action: func(ctx context.Context, c *cli.Command) error {
+			return nil
+		},
+	}
+
+	if err := cmd.Run(context.Background(), []string{"test"}); err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	if got := os.Getenv("TEST_FILE_NAME"); got != "file" {
+		t.Errorf("Expected TEST_FILE_NAME=file, got %s", got)
+	}
+
+	if value, ok := os.LookupEnv("TEST_FILE_OUTPUT"); ok {
+		t.Errorf("Expected TEST_FILE_OUTPUT unset, got %s", value)
+	}
+}
+
+// Test Before exports the values and name of the profile selected by branch
+func TestBeforeFileProfile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "monad.yaml", "name: file\nport: 8080\nprofiles:\n  prod:\n    port: 443\nbranches:\n  - match: main\n    profile: prod\n")
+
+	Files(func() string { return dir }, "monad.yaml")
+	Profiles("TEST_FILE_PROFILE", func() (string, error) { return "main", nil })
+	defer Files(nil)
+	defer Profiles("", nil)
+
+	for _, key := range []string{"TEST_FILE_NAME", "TEST_FILE_PORT", "TEST_FILE_PROFILE"} {
+		os.Unsetenv(key)
+		defer os.Unsetenv(key)
+	}
+
+	cmd := &cli.Command{
+		Name:   "test",
+		Flags:  Flags[FileConfig](),
+		Before: Before[FileConfig](),
+		Action: func(ctx context.Context, c *cli.Command) error {
+			return nil
+		},
+	}
+
+	err := cmd.Run(context.Background(), []string{"test"})
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"TEST_FILE_NAME":    "file",
+		"TEST_FILE_PORT":    "443",
+		"TEST_FILE_PROFILE": "prod",
+	}
+
+	for key, value := range expected {
+		if got := os.Getenv(key); got != value {
+			t.Errorf("Expected %s=%s, got %s", key, value, got)
+		}
+	}
+}
+
+// Test Before rejects keys which are not flags
+func TestBeforeFileUnknown(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "monad.yaml", "name: file\nnmae: typo\n")
+
+	Files(func() string { return dir }, "monad.yaml")
+	defer Files(nil)
+
+	os.Unsetenv("TEST_FILE_NAME")
+	defer os.Unsetenv("TEST_FILE_NAME")
+
+	cmd := &cli.Command{
+		Name:   "test",
+		Flags:  Flags[FileConfig](),
+		Before: Before[FileConfig](),
+		Action: func(ctx context.Context, c *cli.Command) error {
+			return nil
+		},
+	}
+
+	err := cmd.Run(context.Background(), []string{"test"})
+	if err == nil || !strings.Contains(err.Error(), "nmae") {
+		t.Errorf("Expected unknown key error naming nmae, got %v", err)
+	}
+}
+
+// Test Environ leaves out env vars exported from the config file
+func TestEnviron(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "monad.yaml", "name: file\n")
+
+	Files(func() string { return dir }, "monad.yaml")
+	defer Files(nil)
+
+	os.Unsetenv("TEST_FILE_NAME")
+	defer os.Unsetenv("TEST_FILE_NAME")
+	os.Setenv("TEST_ENVIRON_KEPT", "7070")
+	defer os.Unsetenv("TEST_ENVIRON_KEPT")
+
+	cmd := &cli.Command{
+		Name:   "test",
+		Flags:  Flags[FileConfig](),
+		Before: Before[FileConfig](),
+		Action: func(ctx context.Context, c *cli.Command) error {
+			return nil
+		},
+	}
+
+	if err := cmd.Run(context.Background(), []string{"test"}); err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	environ := strings.Join(Environ(), "\n")
+	if strings.Contains(environ, "TEST_FILE_NAME=") {
+		t.Error("Expected TEST_FILE_NAME from the config file to be left out")
+	}
+	if !strings.Contains(environ, "TEST_ENVIRON_KEPT=7070") {
+		t.Error("Expected TEST_ENVIRON_KEPT from the environment to be kept")
+	}
+}
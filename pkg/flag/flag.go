@@ -176,6 +176,7 @@ func parseType(typ reflect.Type, visited map[reflect.Type]bool) []cli.Flag {
 			
 			for i, part := range flagParts {
 				cleanPart := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(part, "--"), "-"))
+				known[cleanPart] = true
 				if i == 0 {
 					cleanFlagName = cleanPart
 				} else {
@@ -339,7 +340,8 @@ func createFlag(fieldType reflect.Type, name, usage, envVar, defaultValue, hint
 	return nil
 }
 
-// Before returns a BeforeFunc that exports all set CLI flags to corresponding environment variables.
+// Before returns a BeforeFunc that exports all set CLI flags to corresponding environment variables,
+// then fills unset ones from the config file enabled with Files.
 // Uses the same type traversal as Flags to find flag-to-env mappings.
 // Returns a function compatible with cli.Command.Before.
 func Before[T any]() func(context.Context, *cli.Command) (context.Context, error) {
@@ -347,7 +349,10 @@ func Before[T any]() func(context.Context, *cli.Command) (context.Context, error
 		visited := make(map[reflect.Type]bool)
 		var zero T
 		typ := reflect.TypeOf(zero)
-		err := exportType(typ, visited, cmd)
+		if err := exportType(typ, visited, cmd); err != nil {
+			return ctx, err
+		}
+		err := load(typ)
 		return ctx, err
 	}
 }
@@ -0,0 +1,181 @@
+package format
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/charmbracelet/lipgloss/table"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+	"gopkg.in/yaml.v3"
+)
+
+//
+// Rows
+//
+
+// Rows are the records of a listing along with how each is rendered as a row
+type Rows[T any] struct {
+	Headers []string
+	Records []T
+	Row     func(T) []string // complete values, as written to csv and tsv
+	Short   func(T) []string // abbreviated values for the table, Row when nil
+}
+
+//
+// Format
+//
+
+// Format writes listings. Config files set output for deploy reports, so --output of a
+// listing is read from its own env var and never from a config file.
+type Format struct {
+	FormatOutput   string `env:"MONAD_LIST_OUTPUT" flag:"--output" usage:"Write the listing as table, json, yaml, csv or tsv (default table)" hint:"format" file:"-"`
+	FormatTemplate string `env:"MONAD_FORMAT" flag:"--format" usage:"Write each record with a Go template, e.g. '{{.Service}} {{.Branch}}'" hint:"template"`
+}
+
+//
+// Derive
+//
+
+func Derive() (*Format, error) {
+	var format Format
+
+	if err := env.Parse(&format); err != nil {
+		return nil, err
+	}
+
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &format, nil
+}
+
+//
+// Validations
+//
+
+func (f *Format) Validate() error {
+	return v.ValidateStruct(f,
+		v.Field(&f.FormatOutput, v.In("table", "json", "yaml", "csv", "tsv")),
+		v.Field(&f.FormatTemplate, v.By(parse)),
+	)
+}
+
+func parse(value interface{}) error {
+	if _, err := New(value.(string)); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	return nil
+}
+
+// New parses a record template. Values may be written as JSON with {{json .}}.
+func New(text string) (*template.Template, error) {
+	return template.New("format").Funcs(template.FuncMap{
+		"json": func(value any) (string, error) {
+			b, err := json.Marshal(value)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+//
+// Write
+//
+
+// Write writes the rows in the requested format. A template takes precedence over --output.
+func Write[T any](w io.Writer, f *Format, rows Rows[T]) error {
+	if f.FormatTemplate != "" {
+		tmpl, err := New(f.FormatTemplate)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range rows.Records {
+			if err := tmpl.Execute(w, record); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	switch f.FormatOutput {
+	case "", "table":
+		short := rows.Short
+		if short == nil {
+			short = rows.Row
+		}
+
+		var values [][]string
+		for _, record := range rows.Records {
+			values = append(values, short(record))
+		}
+
+		_, err := fmt.Fprintln(w, Table(rows.Headers, values))
+		return err
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records(rows.Records))
+	case "yaml":
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(records(rows.Records)); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "csv", "tsv":
+		writer := csv.NewWriter(w)
+		if f.FormatOutput == "tsv" {
+			writer.Comma = '\t'
+		}
+
+		headers := make([]string, len(rows.Headers))
+		for i, header := range rows.Headers {
+			headers[i] = strings.ToLower(header)
+		}
+
+		if err := writer.Write(headers); err != nil {
+			return err
+		}
+
+		for _, record := range rows.Records {
+			if err := writer.Write(rows.Row(record)); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unsupported output format: %s", f.FormatOutput)
+	}
+}
+
+// Table renders the headers and rows as a table
+func Table(headers []string, rows [][]string) string {
+	tbl := table.New()
+	tbl.Headers(headers...)
+
+	for _, row := range rows {
+		tbl.Row(row...)
+	}
+
+	return tbl.Render()
+}
+
+// records encodes an empty listing as an empty list rather than null
+func records[T any](records []T) []T {
+	if records == nil {
+		return []T{}
+	}
+	return records
+}
@@ -0,0 +1,87 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type service struct {
+	Name   string `json:"name" yaml:"name"`
+	Branch string `json:"branch" yaml:"branch"`
+	Sha    string `json:"sha" yaml:"sha"`
+}
+
+func rows(services ...service) Rows[service] {
+	return Rows[service]{
+		Headers: []string{"Name", "Branch", "Sha"},
+		Records: services,
+		Row:     func(s service) []string { return []string{s.Name, s.Branch, s.Sha} },
+		Short:   func(s service) []string { return []string{s.Name, s.Branch, s.Sha[:7]} },
+	}
+}
+
+func write(t *testing.T, f *Format, rows Rows[service]) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, f, rows))
+	return buf.String()
+}
+
+var services = []service{
+	{Name: "api", Branch: "main", Sha: "0123456789abcdef"},
+	{Name: "worker", Branch: "feat, wip", Sha: "fedcba9876543210"},
+}
+
+func TestWrite_Table(t *testing.T) {
+	out := write(t, &Format{}, rows(services...))
+	assert.Contains(t, out, "Name")
+	assert.Contains(t, out, "0123456")
+	assert.NotContains(t, out, "0123456789abcdef", "tables show the abbreviated row")
+}
+
+func TestWrite_Json(t *testing.T) {
+	out := write(t, &Format{FormatOutput: "json"}, rows(services...))
+	assert.JSONEq(t, `[
+		{"name": "api", "branch": "main", "sha": "0123456789abcdef"},
+		{"name": "worker", "branch": "feat, wip", "sha": "fedcba9876543210"}
+	]`, out)
+
+	assert.Equal(t, "[]\n", write(t, &Format{FormatOutput: "json"}, rows()))
+}
+
+func TestWrite_Yaml(t *testing.T) {
+	out := write(t, &Format{FormatOutput: "yaml"}, rows(services[0]))
+	assert.Equal(t, "- name: api\n  branch: main\n  sha: 0123456789abcdef\n", out)
+
+	assert.Equal(t, "[]\n", write(t, &Format{FormatOutput: "yaml"}, rows()))
+}
+
+func TestWrite_Delimited(t *testing.T) {
+	out := write(t, &Format{FormatOutput: "csv"}, rows(services...))
+	assert.Equal(t, "name,branch,sha\napi,main,0123456789abcdef\nworker,\"feat, wip\",fedcba9876543210\n", out)
+
+	out = write(t, &Format{FormatOutput: "tsv"}, rows(services...))
+	assert.Equal(t, "name\tbranch\tsha\napi\tmain\t0123456789abcdef\nworker\tfeat, wip\tfedcba9876543210\n", out)
+}
+
+func TestWrite_Template(t *testing.T) {
+	f := &Format{FormatOutput: "json", FormatTemplate: "{{.Name}}@{{.Branch}}"}
+	assert.Equal(t, "api@main\nworker@feat, wip\n", write(t, f, rows(services...)), "templates take precedence over --output")
+
+	f = &Format{FormatTemplate: "{{json .Name}}"}
+	assert.Equal(t, "\"api\"\n", write(t, f, rows(services[0])))
+
+	f = &Format{FormatTemplate: "{{.Missing}}"}
+	assert.Error(t, Write(&bytes.Buffer{}, f, rows(services...)))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, (&Format{}).Validate())
+	assert.NoError(t, (&Format{FormatOutput: "tsv", FormatTemplate: "{{.Name}}"}).Validate())
+	assert.Error(t, (&Format{FormatOutput: "xml"}).Validate())
+	assert.ErrorContains(t, (&Format{FormatTemplate: "{{.Name"}).Validate(), "invalid template")
+}
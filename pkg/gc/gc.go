@@ -0,0 +1,246 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bkeane/monad/internal/git"
+	gitbasis "github.com/bkeane/monad/pkg/basis/git"
+	"github.com/bkeane/monad/pkg/state"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/charmbracelet/lipgloss/table"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/rs/zerolog/log"
+)
+
+//
+// Dependencies
+//
+
+type Basis interface {
+	Git() (*gitbasis.Basis, error)
+}
+
+// Lister returns every deployed service
+type Lister interface {
+	All(ctx context.Context) ([]*state.StateMetadata, error)
+}
+
+// Destroyer destroys a deployment with the full saga undo
+type Destroyer func(ctx context.Context, deployment *state.StateMetadata) error
+
+type Status string
+
+const (
+	Stale     Status = "stale"
+	Destroyed Status = "destroyed"
+	Failed    Status = "failed"
+)
+
+// Result records what became of a stale deployment
+type Result struct {
+	Deployment *state.StateMetadata
+	Status     Status
+	Error      string
+}
+
+//
+// GC
+//
+
+type GC struct {
+	GcDryRun  bool   `env:"MONAD_DRY_RUN" flag:"--dry-run" usage:"Report stale deployments without destroying them"`
+	GcMinAge  int32  `env:"MONAD_GC_MIN_AGE" flag:"--min-age" usage:"Keep stale deployments deployed within this many days (default 0)" hint:"days"`
+	GcRemote  string `env:"MONAD_GC_REMOTE" flag:"--remote" usage:"Git remote whose branches are kept (default origin)" hint:"name|url"`
+	owner     string
+	repo      string
+	branch    string
+	lister    Lister
+	destroyer Destroyer
+	branches  func() ([]string, error)
+	now       func() time.Time
+}
+
+//
+// Derive
+//
+
+func Derive(ctx context.Context, basis Basis, lister Lister, destroyer Destroyer) (*GC, error) {
+	var err error
+	var gc GC
+
+	if err = env.Parse(&gc); err != nil {
+		return nil, err
+	}
+
+	if gc.GcRemote == "" {
+		gc.GcRemote = "origin"
+	}
+
+	git, err := basis.Git()
+	if err != nil {
+		return nil, err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	gc.owner = git.Owner()
+	gc.repo = git.Repo()
+	gc.branch = git.Branch()
+	gc.lister = lister
+	gc.destroyer = destroyer
+	gc.branches = gc.remote(cwd)
+	gc.now = time.Now
+
+	if err = gc.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &gc, nil
+}
+
+func (g *GC) Validate() error {
+	return v.ValidateStruct(g,
+		v.Field(&g.GcMinAge, v.Min(int32(0))),
+		v.Field(&g.owner, v.Required),
+		v.Field(&g.repo, v.Required),
+		v.Field(&g.lister, v.Required),
+	)
+}
+
+//
+// GC
+//
+
+// Do destroys every stale deployment of the repo, or only reports them on a dry run.
+// Every stale deployment is attempted regardless of the others failing.
+func (g *GC) Do(ctx context.Context) ([]Result, error) {
+	deployments, err := g.lister.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	branches, err := g.branches()
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty listing more likely means a misconfigured remote than a repo without branches
+	if len(branches) == 0 {
+		return nil, fmt.Errorf("remote %s has no branches, refusing to collect", g.GcRemote)
+	}
+
+	var results []Result
+	var errs []error
+
+	for _, deployment := range g.stale(deployments, branches) {
+		result := Result{Deployment: deployment, Status: Stale}
+
+		event := log.Info().
+			Str("service", deployment.Service).
+			Str("branch", deployment.Branch).
+			Str("sha", deployment.Sha).
+			Time("deployed", deployment.Modified)
+
+		if g.GcDryRun {
+			event.Msg("stale")
+			results = append(results, result)
+			continue
+		}
+
+		event.Msg("destroy")
+
+		if err := g.destroyer(ctx, deployment); err != nil {
+			err = fmt.Errorf("%s of %s: %w", deployment.Service, deployment.Branch, err)
+			log.Error().Err(err).Msg("destroy failed")
+			result.Status = Failed
+			result.Error = err.Error()
+			errs = append(errs, err)
+		} else {
+			result.Status = Destroyed
+		}
+
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		log.Info().Msg("no stale deployments")
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// stale returns the deployments of the repo whose branch no longer exists on the remote,
+// last deployed at least --min-age days ago, ordered by branch then service.
+// The current branch is never stale, as it may not have been pushed yet.
+func (g *GC) stale(deployments []*state.StateMetadata, branches []string) []*state.StateMetadata {
+	age := time.Duration(g.GcMinAge) * 24 * time.Hour
+
+	var stale []*state.StateMetadata
+	for _, deployment := range deployments {
+		if deployment.Owner != g.owner || deployment.Repo != g.repo {
+			continue
+		}
+
+		if deployment.Branch == g.branch || slices.Contains(branches, deployment.Branch) {
+			continue
+		}
+
+		if g.now().Sub(deployment.Modified) < age {
+			continue
+		}
+
+		stale = append(stale, deployment)
+	}
+
+	slices.SortFunc(stale, func(a, b *state.StateMetadata) int {
+		if c := strings.Compare(a.Branch, b.Branch); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Service, b.Service)
+	})
+
+	return stale
+}
+
+// remote lists the branches of the remote of the repository containing dir
+func (g *GC) remote(dir string) func() ([]string, error) {
+	return func() ([]string, error) {
+		return git.RemoteBranches(dir, g.GcRemote)
+	}
+}
+
+//
+// Table
+//
+
+// Table renders the results as a table
+func Table(results []Result) string {
+	tbl := table.New()
+	tbl.Headers("Service", "Branch", "Sha", "Deployed", "Status")
+
+	for _, result := range results {
+		sha := result.Deployment.Sha
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+
+		deployed := ""
+		if !result.Deployment.Modified.IsZero() {
+			deployed = result.Deployment.Modified.Format(time.DateOnly)
+		}
+
+		tbl.Row(result.Deployment.Service, result.Deployment.Branch, sha, deployed, string(result.Status))
+	}
+
+	return tbl.Render()
+}
@@ -0,0 +1,165 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bkeane/monad/pkg/state"
+
+	v5 "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeLister []*state.StateMetadata
+
+func (f fakeLister) All(ctx context.Context) ([]*state.StateMetadata, error) {
+	return f, nil
+}
+
+func deployment(service, repo, branch string, age time.Duration) *state.StateMetadata {
+	return &state.StateMetadata{
+		Service:  service,
+		Owner:    "acme",
+		Repo:     repo,
+		Branch:   branch,
+		Sha:      "0123456789abcdef",
+		Modified: now.Add(-age),
+	}
+}
+
+func collector(deployments []*state.StateMetadata, branches []string, destroyer Destroyer) *GC {
+	return &GC{
+		GcRemote:  "origin",
+		owner:     "acme",
+		repo:      "shop",
+		branch:    "wip",
+		lister:    fakeLister(deployments),
+		destroyer: destroyer,
+		branches:  func() ([]string, error) { return branches, nil },
+		now:       func() time.Time { return now },
+	}
+}
+
+func deployments() []*state.StateMetadata {
+	day := 24 * time.Hour
+	return []*state.StateMetadata{
+		deployment("api", "shop", "main", 30*day),
+		deployment("web", "shop", "feature-b", 10*day),
+		deployment("api", "shop", "feature-b", 10*day),
+		deployment("api", "shop", "feature-a", 2*day),
+		deployment("api", "shop", "wip", 30*day),
+		deployment("api", "other", "feature-b", 30*day),
+	}
+}
+
+func TestStale(t *testing.T) {
+	g := collector(nil, nil, nil)
+
+	stale := g.stale(deployments(), []string{"main"})
+	require.Len(t, stale, 3)
+	assert.Equal(t, "feature-a", stale[0].Branch)
+	assert.Equal(t, "api", stale[1].Service)
+	assert.Equal(t, "feature-b", stale[1].Branch)
+	assert.Equal(t, "web", stale[2].Service)
+
+	g.GcMinAge = 7
+	stale = g.stale(deployments(), []string{"main"})
+	require.Len(t, stale, 2)
+	assert.Equal(t, "feature-b", stale[0].Branch)
+	assert.Equal(t, "feature-b", stale[1].Branch)
+}
+
+func TestDo_DryRun(t *testing.T) {
+	destroyed := 0
+	g := collector(deployments(), []string{"main", "feature-a"}, func(ctx context.Context, d *state.StateMetadata) error {
+		destroyed++
+		return nil
+	})
+	g.GcDryRun = true
+
+	results, err := g.Do(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, Stale, results[0].Status)
+	assert.Zero(t, destroyed)
+}
+
+func TestDo_Destroy(t *testing.T) {
+	var destroyed []string
+	g := collector(deployments(), []string{"main"}, func(ctx context.Context, d *state.StateMetadata) error {
+		destroyed = append(destroyed, d.Service+"@"+d.Branch)
+		if d.Branch == "feature-a" {
+			return errors.New("route conflict")
+		}
+		return nil
+	})
+
+	results, err := g.Do(context.Background())
+	assert.EqualError(t, err, "api of feature-a: route conflict")
+	assert.Equal(t, []string{"api@feature-a", "api@feature-b", "web@feature-b"}, destroyed)
+
+	require.Len(t, results, 3)
+	assert.Equal(t, Failed, results[0].Status)
+	assert.Equal(t, Destroyed, results[1].Status)
+	assert.Equal(t, Destroyed, results[2].Status)
+	assert.Contains(t, Table(results), "0123456")
+}
+
+func TestDo_NoBranches(t *testing.T) {
+	g := collector(deployments(), nil, func(ctx context.Context, d *state.StateMetadata) error {
+		t.Fatal("nothing should be destroyed")
+		return nil
+	})
+
+	_, err := g.Do(context.Background())
+	assert.ErrorContains(t, err, "refusing to collect")
+}
+
+func TestRemote(t *testing.T) {
+	// upstream is a repository with a commit on main and two feature branches
+	upstream := t.TempDir()
+	repo, err := v5.PlainInit(upstream, false)
+	require.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+
+	hash, err := worktree.Commit("initial", &v5.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "test", Email: "test@example.com", When: now},
+	})
+	require.NoError(t, err)
+
+	for _, branch := range []string{"main", "feature-a", "feature-b"} {
+		require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), hash)))
+	}
+
+	// bare is the remote the local clone tracks as origin
+	bare := t.TempDir()
+	_, err = v5.PlainClone(bare, true, &v5.CloneOptions{URL: upstream, Mirror: true})
+	require.NoError(t, err)
+
+	local := t.TempDir()
+	clone, err := v5.PlainInit(local, false)
+	require.NoError(t, err)
+	_, err = clone.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{bare}})
+	require.NoError(t, err)
+
+	g := &GC{GcRemote: "origin"}
+	branches, err := g.remote(local)()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"main", "feature-a", "feature-b", "master"}, branches)
+
+	g.GcRemote = upstream
+	branches, err = g.remote(local)()
+	require.NoError(t, err)
+	assert.Contains(t, branches, "feature-b")
+}
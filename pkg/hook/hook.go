@@ -0,0 +1,323 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bkeane/monad/pkg/basis"
+
+	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+//
+// Dependencies
+//
+
+type Basis interface {
+	Data() (basis.TemplateData, error)
+	Render(string) (string, error)
+}
+
+//
+// Hook
+//
+
+const (
+	Pre  = "pre"
+	Post = "post"
+)
+
+// Http describes a request made by a hook. A non-2xx response fails the hook.
+type Http struct {
+	Url     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+}
+
+// Hook runs a shell command or http request before or after a step, or around the whole saga when no step is named
+type Hook struct {
+	Name    string `yaml:"name"`
+	When    string `yaml:"when"`
+	Action  string `yaml:"action"`
+	Step    string `yaml:"step"`
+	Command string `yaml:"command"`
+	Http    *Http  `yaml:"http"`
+	Timeout int32  `yaml:"timeout"`
+}
+
+func (h *Hook) Validate() error {
+	return v.ValidateStruct(h,
+		v.Field(&h.Name, v.Required),
+		v.Field(&h.When, v.Required, v.In(Pre, Post)),
+		v.Field(&h.Action, v.Required, v.In("deploy", "destroy")),
+		v.Field(&h.Command, v.When(h.Http == nil, v.Required.Error("or http is required")), v.When(h.Http != nil, v.Empty.Error("cannot be combined with http"))),
+		v.Field(&h.Http, v.When(h.Http != nil, v.By(func(any) error {
+			return v.ValidateStruct(h.Http,
+				v.Field(&h.Http.Url, v.Required),
+			)
+		}))),
+		v.Field(&h.Timeout, v.Min(int32(0))),
+	)
+}
+
+// Event identifies the point of a saga at which hooks run
+type Event struct {
+	Action string
+	When   string
+	Step   string
+}
+
+func (e Event) String() string {
+	if e.Step == "" {
+		return fmt.Sprintf("%s-%s", e.When, e.Action)
+	}
+	return fmt.Sprintf("%s-%s %s", e.When, e.Action, e.Step)
+}
+
+//
+// Hooks
+//
+
+type Hooks struct {
+	HooksPath string `env:"MONAD_HOOKS" flag:"--hooks" usage:"Hooks template file path" hint:"path"`
+	hooks     []Hook
+	env       map[string]string
+}
+
+//
+// Derive
+//
+
+func Derive(ctx context.Context, basis Basis) (*Hooks, error) {
+	var err error
+	var hooks Hooks
+
+	if err = env.Parse(&hooks); err != nil {
+		return nil, err
+	}
+
+	if hooks.HooksPath == "" {
+		return &hooks, nil
+	}
+
+	bytes, err := os.ReadFile(hooks.HooksPath)
+	if err != nil {
+		return nil, err
+	}
+
+	templated, err := basis.Render(string(bytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to render hooks template: %w", err)
+	}
+
+	var file struct {
+		Hooks []Hook `yaml:"hooks"`
+	}
+
+	if err = yaml.Unmarshal([]byte(templated), &file); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks: %w", err)
+	}
+
+	data, err := basis.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(file.Hooks, data.Env())
+}
+
+// New returns hooks that run with the given environment variables
+func New(hooks []Hook, env map[string]string) (*Hooks, error) {
+	for i := range hooks {
+		if hooks[i].Action == "" {
+			hooks[i].Action = "deploy"
+		}
+	}
+
+	h := &Hooks{
+		hooks: hooks,
+		env:   env,
+	}
+
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *Hooks) Validate() error {
+	for _, hook := range h.hooks {
+		if err := hook.Validate(); err != nil {
+			return fmt.Errorf("hook %s: %w", hook.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Steps returns the names of the steps that hooks are attached to
+func (h *Hooks) Steps() []string {
+	var steps []string
+	for _, hook := range h.hooks {
+		if hook.Step != "" && !slices.Contains(steps, hook.Step) {
+			steps = append(steps, hook.Step)
+		}
+	}
+	return steps
+}
+
+// Run runs the hooks of event in declaration order, stopping at the first failure.
+// Outputs are keyed by step and passed on as MONAD_<STEP>_<OUTPUT> variables.
+func (h *Hooks) Run(ctx context.Context, event Event, outputs map[string]map[string]string) error {
+	for _, hook := range h.hooks {
+		if hook.Action != event.Action || hook.When != event.When || hook.Step != event.Step {
+			continue
+		}
+
+		vars := h.vars(event, outputs)
+
+		log.Info().
+			Str("action", "run").
+			Str("hook", hook.Name).
+			Str("event", event.String()).
+			Msg("hook")
+
+		var err error
+		if hook.Http != nil {
+			err = hook.request(ctx, vars)
+		} else {
+			err = hook.command(ctx, vars)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s hook %s failed: %w", event, hook.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (h *Hooks) vars(event Event, outputs map[string]map[string]string) map[string]string {
+	vars := maps.Clone(h.env)
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	vars["MONAD_HOOK_ACTION"] = event.Action
+	vars["MONAD_HOOK_WHEN"] = event.When
+	vars["MONAD_HOOK_STEP"] = event.Step
+
+	for step, values := range outputs {
+		for key, value := range values {
+			vars[variable("MONAD", step, key)] = value
+		}
+	}
+
+	return vars
+}
+
+//
+// Execution
+//
+
+func (h *Hook) command(ctx context.Context, vars map[string]string) error {
+	ctx, cancel := h.context(ctx)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", h.Command)
+	}
+
+	// stdout is reserved for reports, so hook output is written to stderr alongside the logs
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for _, key := range slices.Sorted(maps.Keys(vars)) {
+		cmd.Env = append(cmd.Env, key+"="+vars[key])
+	}
+
+	return cmd.Run()
+}
+
+func (h *Hook) request(ctx context.Context, vars map[string]string) error {
+	ctx, cancel := h.context(ctx)
+	defer cancel()
+
+	method := h.Http.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	body := []byte(h.Http.Body)
+	if h.Http.Body == "" && method != http.MethodGet {
+		var err error
+		if body, err = json.Marshal(vars); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, h.Http.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if h.Http.Body == "" && method != http.MethodGet {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	for key, value := range h.Http.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("%s %s returned %d: %s", method, h.Http.Url, response.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+func (h *Hook) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(h.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// variable joins parts into an environment variable name, e.g. MONAD_LAMBDA_FUNCTION_ARN
+func variable(parts ...string) string {
+	name := strings.ToUpper(strings.Join(parts, "_"))
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,96 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHook_Validate(t *testing.T) {
+	_, err := New([]Hook{{Name: "smoke", When: Post, Command: "true"}}, nil)
+	assert.NoError(t, err)
+
+	_, err = New([]Hook{{Name: "smoke", When: "during", Command: "true"}}, nil)
+	assert.ErrorContains(t, err, "When: must be a valid value")
+
+	_, err = New([]Hook{{Name: "smoke", When: Post}}, nil)
+	assert.ErrorContains(t, err, "or http is required")
+
+	_, err = New([]Hook{{Name: "smoke", When: Post, Command: "true", Http: &Http{Url: "http://localhost"}}}, nil)
+	assert.ErrorContains(t, err, "cannot be combined with http")
+}
+
+func TestHooks_RunCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+
+	hooks, err := New([]Hook{
+		{Name: "smoke", When: Post, Step: "lambda", Command: "echo $MONAD_SERVICE $MONAD_HOOK_WHEN $MONAD_LAMBDA_FUNCTION_ARN > " + out},
+		{Name: "other", When: Pre, Step: "lambda", Command: "exit 1"},
+	}, map[string]string{"MONAD_SERVICE": "svc"})
+	require.NoError(t, err)
+
+	outputs := map[string]map[string]string{
+		"lambda": {"function_arn": "arn:aws:lambda:us-west-2:123:function:svc"},
+	}
+
+	require.NoError(t, hooks.Run(context.Background(), Event{Action: "deploy", When: Post, Step: "lambda"}, outputs))
+
+	written, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "svc post arn:aws:lambda:us-west-2:123:function:svc\n", string(written))
+
+	err = hooks.Run(context.Background(), Event{Action: "deploy", When: Pre, Step: "lambda"}, outputs)
+	assert.ErrorContains(t, err, "pre-deploy lambda hook other failed")
+
+	// hooks of other actions are not run
+	assert.NoError(t, hooks.Run(context.Background(), Event{Action: "destroy", When: Pre, Step: "lambda"}, outputs))
+}
+
+func TestHooks_RunHttp(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "nope", http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "token", r.Header.Get("Authorization"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	hooks, err := New([]Hook{
+		{Name: "notify", When: Post, Http: &Http{Url: server.URL, Headers: map[string]string{"Authorization": "token"}}},
+		{Name: "gate", When: Pre, Http: &Http{Url: server.URL + "/fail"}},
+	}, map[string]string{"MONAD_SERVICE": "svc"})
+	require.NoError(t, err)
+
+	require.NoError(t, hooks.Run(context.Background(), Event{Action: "deploy", When: Post}, nil))
+	assert.Equal(t, "svc", received["MONAD_SERVICE"])
+	assert.Equal(t, "post", received["MONAD_HOOK_WHEN"])
+
+	err = hooks.Run(context.Background(), Event{Action: "deploy", When: Pre}, nil)
+	assert.ErrorContains(t, err, "returned 503: nope")
+}
+
+func TestHooks_Steps(t *testing.T) {
+	hooks, err := New([]Hook{
+		{Name: "a", When: Pre, Step: "lambda", Command: "true"},
+		{Name: "b", When: Post, Step: "lambda", Command: "true"},
+		{Name: "c", When: Post, Command: "true"},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"lambda"}, hooks.Steps())
+}
+
+func TestVariable(t *testing.T) {
+	assert.Equal(t, "MONAD_LAMBDA_FUNCTION_ARN", variable("MONAD", "lambda", "function_arn"))
+	assert.Equal(t, "MONAD_API_GATEWAY_URL", variable("MONAD", "api-gateway", "url"))
+}
@@ -0,0 +1,122 @@
+package inventory
+
+import (
+	"fmt"
+	"strings"
+)
+
+//
+// Resource
+//
+
+// Attribute is a single named value of a deployed resource
+type Attribute struct {
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// Resource is a deployed resource along with the attributes worth knowing about it
+type Resource struct {
+	Type       string      `json:"type" yaml:"type"`
+	Name       string      `json:"name" yaml:"name"`
+	Attributes []Attribute `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// Set records an attribute of the resource. Empty values are omitted.
+func (r *Resource) Set(key, value string) *Resource {
+	if value != "" {
+		r.Attributes = append(r.Attributes, Attribute{Key: key, Value: value})
+	}
+	return r
+}
+
+// Get returns the value of the named attribute, or empty if it was not set
+func (r *Resource) Get(key string) string {
+	for _, attribute := range r.Attributes {
+		if attribute.Key == key {
+			return attribute.Value
+		}
+	}
+	return ""
+}
+
+//
+// Inventory
+//
+
+// Inventory collects the resources a step has deployed
+type Inventory struct {
+	Step      string      `json:"step" yaml:"step"`
+	Resources []*Resource `json:"resources" yaml:"resources"`
+}
+
+func New(step string) *Inventory {
+	return &Inventory{Step: step, Resources: []*Resource{}}
+}
+
+// Add records a deployed resource and returns it so that its attributes can be set
+func (i *Inventory) Add(resource, name string) *Resource {
+	r := &Resource{Type: resource, Name: name}
+	i.Resources = append(i.Resources, r)
+	return r
+}
+
+// Empty reports whether the step has nothing deployed
+func (i *Inventory) Empty() bool {
+	return len(i.Resources) == 0
+}
+
+// String renders the inventory as an indented list of resources and their attributes
+func (i *Inventory) String() string {
+	var b strings.Builder
+
+	b.WriteString(i.Step)
+	b.WriteString("\n")
+
+	if i.Empty() {
+		b.WriteString("  (not deployed)\n")
+		return b.String()
+	}
+
+	for _, resource := range i.Resources {
+		fmt.Fprintf(&b, "  %s %s\n", resource.Type, resource.Name)
+
+		for _, attribute := range resource.Attributes {
+			if !strings.Contains(attribute.Value, "\n") {
+				fmt.Fprintf(&b, "      %s: %s\n", attribute.Key, attribute.Value)
+				continue
+			}
+
+			fmt.Fprintf(&b, "      %s:\n", attribute.Key)
+			for _, line := range strings.Split(attribute.Value, "\n") {
+				fmt.Fprintf(&b, "        %s\n", line)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// Markdown renders the inventories as a table of resources and their attributes
+func Markdown(inventories []*Inventory) string {
+	var b strings.Builder
+
+	b.WriteString("| Step | Resource | Name | Attribute | Value |\n")
+	b.WriteString("|------|----------|------|-----------|-------|\n")
+
+	for _, inventory := range inventories {
+		for _, resource := range inventory.Resources {
+			if len(resource.Attributes) == 0 {
+				fmt.Fprintf(&b, "| %s | %s | `%s` | | |\n", inventory.Step, resource.Type, resource.Name)
+				continue
+			}
+
+			for _, attribute := range resource.Attributes {
+				value := strings.ReplaceAll(attribute.Value, "\n", " ")
+				fmt.Fprintf(&b, "| %s | %s | `%s` | %s | `%s` |\n", inventory.Step, resource.Type, resource.Name, attribute.Key, value)
+			}
+		}
+	}
+
+	return b.String()
+}
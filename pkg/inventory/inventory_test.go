@@ -0,0 +1,45 @@
+package inventory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResource_SetOmitsEmptyValues(t *testing.T) {
+	i := New("lambda")
+
+	i.Add("function", "svc").
+		Set("memory", "128").
+		Set("alias", "")
+
+	assert.Equal(t, []Attribute{{Key: "memory", Value: "128"}}, i.Resources[0].Attributes)
+	assert.Equal(t, "128", i.Resources[0].Get("memory"))
+	assert.Empty(t, i.Resources[0].Get("alias"))
+}
+
+func TestInventory_String(t *testing.T) {
+	i := New("iam")
+	assert.Contains(t, i.String(), "(not deployed)")
+
+	i.Add("role", "svc").Set("arn", "arn:aws:iam::123456789012:role/svc")
+	i.Add("policy", "svc").Set("document", "{\n  \"a\": 1\n}")
+
+	assert.Equal(t, `iam
+  role svc
+      arn: arn:aws:iam::123456789012:role/svc
+  policy svc
+      document:
+        {
+          "a": 1
+        }
+`, i.String())
+}
+
+func TestMarkdown(t *testing.T) {
+	i := New("cloudwatch")
+	i.Add("group", "/aws/lambda/svc").Set("retention", "14")
+
+	markdown := Markdown([]*Inventory{i})
+	assert.Contains(t, markdown, "| cloudwatch | group | `/aws/lambda/svc` | retention | `14` |")
+}
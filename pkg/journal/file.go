@@ -0,0 +1,81 @@
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File stores each journal as a JSON lines file within a directory
+type File struct {
+	dir string
+}
+
+func NewFile(dir string) *File {
+	return &File{dir: dir}
+}
+
+func (f *File) Append(ctx context.Context, key string, entries ...Entry) error {
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path(key), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	return file.Sync()
+}
+
+func (f *File) Read(ctx context.Context, key string) ([]Entry, error) {
+	file, err := os.Open(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return decode(file)
+}
+
+func (f *File) path(key string) string {
+	return filepath.Join(f.dir, key+".jsonl")
+}
+
+// decode reads JSON lines, ignoring a truncated final line left by a crash
+func decode(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,353 @@
+package journal
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bkeane/monad/pkg/basis/caller"
+	"github.com/bkeane/monad/pkg/basis/git"
+	"github.com/bkeane/monad/pkg/basis/resource"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/charmbracelet/lipgloss/table"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+//
+// Dependencies
+//
+
+type Basis interface {
+	Caller() (*caller.Basis, error)
+	Git() (*git.Basis, error)
+	Resource() (*resource.Basis, error)
+}
+
+// Backend persists the entries of a journal under a key
+type Backend interface {
+	Append(ctx context.Context, key string, entries ...Entry) error
+	Read(ctx context.Context, key string) ([]Entry, error)
+}
+
+//
+// Entry
+//
+
+type Event string
+
+const (
+	Started    Event = "started"
+	Succeeded  Event = "succeeded"
+	Failed     Event = "failed"
+	Resumed    Event = "resumed"
+	RolledBack Event = "rolled_back"
+)
+
+// Entry is a single transition of a run or of one of its steps.
+// Entries without a step describe the run itself.
+type Entry struct {
+	Run     string            `json:"run"`
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Sha     string            `json:"sha"`
+	Step    string            `json:"step,omitempty"`
+	Event   Event             `json:"event"`
+	Error   string            `json:"error,omitempty"`
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// Run summarises the entries sharing a run id
+type Run struct {
+	Id       string
+	Action   string
+	Sha      string
+	Started  time.Time
+	Finished time.Time
+	Event    Event
+	Steps    map[string]Event
+	Outputs  map[string]map[string]string
+}
+
+//
+// Journal
+//
+
+type Journal struct {
+	JournalPath string `env:"MONAD_JOURNAL" flag:"--journal" usage:"Journal directory or s3://bucket/prefix (default user cache)" hint:"path|uri"`
+	backend     Backend
+	key         string
+	sha         string
+	mu          sync.Mutex
+	run         string
+	action      string
+}
+
+//
+// Derive
+//
+
+func Derive(ctx context.Context, basis Basis) (*Journal, error) {
+	var err error
+	var journal Journal
+
+	if err = env.Parse(&journal); err != nil {
+		return nil, err
+	}
+
+	resource, err := basis.Resource()
+	if err != nil {
+		return nil, err
+	}
+
+	git, err := basis.Git()
+	if err != nil {
+		return nil, err
+	}
+
+	journal.key = resource.Name()
+	journal.sha = git.Sha()
+
+	if journal.JournalPath == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		journal.JournalPath = filepath.Join(cache, "monad", "journal")
+	}
+
+	if strings.HasPrefix(journal.JournalPath, "s3://") {
+		caller, err := basis.Caller()
+		if err != nil {
+			return nil, err
+		}
+
+		journal.backend, err = NewS3(caller.AwsConfig(), journal.JournalPath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		journal.backend = NewFile(journal.JournalPath)
+	}
+
+	if err = journal.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &journal, nil
+}
+
+// New returns a journal of the given key and sha written to backend
+func New(backend Backend, key, sha string) *Journal {
+	return &Journal{
+		backend: backend,
+		key:     key,
+		sha:     sha,
+	}
+}
+
+// At returns a journal of the same key recording runs of another sha, such as a rollback to an earlier deploy
+func (j *Journal) At(sha string) *Journal {
+	return &Journal{
+		JournalPath: j.JournalPath,
+		backend:     j.backend,
+		key:         j.key,
+		sha:         sha,
+	}
+}
+
+func (j *Journal) Validate() error {
+	return v.ValidateStruct(j,
+		v.Field(&j.backend, v.Required),
+		v.Field(&j.key, v.Required),
+		v.Field(&j.sha, v.Required),
+	)
+}
+
+//
+// Recording
+//
+
+// Begin starts a new run of the given action, e.g. deploy or destroy
+func (j *Journal) Begin(ctx context.Context, action string) error {
+	j.mu.Lock()
+	j.run = time.Now().UTC().Format("20060102T150405.000000Z")
+	j.action = action
+	j.mu.Unlock()
+
+	return j.append(ctx, Entry{Event: Started})
+}
+
+// Record appends a step transition to the current run
+func (j *Journal) Record(ctx context.Context, step string, event Event, err error, outputs map[string]string) error {
+	entry := Entry{
+		Step:    step,
+		Event:   event,
+		Outputs: outputs,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	return j.append(ctx, entry)
+}
+
+// End closes the current run as succeeded or failed
+func (j *Journal) End(ctx context.Context, err error) error {
+	entry := Entry{Event: Succeeded}
+
+	if err != nil {
+		entry.Event = Failed
+		entry.Error = err.Error()
+	}
+
+	return j.append(ctx, entry)
+}
+
+func (j *Journal) append(ctx context.Context, entry Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.run == "" {
+		return fmt.Errorf("journal run has not begun")
+	}
+
+	entry.Run = j.run
+	entry.Time = time.Now().UTC()
+	entry.Action = j.action
+	entry.Sha = j.sha
+
+	return j.backend.Append(ctx, j.key, entry)
+}
+
+//
+// Reading
+//
+
+// Runs returns every run of the journal, oldest first
+func (j *Journal) Runs(ctx context.Context) ([]Run, error) {
+	entries, err := j.backend.Read(ctx, j.key)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []Run
+	index := map[string]int{}
+
+	for _, entry := range entries {
+		i, ok := index[entry.Run]
+		if !ok {
+			i = len(runs)
+			index[entry.Run] = i
+			runs = append(runs, Run{
+				Id:      entry.Run,
+				Action:  entry.Action,
+				Sha:     entry.Sha,
+				Started: entry.Time,
+				Event:   Started,
+				Steps:   map[string]Event{},
+				Outputs: map[string]map[string]string{},
+			})
+		}
+
+		if entry.Step == "" {
+			runs[i].Event = entry.Event
+			if entry.Event != Started {
+				runs[i].Finished = entry.Time
+			}
+			continue
+		}
+
+		runs[i].Steps[entry.Step] = entry.Event
+		if entry.Outputs != nil {
+			runs[i].Outputs[entry.Step] = entry.Outputs
+		}
+	}
+
+	return runs, nil
+}
+
+// Completed returns the steps already done by the last run, should it be an unfinished run of
+// action at the current sha. Nothing is completed after a finished run of any action, and
+// resuming across an unfinished run of another action, such as a destroy that removed what the
+// deploy mounted, or of a different sha is refused.
+func (j *Journal) Completed(ctx context.Context, action string) ([]string, error) {
+	runs, err := j.Runs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(runs) == 0 {
+		return nil, nil
+	}
+
+	last := runs[len(runs)-1]
+	if last.Event == Succeeded {
+		return nil, nil
+	}
+
+	if last.Action != action {
+		return nil, fmt.Errorf("cannot resume %s after unfinished %s run %s", action, last.Action, last.Id)
+	}
+
+	if last.Sha != j.sha {
+		return nil, fmt.Errorf("cannot resume run %s of sha %s from sha %s", last.Id, last.Sha, j.sha)
+	}
+
+	var completed []string
+	for step, event := range last.Steps {
+		if event == Succeeded || event == Resumed {
+			completed = append(completed, step)
+		}
+	}
+
+	slices.Sort(completed)
+	return completed, nil
+}
+
+// Table renders the run history as a table
+func (j *Journal) Table(ctx context.Context) (string, error) {
+	runs, err := j.Runs(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tbl := table.New()
+	tbl.Headers("Run", "Action", "Sha", "Status", "Duration", "Steps")
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+
+		duration := ""
+		if !run.Finished.IsZero() {
+			duration = run.Finished.Sub(run.Started).Round(time.Second).String()
+		}
+
+		var steps []string
+		for _, step := range slices.Sorted(maps.Keys(run.Steps)) {
+			steps = append(steps, fmt.Sprintf("%s:%s", step, run.Steps[step]))
+		}
+
+		tbl.Row(run.Id, run.Action, truncate(run.Sha), string(run.Event), duration, strings.Join(steps, " "))
+	}
+
+	return tbl.Render(), nil
+}
+
+//
+// Helpers
+//
+
+func truncate(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
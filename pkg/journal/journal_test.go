@@ -0,0 +1,181 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_AppendRead(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(filepath.Join(t.TempDir(), "journal"))
+
+	entries, err := backend.Read(ctx, "repo-main-svc")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, backend.Append(ctx, "repo-main-svc", Entry{Run: "1", Event: Started}))
+	require.NoError(t, backend.Append(ctx, "repo-main-svc", Entry{Run: "1", Step: "iam", Event: Succeeded}))
+
+	entries, err = backend.Read(ctx, "repo-main-svc")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "iam", entries[1].Step)
+}
+
+func TestFile_ReadIgnoresTruncatedLine(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backend := NewFile(dir)
+
+	require.NoError(t, backend.Append(ctx, "svc", Entry{Run: "1", Event: Started}))
+
+	file, err := os.OpenFile(filepath.Join(dir, "svc.jsonl"), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"run":"1","step":"ia`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	entries, err := backend.Read(ctx, "svc")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestJournal_RecordRequiresBegin(t *testing.T) {
+	journal := New(NewFile(t.TempDir()), "svc", "abc123")
+	assert.Error(t, journal.Record(context.Background(), "iam", Started, nil, nil))
+}
+
+func TestJournal_Runs(t *testing.T) {
+	ctx := context.Background()
+	journal := New(NewFile(t.TempDir()), "svc", "abc123")
+
+	require.NoError(t, journal.Begin(ctx, "deploy"))
+	require.NoError(t, journal.Record(ctx, "iam", Started, nil, nil))
+	require.NoError(t, journal.Record(ctx, "iam", Succeeded, nil, map[string]string{"role_arn": "arn"}))
+	require.NoError(t, journal.Record(ctx, "lambda", Failed, errors.New("timeout"), nil))
+	require.NoError(t, journal.End(ctx, errors.New("lambda: timeout")))
+
+	runs, err := journal.Runs(ctx)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+
+	assert.Equal(t, "deploy", runs[0].Action)
+	assert.Equal(t, "abc123", runs[0].Sha)
+	assert.Equal(t, Failed, runs[0].Event)
+	assert.False(t, runs[0].Finished.IsZero())
+	assert.Equal(t, map[string]Event{"iam": Succeeded, "lambda": Failed}, runs[0].Steps)
+}
+
+func TestJournal_Completed(t *testing.T) {
+	ctx := context.Background()
+	journal := New(NewFile(t.TempDir()), "svc", "abc123")
+
+	completed, err := journal.Completed(ctx, "deploy")
+	require.NoError(t, err)
+	assert.Empty(t, completed)
+
+	require.NoError(t, journal.Begin(ctx, "deploy"))
+	require.NoError(t, journal.Record(ctx, "iam", Succeeded, nil, nil))
+	require.NoError(t, journal.Record(ctx, "cloudwatch", Succeeded, nil, nil))
+	require.NoError(t, journal.Record(ctx, "cloudwatch", RolledBack, nil, nil))
+	require.NoError(t, journal.Record(ctx, "lambda", Failed, errors.New("timeout"), nil))
+	require.NoError(t, journal.End(ctx, errors.New("lambda: timeout")))
+
+	completed, err = journal.Completed(ctx, "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iam"}, completed)
+
+	require.NoError(t, journal.Begin(ctx, "deploy"))
+	require.NoError(t, journal.End(ctx, nil))
+
+	completed, err = journal.Completed(ctx, "deploy")
+	require.NoError(t, err)
+	assert.Empty(t, completed, "a finished deploy has nothing to resume")
+}
+
+func TestJournal_CompletedAfterDestroy(t *testing.T) {
+	ctx := context.Background()
+	journal := New(NewFile(t.TempDir()), "svc", "abc123")
+
+	require.NoError(t, journal.Begin(ctx, "deploy"))
+	require.NoError(t, journal.Record(ctx, "iam", Succeeded, nil, nil))
+	require.NoError(t, journal.Record(ctx, "lambda", Failed, errors.New("timeout"), nil))
+	require.NoError(t, journal.End(ctx, errors.New("lambda: timeout")))
+
+	// the destroy removed the role the deploy mounted, so nothing is left to skip
+	require.NoError(t, journal.Begin(ctx, "destroy"))
+	require.NoError(t, journal.Record(ctx, "iam", Succeeded, nil, nil))
+	require.NoError(t, journal.End(ctx, nil))
+
+	completed, err := journal.Completed(ctx, "deploy")
+	require.NoError(t, err)
+	assert.Empty(t, completed)
+
+	require.NoError(t, journal.Begin(ctx, "destroy"))
+	require.NoError(t, journal.Record(ctx, "iam", Failed, errors.New("denied"), nil))
+	require.NoError(t, journal.End(ctx, errors.New("iam: denied")))
+
+	_, err = journal.Completed(ctx, "deploy")
+	assert.ErrorContains(t, err, "cannot resume deploy after unfinished destroy")
+}
+
+func TestJournal_CompletedDifferentSha(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+
+	previous := New(backend, "svc", "abc123")
+	require.NoError(t, previous.Begin(ctx, "deploy"))
+	require.NoError(t, previous.Record(ctx, "iam", Succeeded, nil, nil))
+
+	current := New(backend, "svc", "def456")
+	_, err := current.Completed(ctx, "deploy")
+	assert.ErrorContains(t, err, "cannot resume")
+
+	require.NoError(t, previous.End(ctx, nil))
+	completed, err := current.Completed(ctx, "deploy")
+	require.NoError(t, err)
+	assert.Empty(t, completed)
+}
+
+func TestNewS3_InvalidUri(t *testing.T) {
+	_, err := NewS3(awsConfig(), "s3:///prefix")
+	assert.Error(t, err)
+
+	backend, err := NewS3(awsConfig(), "s3://bucket/monad/journal/")
+	require.NoError(t, err)
+	assert.Equal(t, "bucket", backend.bucket)
+	assert.Equal(t, "monad/journal/svc/20250601T120000.000000Z.jsonl", backend.key("svc", "20250601T120000.000000Z"))
+}
+
+func awsConfig() aws.Config {
+	return aws.Config{Region: "us-west-2"}
+}
+
+func TestJournal_RunsOutputsAt(t *testing.T) {
+	ctx := context.Background()
+	journal := New(NewFile(t.TempDir()), "svc", "abc123")
+
+	require.NoError(t, journal.Begin(ctx, "deploy"))
+	require.NoError(t, journal.Record(ctx, "iam", Succeeded, nil, map[string]string{"role_arn": "arn"}))
+	require.NoError(t, journal.End(ctx, nil))
+
+	rollback := journal.At("def456")
+	require.NoError(t, rollback.Begin(ctx, "rollback"))
+	require.NoError(t, rollback.End(ctx, nil))
+
+	runs, err := journal.Runs(ctx)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+
+	assert.Equal(t, map[string]map[string]string{"iam": {"role_arn": "arn"}}, runs[0].Outputs)
+	assert.Equal(t, "def456", runs[1].Sha)
+	assert.Equal(t, "rollback", runs[1].Action)
+	assert.Empty(t, runs[1].Outputs)
+}
@@ -0,0 +1,211 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Client interface for dependency injection and testing
+type S3Client interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+// retainedRuns is the number of runs kept for each journal, the oldest being deleted
+// as new runs begin
+const retainedRuns = 50
+
+// S3 stores each run of a journal as a JSON lines object beneath a prefix of its key,
+// so that concurrent runs of a service never write the same object.
+type S3 struct {
+	client S3Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a backend for a uri of the form s3://bucket/prefix
+func NewS3(config aws.Config, uri string) (*S3, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme != "s3" || parsed.Host == "" {
+		return nil, fmt.Errorf("journal uri must be of the form s3://bucket/prefix: %s", uri)
+	}
+
+	return &S3{
+		client: s3.NewFromConfig(config),
+		bucket: parsed.Host,
+		prefix: strings.Trim(parsed.Path, "/"),
+	}, nil
+}
+
+// Append adds the entries to the objects of their runs. Only the process running a run
+// writes its object, and the object of a new run makes room for itself within retainedRuns.
+func (s *S3) Append(ctx context.Context, key string, entries ...Entry) error {
+	var runs []string
+	byRun := map[string][]Entry{}
+	for _, entry := range entries {
+		if _, ok := byRun[entry.Run]; !ok {
+			runs = append(runs, entry.Run)
+		}
+		byRun[entry.Run] = append(byRun[entry.Run], entry)
+	}
+
+	for _, run := range runs {
+		object := s.key(key, run)
+
+		existing, err := s.get(ctx, object)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			if err := s.prune(ctx, key); err != nil {
+				return err
+			}
+		}
+
+		buf := bytes.NewBuffer(existing)
+		encoder := json.NewEncoder(buf)
+		for _, entry := range byRun[run] {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(object),
+			Body:        bytes.NewReader(buf.Bytes()),
+			ContentType: aws.String("application/x-ndjson"),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read returns the entries of every retained run, oldest first
+func (s *S3) Read(ctx context.Context, key string) ([]Entry, error) {
+	objects, err := s.list(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, object := range objects {
+		body, err := s.get(ctx, object)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := decode(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, decoded...)
+	}
+
+	return entries, nil
+}
+
+// prune deletes the oldest runs of key so that a new run remains within retainedRuns
+func (s *S3) prune(ctx context.Context, key string) error {
+	objects, err := s.list(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	excess := len(objects) - retainedRuns + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	var identifiers []types.ObjectIdentifier
+	for _, object := range objects[:excess] {
+		identifiers = append(identifiers, types.ObjectIdentifier{Key: aws.String(object)})
+	}
+
+	_, err = s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Delete: &types.Delete{Objects: identifiers, Quiet: aws.Bool(true)},
+	})
+
+	return err
+}
+
+// list returns the objects of the runs of key, which sort oldest first as run ids are timestamps
+func (s *S3) list(ctx context.Context, key string) ([]string, error) {
+	var objects []string
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(path.Join(s.prefix, key) + "/"),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			objects = append(objects, *object.Key)
+		}
+	}
+
+	slices.Sort(objects)
+	return objects, nil
+}
+
+func (s *S3) get(ctx context.Context, object string) ([]byte, error) {
+	var apiErr smithy.APIError
+
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(object),
+	})
+
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey":
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(output.Body); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *S3) key(key, run string) string {
+	return path.Join(s.prefix, key, run+".jsonl")
+}
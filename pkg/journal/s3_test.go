@@ -0,0 +1,117 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 keeps objects in memory, listing them a page of two at a time
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, ok := f.objects[*params.Key]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[*params.Key] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, *params.Prefix) && (params.ContinuationToken == nil || key > *params.ContinuationToken) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	output := &s3.ListObjectsV2Output{}
+	for _, key := range keys[:min(2, len(keys))] {
+		output.Contents = append(output.Contents, types.Object{Key: aws.String(key)})
+	}
+	if len(keys) > 2 {
+		output.IsTruncated = aws.Bool(true)
+		output.NextContinuationToken = aws.String(keys[1])
+	}
+	return output, nil
+}
+
+func (f *fakeS3) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, object := range params.Delete.Objects {
+		delete(f.objects, *object.Key)
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func TestS3_AppendRead(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeS3{objects: map[string][]byte{}}
+	backend := &S3{client: client, bucket: "bucket", prefix: "monad"}
+
+	// runs of concurrent deploys append to objects of their own
+	require.NoError(t, backend.Append(ctx, "svc", Entry{Run: "2", Event: Started}))
+	require.NoError(t, backend.Append(ctx, "svc", Entry{Run: "1", Event: Started}))
+	require.NoError(t, backend.Append(ctx, "svc", Entry{Run: "2", Step: "iam", Event: Succeeded}))
+	require.NoError(t, backend.Append(ctx, "other", Entry{Run: "1", Event: Started}))
+
+	assert.Len(t, client.objects, 3)
+
+	entries, err := backend.Read(ctx, "svc")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "1", entries[0].Run, "runs are read oldest first")
+	assert.Equal(t, "iam", entries[2].Step)
+}
+
+func TestS3_Prune(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeS3{objects: map[string][]byte{}}
+	backend := &S3{client: client, bucket: "bucket"}
+
+	for i := range retainedRuns + 5 {
+		run := fmt.Sprintf("%03d", i)
+		require.NoError(t, backend.Append(ctx, "svc", Entry{Run: run, Event: Started}))
+		require.NoError(t, backend.Append(ctx, "svc", Entry{Run: run, Event: Succeeded}))
+	}
+
+	entries, err := backend.Read(ctx, "svc")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2*retainedRuns)
+	assert.Equal(t, "005", entries[0].Run)
+}
@@ -0,0 +1,182 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// DynamoDB stores each lease as an item of a table whose partition key is the string attribute "key".
+// Conditional writes guarantee a single owner across machines. The numeric "expires" attribute may be
+// configured as the table TTL so that abandoned leases are eventually removed.
+type DynamoDB struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewDynamoDB returns a backend for a uri of the form dynamodb://table
+func NewDynamoDB(config aws.Config, uri string) (*DynamoDB, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme != "dynamodb" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+		return nil, fmt.Errorf("lock uri must be of the form dynamodb://table: %s", uri)
+	}
+
+	return &DynamoDB{
+		client: dynamodb.NewFromConfig(config),
+		table:  parsed.Host,
+	}, nil
+}
+
+func (d *DynamoDB) Acquire(ctx context.Context, lease Lease) error {
+	var apiErr smithy.APIError
+
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                item(lease),
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR #owner = :owner OR #expires <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#key":     "key",
+			"#owner":   "owner",
+			"#expires": "expires",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: lease.Owner},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	})
+
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ConditionalCheckFailedException":
+			current, err := d.Get(ctx, lease.Key)
+			if err != nil {
+				return err
+			}
+
+			// released between the write and the read
+			if current == nil {
+				return d.Acquire(ctx, lease)
+			}
+
+			return &HeldError{Lease: *current}
+		default:
+			return err
+		}
+	}
+
+	return err
+}
+
+func (d *DynamoDB) Release(ctx context.Context, key, owner string) error {
+	var apiErr smithy.APIError
+
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 itemKey(key),
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ConditionalCheckFailedException":
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return err
+}
+
+func (d *DynamoDB) Get(ctx context.Context, key string) (*Lease, error) {
+	output, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            itemKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if output.Item == nil {
+		return nil, nil
+	}
+
+	return lease(output.Item)
+}
+
+func (d *DynamoDB) Delete(ctx context.Context, key string) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       itemKey(key),
+	})
+
+	return err
+}
+
+//
+// Helpers
+//
+
+func itemKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"key": &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+func item(lease Lease) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"key":      &types.AttributeValueMemberS{Value: lease.Key},
+		"owner":    &types.AttributeValueMemberS{Value: lease.Owner},
+		"acquired": &types.AttributeValueMemberS{Value: lease.Acquired.Format(time.RFC3339)},
+		"expires":  &types.AttributeValueMemberN{Value: strconv.FormatInt(lease.Expires.Unix(), 10)},
+	}
+}
+
+func lease(item map[string]types.AttributeValue) (*Lease, error) {
+	var lease Lease
+
+	if value, ok := item["key"].(*types.AttributeValueMemberS); ok {
+		lease.Key = value.Value
+	}
+
+	if value, ok := item["owner"].(*types.AttributeValueMemberS); ok {
+		lease.Owner = value.Value
+	}
+
+	if value, ok := item["acquired"].(*types.AttributeValueMemberS); ok {
+		acquired, err := time.Parse(time.RFC3339, value.Value)
+		if err != nil {
+			return nil, err
+		}
+		lease.Acquired = acquired
+	}
+
+	if value, ok := item["expires"].(*types.AttributeValueMemberN); ok {
+		expires, err := strconv.ParseInt(value.Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		lease.Expires = time.Unix(expires, 0).UTC()
+	}
+
+	return &lease, nil
+}
@@ -0,0 +1,142 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// guardTimeout bounds how long a guard file may exist before it is considered abandoned
+const guardTimeout = 10 * time.Second
+
+// File stores each lease as a JSON file within a directory.
+// It serializes processes sharing a filesystem, such as parallel jobs on one CI runner.
+type File struct {
+	dir string
+}
+
+func NewFile(dir string) *File {
+	return &File{dir: dir}
+}
+
+func (f *File) Acquire(ctx context.Context, lease Lease) error {
+	return f.guard(ctx, lease.Key, func() error {
+		current, err := f.read(lease.Key)
+		if err != nil {
+			return err
+		}
+
+		if current != nil && current.Owner != lease.Owner && !current.Expired(time.Now()) {
+			return &HeldError{Lease: *current}
+		}
+
+		return f.write(lease)
+	})
+}
+
+func (f *File) Release(ctx context.Context, key, owner string) error {
+	return f.guard(ctx, key, func() error {
+		current, err := f.read(key)
+		if err != nil {
+			return err
+		}
+
+		if current == nil || current.Owner != owner {
+			return nil
+		}
+
+		return f.remove(key)
+	})
+}
+
+func (f *File) Get(ctx context.Context, key string) (*Lease, error) {
+	return f.read(key)
+}
+
+func (f *File) Delete(ctx context.Context, key string) error {
+	return f.guard(ctx, key, func() error {
+		return f.remove(key)
+	})
+}
+
+// guard runs fn while holding an exclusively created guard file of key
+func (f *File) guard(ctx context.Context, key string, fn func() error) error {
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		return err
+	}
+
+	path := f.path(key) + ".guard"
+
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			file.Close()
+			break
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > guardTimeout {
+			os.Remove(path)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	defer os.Remove(path)
+
+	return fn()
+}
+
+func (f *File) read(key string) (*Lease, error) {
+	data, err := os.ReadFile(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return nil, fmt.Errorf("corrupt lock %s: %w", f.path(key), err)
+	}
+
+	return &lease, nil
+}
+
+func (f *File) write(lease Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path(lease.Key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f.path(lease.Key))
+}
+
+func (f *File) remove(key string) error {
+	err := os.Remove(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f *File) path(key string) string {
+	return filepath.Join(f.dir, key+".lock")
+}
@@ -0,0 +1,317 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bkeane/monad/pkg/basis/caller"
+	"github.com/bkeane/monad/pkg/basis/resource"
+
+	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/rs/zerolog/log"
+)
+
+//
+// Dependencies
+//
+
+type Basis interface {
+	Caller() (*caller.Basis, error)
+	Resource() (*resource.Basis, error)
+}
+
+// Backend stores leases such that only one owner holds an unexpired lease of a key
+type Backend interface {
+	// Acquire stores the lease unless another owner holds an unexpired lease of its key,
+	// in which case a *HeldError is returned. Acquiring a lease already held by its owner renews it.
+	Acquire(ctx context.Context, lease Lease) error
+	// Release removes the lease of key when it is held by owner
+	Release(ctx context.Context, key, owner string) error
+	// Get returns the lease of key, or nil when there is none
+	Get(ctx context.Context, key string) (*Lease, error)
+	// Delete removes the lease of key regardless of its owner
+	Delete(ctx context.Context, key string) error
+}
+
+//
+// Lease
+//
+
+type Lease struct {
+	Key      string    `json:"key"`
+	Owner    string    `json:"owner"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (l *Lease) Expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// HeldError reports the lease preventing an acquire
+type HeldError struct {
+	Lease Lease
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%s is locked by %s since %s until %s, run monad unlock if it was abandoned",
+		e.Lease.Key, e.Lease.Owner, e.Lease.Acquired.Format(time.RFC3339), e.Lease.Expires.Format(time.RFC3339))
+}
+
+// LostError reports a lease that could not be renewed while held
+type LostError struct {
+	Key string
+	Err error
+}
+
+func (e *LostError) Error() string {
+	return fmt.Sprintf("lost the lock of %s: %v", e.Key, e.Err)
+}
+
+func (e *LostError) Unwrap() error {
+	return e.Err
+}
+
+//
+// Lock
+//
+
+type Lock struct {
+	LockBackend string `env:"MONAD_LOCK" flag:"--lock" usage:"Lock in a dynamodb://table shared between runners, or a directory of this machine (default unlocked)" hint:"uri|path"`
+	LockLease   int32  `env:"MONAD_LOCK_LEASE" flag:"--lock-lease" usage:"Lock lease renewed while held (default 300)" hint:"sec"`
+	LockWait    int32  `env:"MONAD_LOCK_WAIT" flag:"--lock-wait" usage:"Wait for a held lock before failing (default 0)" hint:"sec"`
+	backend     Backend
+	key         string
+	owner       string
+	acquired    time.Time
+	mu          sync.Mutex
+	stop        chan struct{}
+	done        chan struct{}
+	cancel      context.CancelCauseFunc
+}
+
+//
+// Derive
+//
+
+func Derive(ctx context.Context, basis Basis) (*Lock, error) {
+	var err error
+	var lock Lock
+
+	if err = env.Parse(&lock); err != nil {
+		return nil, err
+	}
+
+	resource, err := basis.Resource()
+	if err != nil {
+		return nil, err
+	}
+
+	lock.key = resource.Name()
+	lock.owner = owner()
+
+	if lock.LockLease == 0 {
+		lock.LockLease = 300
+	}
+
+	switch {
+	case lock.LockBackend == "", lock.LockBackend == "none":
+	case strings.HasPrefix(lock.LockBackend, "dynamodb://"):
+		caller, err := basis.Caller()
+		if err != nil {
+			return nil, err
+		}
+
+		lock.backend, err = NewDynamoDB(caller.AwsConfig(), lock.LockBackend)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		lock.backend = NewFile(lock.LockBackend)
+	}
+
+	if err = lock.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+// New returns a lock of key held by owner in backend
+func New(backend Backend, key, owner string, lease, wait time.Duration) *Lock {
+	return &Lock{
+		LockLease: int32(lease.Seconds()),
+		LockWait:  int32(wait.Seconds()),
+		backend:   backend,
+		key:       key,
+		owner:     owner,
+	}
+}
+
+func (l *Lock) Validate() error {
+	return v.ValidateStruct(l,
+		v.Field(&l.key, v.Required),
+		v.Field(&l.owner, v.Required),
+		v.Field(&l.LockLease, v.Required, v.Min(int32(1))),
+		v.Field(&l.LockWait, v.Min(int32(0))),
+	)
+}
+
+//
+// Locking
+//
+
+// Lock acquires the lease, waiting up to the configured wait for another owner to release it.
+// The lease is renewed in the background until Unlock is called. The returned context is
+// cancelled with a *LostError should a renewal fail, as the lease may then be held by another owner.
+func (l *Lock) Lock(ctx context.Context) (context.Context, error) {
+	if l.disabled() && l.LockBackend == "" {
+		log.Warn().
+			Str("key", l.key).
+			Msg("deploys are not locked, set --lock dynamodb://table to serialize them across runners")
+	}
+
+	if l.disabled() {
+		return ctx, nil
+	}
+
+	deadline := time.Now().Add(l.wait())
+
+	for {
+		l.acquired = time.Now().UTC()
+		err := l.backend.Acquire(ctx, l.lease())
+
+		var held *HeldError
+		if !errors.As(err, &held) || time.Now().After(deadline) {
+			if err != nil {
+				return ctx, err
+			}
+			break
+		}
+
+		log.Info().
+			Str("owner", held.Lease.Owner).
+			Str("key", l.key).
+			Msg("waiting for lock")
+
+		select {
+		case <-ctx.Done():
+			return ctx, ctx.Err()
+		case <-time.After(min(time.Second, time.Until(deadline)+time.Millisecond)):
+		}
+	}
+
+	locked, cancel := context.WithCancelCause(ctx)
+
+	l.mu.Lock()
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	l.cancel = cancel
+	go l.renew(l.stop, l.done, cancel)
+	l.mu.Unlock()
+
+	return locked, nil
+}
+
+// Unlock stops renewing the lease, cancels the context returned by Lock and releases the lease
+func (l *Lock) Unlock(ctx context.Context) error {
+	if l.disabled() {
+		return nil
+	}
+
+	l.mu.Lock()
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.cancel(nil)
+		l.stop = nil
+	}
+	l.mu.Unlock()
+
+	return l.backend.Release(ctx, l.key, l.owner)
+}
+
+// Force removes the lease regardless of who holds it, returning the removed lease if there was one
+func (l *Lock) Force(ctx context.Context) (*Lease, error) {
+	if l.disabled() {
+		return nil, fmt.Errorf("locking is disabled, --lock names no backend")
+	}
+
+	lease, err := l.backend.Get(ctx, l.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if lease == nil {
+		return nil, nil
+	}
+
+	return lease, l.backend.Delete(ctx, l.key)
+}
+
+// renew renews the lease until stopped, cancelling the locked context and giving up on the first failure
+func (l *Lock) renew(stop, done chan struct{}, cancel context.CancelCauseFunc) {
+	defer close(done)
+
+	ticker := time.NewTicker(time.Duration(l.LockLease) * time.Second / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := l.backend.Acquire(context.Background(), l.lease()); err != nil {
+				log.Error().Err(err).Str("key", l.key).Msg("lock renewal failed")
+				cancel(&LostError{Key: l.key, Err: err})
+				return
+			}
+		}
+	}
+}
+
+// lease returns a lease expiring a full lease period from now
+func (l *Lock) lease() Lease {
+	return Lease{
+		Key:      l.key,
+		Owner:    l.owner,
+		Acquired: l.acquired,
+		Expires:  time.Now().UTC().Add(time.Duration(l.LockLease) * time.Second),
+	}
+}
+
+// disabled reports whether there is no backend to lock in. A local file would not
+// serialize CI jobs on different runners, so none is used unless --lock names one.
+func (l *Lock) disabled() bool {
+	return l.backend == nil
+}
+
+func (l *Lock) wait() time.Duration {
+	return time.Duration(l.LockWait) * time.Second
+}
+
+//
+// Helpers
+//
+
+// owner identifies this process, preferring the CI job when there is one
+func owner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	owner := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+
+	if url := os.Getenv("GITHUB_SERVER_URL"); url != "" && os.Getenv("GITHUB_RUN_ID") != "" {
+		owner = fmt.Sprintf("%s/%s/actions/runs/%s (%s)", url, os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"), owner)
+	}
+
+	return owner
+}
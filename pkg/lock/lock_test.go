@@ -0,0 +1,226 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_AcquireHeld(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+	now := time.Now().UTC()
+
+	require.NoError(t, backend.Acquire(ctx, Lease{Key: "svc", Owner: "a", Acquired: now, Expires: now.Add(time.Minute)}))
+
+	err := backend.Acquire(ctx, Lease{Key: "svc", Owner: "b", Acquired: now, Expires: now.Add(time.Minute)})
+	var held *HeldError
+	require.ErrorAs(t, err, &held)
+	assert.Equal(t, "a", held.Lease.Owner)
+
+	// the owner may renew its own lease
+	require.NoError(t, backend.Acquire(ctx, Lease{Key: "svc", Owner: "a", Acquired: now, Expires: now.Add(2 * time.Minute)}))
+}
+
+func TestFile_AcquireExpired(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+	past := time.Now().UTC().Add(-time.Hour)
+
+	require.NoError(t, backend.Acquire(ctx, Lease{Key: "svc", Owner: "a", Acquired: past, Expires: past.Add(time.Minute)}))
+	require.NoError(t, backend.Acquire(ctx, Lease{Key: "svc", Owner: "b", Acquired: time.Now(), Expires: time.Now().Add(time.Minute)}))
+
+	lease, err := backend.Get(ctx, "svc")
+	require.NoError(t, err)
+	assert.Equal(t, "b", lease.Owner)
+}
+
+func TestFile_Release(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+	now := time.Now().UTC()
+
+	require.NoError(t, backend.Acquire(ctx, Lease{Key: "svc", Owner: "a", Acquired: now, Expires: now.Add(time.Minute)}))
+
+	// releasing another owner's lease is a no-op
+	require.NoError(t, backend.Release(ctx, "svc", "b"))
+	lease, err := backend.Get(ctx, "svc")
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+
+	require.NoError(t, backend.Release(ctx, "svc", "a"))
+	lease, err = backend.Get(ctx, "svc")
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+}
+
+func TestFile_AcquireConcurrent(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+	now := time.Now().UTC()
+
+	var wg sync.WaitGroup
+	var acquired int32
+	for _, owner := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			if backend.Acquire(ctx, Lease{Key: "svc", Owner: owner, Acquired: now, Expires: now.Add(time.Minute)}) == nil {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}(owner)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), acquired)
+}
+
+func TestLock_LockUnlock(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+
+	first := New(backend, "svc", "a", time.Minute, 0)
+	second := New(backend, "svc", "b", time.Minute, 0)
+
+	_, err := first.Lock(ctx)
+	require.NoError(t, err)
+
+	var held *HeldError
+	_, err = second.Lock(ctx)
+	assert.ErrorAs(t, err, &held)
+
+	require.NoError(t, first.Unlock(ctx))
+	_, err = second.Lock(ctx)
+	require.NoError(t, err)
+	require.NoError(t, second.Unlock(ctx))
+}
+
+func TestLock_Wait(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+
+	first := New(backend, "svc", "a", time.Minute, 0)
+	second := New(backend, "svc", "b", time.Minute, 2*time.Second)
+
+	_, err := first.Lock(ctx)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		first.Unlock(ctx)
+	}()
+
+	_, err = second.Lock(ctx)
+	require.NoError(t, err)
+	require.NoError(t, second.Unlock(ctx))
+}
+
+func TestLock_Force(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+
+	first := New(backend, "svc", "a", time.Minute, 0)
+	_, err := first.Lock(ctx)
+	require.NoError(t, err)
+
+	lease, err := New(backend, "svc", "b", time.Minute, 0).Force(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	assert.Equal(t, "a", lease.Owner)
+
+	lease, err = backend.Get(ctx, "svc")
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+
+	require.NoError(t, first.Unlock(ctx))
+}
+
+func TestLock_Lost(t *testing.T) {
+	ctx := context.Background()
+	backend := NewFile(t.TempDir())
+
+	first := New(backend, "svc", "a", time.Second, 0)
+	locked, err := first.Lock(ctx)
+	require.NoError(t, err)
+
+	// an abandoned lock is forced and taken over while a still renews it
+	_, err = New(backend, "svc", "b", time.Minute, 0).Force(ctx)
+	require.NoError(t, err)
+	_, err = New(backend, "svc", "b", time.Minute, 0).Lock(ctx)
+	require.NoError(t, err)
+
+	select {
+	case <-locked.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the locked context outlived the lease")
+	}
+
+	var lost *LostError
+	require.ErrorAs(t, context.Cause(locked), &lost)
+	var held *HeldError
+	assert.ErrorAs(t, lost, &held)
+	assert.Equal(t, "b", held.Lease.Owner)
+
+	require.NoError(t, first.Unlock(ctx))
+	lease, err := backend.Get(ctx, "svc")
+	require.NoError(t, err)
+	assert.Equal(t, "b", lease.Owner, "unlocking a lost lock leaves the new owner's lease")
+}
+
+func TestLock_Disabled(t *testing.T) {
+	lock := &Lock{LockBackend: "none"}
+	_, err := lock.Lock(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Unlock(context.Background()))
+
+	_, err = lock.Force(context.Background())
+	assert.Error(t, err)
+}
+
+func TestHeldError(t *testing.T) {
+	err := error(&HeldError{Lease: Lease{Key: "svc", Owner: "ci"}})
+	assert.Contains(t, err.Error(), "svc is locked by ci")
+
+	var held *HeldError
+	assert.True(t, errors.As(err, &held))
+}
+
+func TestNewDynamoDB_InvalidUri(t *testing.T) {
+	config := aws.Config{Region: "us-west-2"}
+
+	_, err := NewDynamoDB(config, "dynamodb://")
+	assert.Error(t, err)
+
+	_, err = NewDynamoDB(config, "dynamodb://table/extra")
+	assert.Error(t, err)
+
+	backend, err := NewDynamoDB(config, "dynamodb://monad-locks")
+	require.NoError(t, err)
+	assert.Equal(t, "monad-locks", backend.table)
+}
+
+func TestDynamoDB_Item(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	original := Lease{Key: "svc", Owner: "ci", Acquired: now, Expires: now.Add(time.Minute)}
+
+	decoded, err := lease(item(original))
+	require.NoError(t, err)
+	assert.Equal(t, original, *decoded)
+}
+
+func TestLock_Unconfigured(t *testing.T) {
+	for _, backend := range []string{"", "none"} {
+		lock := &Lock{LockBackend: backend, key: "svc", owner: "a", LockLease: 300}
+		assert.NoError(t, lock.Validate())
+		assert.True(t, lock.disabled(), "a local file would not serialize runners, so %q locks nothing", backend)
+		_, err := lock.Lock(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, lock.Unlock(context.Background()))
+	}
+}
@@ -1,20 +1,27 @@
 package log
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
 	"github.com/bkeane/monad/pkg/config/cloudwatch"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/rs/zerolog/log"
 
 	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
 )
 
 type Config interface {
@@ -24,11 +31,30 @@ type Config interface {
 }
 
 type LogGroup struct {
-	LogGroupTail bool   `env:"MONAD_LOG_TAIL" flag:"--tail,-f" usage:"Follow log output"`
-	LogGroupAgo  string `env:"MONAD_LOG_AGO" flag:"--ago" usage:"Show logs from duration ago (e.g., 1h, 30m, 60s)" hint:"duration"`
-	cloudwatch   *cloudwatch.Config
+	LogGroupTail      bool   `env:"MONAD_LOG_TAIL" flag:"--tail,-f" usage:"Follow log output"`
+	LogGroupAgo       string `env:"MONAD_LOG_AGO" flag:"--ago" usage:"Show logs from duration ago (e.g., 1h, 30m, 60s)" hint:"duration"`
+	LogGroupSince     string `env:"MONAD_LOG_SINCE" flag:"--since" usage:"Show logs since a time (e.g., 2025-06-01T12:00:00Z, '2025-06-01 12:00')" hint:"time"`
+	LogGroupUntil     string `env:"MONAD_LOG_UNTIL" flag:"--until" usage:"Show logs until a time, in the formats of --since" hint:"time"`
+	LogGroupFilter    string `env:"MONAD_LOG_FILTER" flag:"--filter" usage:"Show logs matching a CloudWatch filter pattern (e.g., ERROR, '{ $.level = \"error\" }')" hint:"pattern"`
+	LogGroupRequestId string `env:"MONAD_LOG_REQUEST_ID" flag:"--request-id" usage:"Show the logs of one invocation" hint:"id"`
+	LogGroupOutput    string `env:"MONAD_LOG_OUTPUT" flag:"--output" usage:"Write messages raw, or pretty print JSON messages colored by level with json (default raw)" hint:"format" file:"-"`
+	cloudwatch        *cloudwatch.Config
+	streams           map[string]bool
 }
 
+// timeLayouts are the layouts --since and --until are parsed with, in local time when
+// without a zone
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// requestId is the form of a lambda request id
+var requestId = regexp.MustCompile(`^[0-9a-fA-F-]+$`)
+
 func Derive(config *cloudwatch.Config) (*LogGroup, error) {
 	var lg LogGroup
 
@@ -39,9 +65,69 @@ func Derive(config *cloudwatch.Config) (*LogGroup, error) {
 
 	lg.cloudwatch = config
 
+	if err := lg.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &lg, nil
 }
 
+//
+// Validations
+//
+
+func (l *LogGroup) Validate() error {
+	return v.ValidateStruct(l,
+		v.Field(&l.LogGroupSince, v.By(validTime), v.When(l.LogGroupAgo != "", v.Empty.Error("cannot be used with --ago"))),
+		v.Field(&l.LogGroupUntil, v.By(validTime), v.By(l.afterSince), v.When(l.LogGroupTail, v.Empty.Error("cannot be used with --tail"))),
+		v.Field(&l.LogGroupRequestId, v.Match(requestId)),
+		v.Field(&l.LogGroupOutput, v.In("raw", "json")),
+	)
+}
+
+// afterSince checks that --until is later than --since when both are given
+func (l *LogGroup) afterSince(value interface{}) error {
+	if value.(string) == "" || l.LogGroupSince == "" {
+		return nil
+	}
+
+	since, err := parseTime(l.LogGroupSince)
+	if err != nil {
+		return nil
+	}
+
+	until, err := parseTime(value.(string))
+	if err != nil {
+		return nil
+	}
+
+	if !since.Before(until) {
+		return fmt.Errorf("must be later than --since")
+	}
+	return nil
+}
+
+func validTime(value interface{}) error {
+	if value.(string) == "" {
+		return nil
+	}
+	_, err := parseTime(value.(string))
+	return err
+}
+
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time format: %s", value)
+}
+
+//
+// Dump
+//
+
 func (l *LogGroup) Dump(ctx context.Context) error {
 	// Parse duration or default to 30 seconds
 	duration := 30 * time.Second
@@ -58,63 +144,146 @@ func (l *LogGroup) Dump(ctx context.Context) error {
 
 // dumpLogsForTail dumps historical logs for the tail command, respecting the --ago flag
 func (l *LogGroup) dumpLogsForTail(ctx context.Context) error {
-	// For tailing, we only dump historical logs if --ago flag is provided
-	// If no --ago flag is provided, we start tailing from now (no historical logs)
-	if l.LogGroupAgo == "" {
+	// For tailing, we only dump historical logs if --ago or --since is provided
+	// Otherwise we start tailing from now (no historical logs)
+	if l.LogGroupAgo == "" && l.LogGroupSince == "" {
 		return nil
 	}
 
-	duration, err := time.ParseDuration(l.LogGroupAgo)
-	if err != nil {
-		return fmt.Errorf("invalid duration format: %w", err)
+	var duration time.Duration
+	if l.LogGroupAgo != "" {
+		var err error
+		duration, err = time.ParseDuration(l.LogGroupAgo)
+		if err != nil {
+			return fmt.Errorf("invalid duration format: %w", err)
+		}
 	}
 
 	return l.dumpLogs(ctx, duration)
 }
 
-// dumpLogs is the common implementation for dumping logs for a given duration
+// dumpLogs is the common implementation for dumping logs for a given duration before
+// --until or now, or between --since and --until when given
 func (l *LogGroup) dumpLogs(ctx context.Context, duration time.Duration) error {
-	// Get logs from the specified duration ago
-	endTime := time.Now()
-	startTime := endTime.Add(-duration)
+	startTime, endTime, err := l.window(time.Now(), duration)
+	if err != nil {
+		return err
+	}
 
-	logGroupName := l.cloudwatch.Name()
-	startTimeMillis := startTime.UnixMilli()
-	endTimeMillis := endTime.UnixMilli()
+	if l.LogGroupRequestId != "" {
+		return l.dumpInvocation(ctx, startTime, endTime)
+	}
 
-	input := &cloudwatchlogs.FilterLogEventsInput{
-		LogGroupName: &logGroupName,
-		StartTime:    &startTimeMillis,
-		EndTime:      &endTimeMillis,
+	events, err := l.events(ctx, l.filterInput(startTime, endTime))
+	if err != nil {
+		return err
 	}
 
-	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(l.cloudwatch.Client(), input)
+	for _, event := range events {
+		l.write(os.Stdout, *event.Timestamp, *event.Message)
+	}
 
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
+	return nil
+}
+
+// window returns the time range logs are dumped from: duration before --until, or now
+// when it is not given, unless --since gives the start
+func (l *LogGroup) window(now time.Time, duration time.Duration) (time.Time, time.Time, error) {
+	var err error
+
+	end := now
+	if l.LogGroupUntil != "" {
+		if end, err = parseTime(l.LogGroupUntil); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	start := end.Add(-duration)
+	if l.LogGroupSince != "" {
+		if start, err = parseTime(l.LogGroupSince); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("--since %s is later than the end of the logs at %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	return start, end, nil
+}
+
+// dumpInvocation dumps the logs of the --request-id invocation. Its START, END and REPORT
+// lines are found by the request id, and the lines between them are read from the log stream
+// they were written to, as each execution environment serves one invocation at a time.
+func (l *LogGroup) dumpInvocation(ctx context.Context, start, end time.Time) error {
+	input := l.filterInput(start, end)
+	input.FilterPattern = aws.String(`"` + l.LogGroupRequestId + `"`)
+
+	found, err := l.events(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	for _, invocation := range invocations(found, l.LogGroupRequestId, end) {
+		input := l.filterInput(invocation.start, invocation.end)
+		input.LogStreamNames = []string{invocation.stream}
+
+		events, err := l.events(ctx, input)
 		if err != nil {
-			return fmt.Errorf("failed to get log events: %w", err)
+			return err
 		}
 
-		for _, event := range page.Events {
-			message := strings.TrimSuffix(*event.Message, "\n")
-			fmt.Printf("%s %s\n",
-				time.Unix(*event.Timestamp/1000, 0).Format("2006-01-02 15:04:05"),
-				message)
+		for _, event := range events {
+			l.write(os.Stdout, *event.Timestamp, *event.Message)
 		}
 	}
 
 	return nil
 }
 
-func (l *LogGroup) session(ctx context.Context) (*cloudwatchlogs.StartLiveTailEventStream, error) {
-	client := l.cloudwatch.Client()
+// events returns every page of events of the input
+func (l *LogGroup) events(ctx context.Context, input *cloudwatchlogs.FilterLogEventsInput) ([]types.FilteredLogEvent, error) {
+	var events []types.FilteredLogEvent
 
-	request := &cloudwatchlogs.StartLiveTailInput{
-		LogGroupIdentifiers: []string{l.cloudwatch.Arn()},
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(l.cloudwatch.Client(), input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get log events: %w", err)
+		}
+		events = append(events, page.Events...)
+	}
+
+	return events, nil
+}
+
+// filterInput requests the events of the log group between start and end matching the filters
+func (l *LogGroup) filterInput(start, end time.Time) *cloudwatchlogs.FilterLogEventsInput {
+	logGroupName := l.cloudwatch.Name()
+	startTimeMillis := start.UnixMilli()
+	endTimeMillis := end.UnixMilli()
+
+	input := &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: &logGroupName,
+		StartTime:    &startTimeMillis,
+		EndTime:      &endTimeMillis,
 	}
 
-	response, err := client.StartLiveTail(ctx, request)
+	if l.LogGroupFilter != "" {
+		input.FilterPattern = aws.String(l.LogGroupFilter)
+	}
+
+	return input
+}
+
+//
+// Tail
+//
+
+func (l *LogGroup) session(ctx context.Context) (*cloudwatchlogs.StartLiveTailEventStream, error) {
+	client := l.cloudwatch.Client()
+
+	response, err := client.StartLiveTail(ctx, l.tailInput())
 	if err != nil {
 		return nil, fmt.Errorf("failed to start live tail: %w", err)
 	}
@@ -122,6 +291,20 @@ func (l *LogGroup) session(ctx context.Context) (*cloudwatchlogs.StartLiveTailEv
 	return response.GetStream(), nil
 }
 
+// tailInput requests the live events of the log group matching the filter. Events of
+// the --request-id invocation are picked out as they arrive, see follows.
+func (l *LogGroup) tailInput() *cloudwatchlogs.StartLiveTailInput {
+	input := &cloudwatchlogs.StartLiveTailInput{
+		LogGroupIdentifiers: []string{l.cloudwatch.Arn()},
+	}
+
+	if l.LogGroupFilter != "" {
+		input.LogEventFilterPattern = aws.String(l.LogGroupFilter)
+	}
+
+	return input
+}
+
 func (l *LogGroup) Tail(ctx context.Context) error {
 	// First, dump existing logs from --ago duration before starting the live tail
 	if err := l.dumpLogsForTail(ctx); err != nil {
@@ -150,12 +333,13 @@ func (l *LogGroup) Tail(ctx context.Context) error {
 				// successfully started
 			case *types.StartLiveTailResponseStreamMemberSessionUpdate:
 				for _, logEvent := range e.Value.SessionResults {
-					timestamp := time.Unix(*logEvent.Timestamp/1000, 0).Format("2006-01-02 15:04:05")
 					message := ""
 					if logEvent.Message != nil {
-						message = strings.TrimSuffix(*logEvent.Message, "\n")
+						message = *logEvent.Message
+					}
+					if l.follows(aws.ToString(logEvent.LogStreamName), message) {
+						l.write(os.Stdout, *logEvent.Timestamp, message)
 					}
-					fmt.Printf("%s %s\n", timestamp, message)
 				}
 			default:
 				if err := session.Err(); err != nil {
@@ -170,3 +354,115 @@ func (l *LogGroup) Tail(ctx context.Context) error {
 		}
 	}
 }
+
+//
+// Filters
+//
+
+// invocation is the time range of a log stream holding the logs of an invocation
+type invocation struct {
+	stream     string
+	start, end time.Time
+}
+
+// invocations returns the ranges of the streams holding the events of the request id, from
+// its START line to its REPORT line, or to end when the invocation had not finished by then
+func invocations(events []types.FilteredLogEvent, id string, end time.Time) []invocation {
+	var found []invocation
+	index := map[string]int{}
+
+	for _, event := range events {
+		stream := aws.ToString(event.LogStreamName)
+		timestamp := time.UnixMilli(aws.ToInt64(event.Timestamp))
+
+		i, ok := index[stream]
+		if !ok {
+			i = len(found)
+			index[stream] = i
+			found = append(found, invocation{stream: stream, start: timestamp, end: end})
+		}
+
+		if strings.HasPrefix(aws.ToString(event.Message), "REPORT RequestId: "+id) {
+			found[i].end = timestamp
+		}
+	}
+
+	return found
+}
+
+// follows reports whether a live event belongs to the --request-id invocation, being a
+// line holding the request id or a line of its log stream between its START and END lines.
+// The tail is filtered by --filter alone, as its pattern cannot also require the request id.
+func (l *LogGroup) follows(stream, message string) bool {
+	id := l.LogGroupRequestId
+	if id == "" {
+		return true
+	}
+
+	if l.streams == nil {
+		l.streams = map[string]bool{}
+	}
+
+	switch {
+	case strings.HasPrefix(message, "START RequestId: "+id):
+		l.streams[stream] = true
+	case strings.HasPrefix(message, "END RequestId: "+id):
+		delete(l.streams, stream)
+		return true
+	}
+
+	return l.streams[stream] || strings.Contains(message, id)
+}
+
+//
+// Output
+//
+
+var levelStyles = map[string]lipgloss.Style{
+	"trace":   lipgloss.NewStyle().Faint(true),
+	"debug":   lipgloss.NewStyle().Faint(true),
+	"info":    lipgloss.NewStyle().Foreground(lipgloss.Color("2")),
+	"warn":    lipgloss.NewStyle().Foreground(lipgloss.Color("3")),
+	"warning": lipgloss.NewStyle().Foreground(lipgloss.Color("3")),
+	"error":   lipgloss.NewStyle().Foreground(lipgloss.Color("1")),
+	"fatal":   lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Bold(true),
+	"panic":   lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Bold(true),
+}
+
+// write writes an event in the --output format
+func (l *LogGroup) write(w io.Writer, timestamp int64, message string) {
+	message = strings.TrimSuffix(message, "\n")
+
+	if l.LogGroupOutput == "json" {
+		message = pretty(message)
+	}
+
+	fmt.Fprintf(w, "%s %s\n", time.UnixMilli(timestamp).Format("2006-01-02 15:04:05"), message)
+}
+
+// pretty indents a JSON message and colors it by its level, leaving other messages as they are
+func pretty(message string) string {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(message), &fields); err != nil {
+		return message
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(message), "", "  "); err != nil {
+		return message
+	}
+
+	level, _ := fields["level"].(string)
+	style, ok := levelStyles[strings.ToLower(level)]
+	if !ok {
+		return indented.String()
+	}
+
+	// lines are styled one by one, as lipgloss pads a block to its widest line
+	lines := strings.Split(indented.String(), "\n")
+	for i, line := range lines {
+		lines[i] = style.Render(line)
+	}
+
+	return strings.Join(lines, "\n")
+}
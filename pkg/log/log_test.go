@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -190,4 +192,153 @@ func TestNewlineHandling(t *testing.T) {
 			assert.Equal(t, tt.expected, result)
 		})
 	}
-}
\ No newline at end of file
+}
+func TestLogGroup_Validate(t *testing.T) {
+	assert.NoError(t, (&LogGroup{}).Validate())
+	assert.NoError(t, (&LogGroup{LogGroupSince: "2025-06-01 12:00", LogGroupUntil: "2025-06-01T13:00:00Z", LogGroupOutput: "json"}).Validate())
+	assert.NoError(t, (&LogGroup{LogGroupRequestId: "8f5e3a3c-5a0e-4c5b-9d6e-0f1a2b3c4d5e"}).Validate())
+
+	assert.Error(t, (&LogGroup{LogGroupSince: "yesterday"}).Validate())
+	assert.Error(t, (&LogGroup{LogGroupSince: "2025-06-01", LogGroupAgo: "1h"}).Validate())
+	assert.Error(t, (&LogGroup{LogGroupUntil: "2025-06-01", LogGroupTail: true}).Validate())
+	assert.Error(t, (&LogGroup{LogGroupRequestId: `" OR "`}).Validate())
+	assert.Error(t, (&LogGroup{LogGroupOutput: "yaml"}).Validate())
+
+	assert.ErrorContains(t, (&LogGroup{LogGroupSince: "2025-06-01 13:00", LogGroupUntil: "2025-06-01 12:00"}).Validate(), "later than --since")
+	assert.NoError(t, (&LogGroup{LogGroupFilter: "ERROR", LogGroupRequestId: "8f5e3a3c"}).Validate())
+	assert.NoError(t, (&LogGroup{LogGroupFilter: "ERROR", LogGroupRequestId: "8f5e3a3c", LogGroupTail: true}).Validate())
+}
+
+func TestLogGroup_Window(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+
+	start, end, err := (&LogGroup{}).window(now, 30*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-30*time.Second), start)
+	assert.Equal(t, now, end)
+
+	// --until alone ends the default range at it
+	start, end, err = (&LogGroup{LogGroupUntil: "2025-05-01 08:00"}).window(now, 30*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 5, 1, 7, 59, 30, 0, time.Local), start)
+	assert.Equal(t, time.Date(2025, 5, 1, 8, 0, 0, 0, time.Local), end)
+
+	start, _, err = (&LogGroup{LogGroupSince: "2025-06-01 11:00"}).window(now, 30*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 6, 1, 11, 0, 0, 0, time.Local), start)
+
+	_, _, err = (&LogGroup{LogGroupSince: "2025-06-01 13:00"}).window(now, 0)
+	assert.ErrorContains(t, err, "is later than the end")
+}
+
+func event(stream string, timestamp time.Time, message string) types.FilteredLogEvent {
+	return types.FilteredLogEvent{
+		LogStreamName: aws.String(stream),
+		Timestamp:     aws.Int64(timestamp.UnixMilli()),
+		Message:       aws.String(message),
+	}
+}
+
+func TestInvocations(t *testing.T) {
+	id := "8f5e3a3c"
+	at := time.UnixMilli(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC).UnixMilli())
+	end := at.Add(time.Hour)
+
+	found := invocations([]types.FilteredLogEvent{
+		event("a", at, "START RequestId: "+id+" Version: $LATEST\n"),
+		event("a", at.Add(time.Second), "END RequestId: "+id+"\n"),
+		event("a", at.Add(time.Second), "REPORT RequestId: "+id+"\tDuration: 1000 ms\n"),
+		event("b", at.Add(time.Minute), "START RequestId: "+id+" Version: $LATEST\n"),
+	}, id, end)
+
+	require.Len(t, found, 2)
+	assert.Equal(t, invocation{stream: "a", start: at, end: at.Add(time.Second)}, found[0])
+	assert.Equal(t, invocation{stream: "b", start: at.Add(time.Minute), end: end}, found[1], "unfinished invocations run to the end of the range")
+}
+
+func TestLogGroup_Follows(t *testing.T) {
+	id := "8f5e3a3c"
+	l := &LogGroup{LogGroupRequestId: id}
+
+	assert.False(t, l.follows("a", "listening on :8080"))
+	assert.True(t, l.follows("a", "START RequestId: "+id+" Version: $LATEST"))
+	assert.True(t, l.follows("a", `{"level":"info","message":"handled"}`), "lines of the invocation need not hold its id")
+	assert.False(t, l.follows("b", `{"level":"info","message":"other"}`), "other streams serve other invocations")
+	assert.True(t, l.follows("a", "END RequestId: "+id))
+	assert.True(t, l.follows("a", "REPORT RequestId: "+id+"\tDuration: 1000 ms"))
+	assert.False(t, l.follows("a", "START RequestId: 0a1b2c3d Version: $LATEST"))
+	assert.False(t, l.follows("a", `{"level":"info","message":"next"}`))
+
+	assert.True(t, (&LogGroup{}).follows("a", "anything"))
+}
+
+func TestLogGroup_FollowsFiltered(t *testing.T) {
+	id := "8f5e3a3c"
+	l := &LogGroup{LogGroupFilter: `{ $.level = "error" }`, LogGroupRequestId: id}
+
+	// the tail only delivers lines matching --filter, so the invocation's START may never arrive
+	assert.True(t, l.follows("a", `{"level":"error","requestId":"`+id+`"}`))
+	assert.False(t, l.follows("a", `{"level":"error","requestId":"0a1b2c3d"}`))
+	assert.False(t, l.follows("b", `{"level":"error","message":"other"}`))
+}
+
+func TestLogGroup_Inputs(t *testing.T) {
+	setup := mock.NewTestSetup()
+	setup.Apply(t)
+	ctx := context.Background()
+
+	cloudwatchConfig, err := cloudwatch.Derive(ctx, setup.Basis)
+	if err != nil {
+		t.Skip("CloudWatch config failed (expected in test env):", err)
+	}
+
+	logGroup := &LogGroup{LogGroupFilter: "ERROR", cloudwatch: cloudwatchConfig}
+
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	filter := logGroup.filterInput(start, start.Add(time.Hour))
+	assert.Equal(t, "ERROR", *filter.FilterPattern)
+	assert.Equal(t, start.UnixMilli(), *filter.StartTime)
+	assert.Equal(t, start.Add(time.Hour).UnixMilli(), *filter.EndTime)
+
+	tail := logGroup.tailInput()
+	assert.Equal(t, "ERROR", *tail.LogEventFilterPattern, "tails honour the same filters")
+
+	logGroup.LogGroupRequestId = "8f5e3a3c"
+	assert.Equal(t, "ERROR", *logGroup.filterInput(start, start).FilterPattern, "invocations are found apart from the filter")
+	assert.Equal(t, "ERROR", *logGroup.tailInput().LogEventFilterPattern, "the request id is followed apart from the filter")
+	logGroup.LogGroupRequestId = ""
+
+	logGroup.LogGroupFilter = ""
+	assert.Nil(t, logGroup.filterInput(start, start).FilterPattern)
+	assert.Nil(t, logGroup.tailInput().LogEventFilterPattern)
+}
+
+func TestLogGroup_Write(t *testing.T) {
+	timestamp := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local).UnixMilli()
+	message := `{"level":"INFO","message":"hello"}` + "\n"
+
+	var raw strings.Builder
+	(&LogGroup{}).write(&raw, timestamp, message)
+	assert.Equal(t, "2025-06-01 12:00:00 {\"level\":\"INFO\",\"message\":\"hello\"}\n", raw.String())
+
+	var pretty strings.Builder
+	l := &LogGroup{LogGroupOutput: "json"}
+	l.write(&pretty, timestamp, message)
+	l.write(&pretty, timestamp, "START RequestId: 8f5e3a3c Version: $LATEST\n")
+	assert.Contains(t, pretty.String(), "2025-06-01 12:00:00 {\n")
+	assert.Contains(t, pretty.String(), `  "message": "hello"`)
+	assert.Contains(t, pretty.String(), "2025-06-01 12:00:00 START RequestId: 8f5e3a3c Version: $LATEST\n", "other messages are written raw")
+}
+
+func TestParseTime(t *testing.T) {
+	parsed, err := parseTime("2025-06-01T12:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC), parsed.UTC())
+
+	parsed, err = parseTime("2025-06-01 12:30")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 6, 1, 12, 30, 0, 0, time.Local), parsed)
+
+	_, err = parseTime("06/01/2025")
+	assert.ErrorContains(t, err, "invalid time format")
+}
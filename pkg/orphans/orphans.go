@@ -0,0 +1,385 @@
+package orphans
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	gitbasis "github.com/bkeane/monad/pkg/basis/git"
+	"github.com/bkeane/monad/pkg/state"
+	"github.com/bkeane/monad/pkg/step"
+	"github.com/bkeane/monad/pkg/step/apigateway"
+	"github.com/bkeane/monad/pkg/step/eventbridge"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/charmbracelet/lipgloss/table"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/rs/zerolog/log"
+)
+
+//
+// Dependencies
+//
+
+type Basis interface {
+	Git() (*gitbasis.Basis, error)
+}
+
+// Scanner finds the resources monad has created in the account
+type Scanner interface {
+	Scan(ctx context.Context) (*Scan, error)
+}
+
+// Deleter deletes an orphaned resource with the delete operation of its step, returning
+// ErrNotOrphaned when the resource is found to be in use again
+type Deleter func(ctx context.Context, resource Resource) error
+
+// ErrNotOrphaned is returned for a resource whose function or role has been created since the scan
+var ErrNotOrphaned = errors.New("no longer orphaned")
+
+type Kind string
+
+// Kinds are listed in the order orphans are deleted, as routes must go before
+// their integration and roles before the policy attached to them
+const (
+	Route       Kind = "route"
+	Rule        Kind = "rule"
+	Function    Kind = "function"
+	Role        Kind = "role"
+	Integration Kind = "integration"
+	Policy      Kind = "policy"
+	LogGroup    Kind = "log group"
+)
+
+var kinds = []Kind{Route, Rule, Function, Role, Integration, Policy, LogGroup}
+
+type Status string
+
+const (
+	Orphaned Status = "orphaned"
+	Deleted  Status = "deleted"
+	Skipped  Status = "skipped"
+	Failed   Status = "failed"
+)
+
+// Resource is a resource created by monad for the function it belongs to
+type Resource struct {
+	Kind       Kind
+	Name       string
+	Function   string               // name of the function the resource was created for
+	Role       string               // name of the role a function runs as, or a policy is attached to
+	ApiId      string               // api of a route or integration
+	Id         string               // id of a route or integration within its api
+	Bus        string               // bus of a rule
+	Modified   time.Time            // when the resource was created or last modified, zero when unknown
+	Deployment *state.StateMetadata // service the resource is tagged with, nil when untagged
+}
+
+// Scan is what a Scanner found in the account
+type Scan struct {
+	Functions map[string]bool // names of every function
+	Roles     map[string]bool // names of every role
+	Resources []Resource      // resources carrying monad tags or naming
+}
+
+// Result records what became of an orphaned resource
+type Result struct {
+	Resource Resource
+	Reason   string
+	Status   Status
+	Error    string
+}
+
+//
+// Orphans
+//
+
+type Orphans struct {
+	OrphansDelete []string `env:"MONAD_DELETE" flag:"--delete" usage:"Delete the orphaned resources of these kinds, * for all but functions (route, rule, function, role, integration, policy, log-group)" hint:"kind"`
+	OrphansGrace  int32    `env:"MONAD_ORPHANS_GRACE" flag:"--grace" usage:"Leave resources created or modified within this many minutes (default 60)" hint:"min"`
+	owner         string
+	repo          string
+	scanner       Scanner
+	deleter       Deleter
+}
+
+//
+// Derive
+//
+
+func Derive(ctx context.Context, basis Basis, scanner Scanner, deleter Deleter) (*Orphans, error) {
+	var err error
+	var orphans Orphans
+
+	if err = env.Parse(&orphans); err != nil {
+		return nil, err
+	}
+
+	git, err := basis.Git()
+	if err != nil {
+		return nil, err
+	}
+
+	if orphans.OrphansGrace == 0 {
+		orphans.OrphansGrace = 60
+	}
+
+	orphans.owner = git.Owner()
+	orphans.repo = git.Repo()
+	orphans.scanner = scanner
+	orphans.deleter = deleter
+
+	if err = orphans.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &orphans, nil
+}
+
+func (o *Orphans) Validate() error {
+	return v.ValidateStruct(o,
+		v.Field(&o.owner, v.Required),
+		v.Field(&o.repo, v.Required),
+		v.Field(&o.scanner, v.Required),
+		v.Field(&o.OrphansDelete, v.Each(v.By(deletable))),
+		v.Field(&o.OrphansGrace, v.Min(int32(1))),
+	)
+}
+
+func deletable(value interface{}) error {
+	if name := value.(string); name != "*" && !slices.Contains(kinds, kind(name)) {
+		return fmt.Errorf("must be * or a kind of resource")
+	}
+	return nil
+}
+
+//
+// Orphans
+//
+
+// Do finds the orphaned resources of the repo, * for every repo, and deletes those of the
+// kinds given to --delete. Every orphan is attempted regardless of the others failing.
+func (o *Orphans) Do(ctx context.Context) ([]Result, error) {
+	scan, err := o.scanner.Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	var errs []error
+
+	for _, result := range o.find(scan, time.Now()) {
+		event := log.Info().
+			Str("kind", string(result.Resource.Kind)).
+			Str("name", result.Resource.Name).
+			Str("reason", result.Reason)
+
+		if !o.deletes(result.Resource.Kind) {
+			event.Msg("orphan")
+			results = append(results, result)
+			continue
+		}
+
+		event.Msg("delete")
+
+		// a policy is detached from its role before deletion, unless the role is gone
+		if result.Resource.Kind == Policy && scan.Roles[result.Resource.Function] {
+			result.Resource.Role = result.Resource.Function
+		}
+
+		err := o.deleter(ctx, result.Resource)
+		if errors.Is(err, ErrNotOrphaned) {
+			log.Info().
+				Str("kind", string(result.Resource.Kind)).
+				Str("name", result.Resource.Name).
+				Msg("no longer orphaned")
+			result.Status = Skipped
+			results = append(results, result)
+			continue
+		}
+
+		if err != nil {
+			err = fmt.Errorf("%s %s: %w", result.Resource.Kind, result.Resource.Name, err)
+			log.Error().Err(err).Msg("delete failed")
+			result.Status = Failed
+			result.Error = err.Error()
+			errs = append(errs, err)
+		} else {
+			result.Status = Deleted
+			if result.Resource.Kind == Role {
+				delete(scan.Roles, result.Resource.Name)
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		log.Info().Msg("no orphaned resources")
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// find returns the resources of the repo whose function no longer exists, and the
+// functions whose role no longer exists, in the order they are to be deleted. Resources
+// modified within the grace period are left out, as a deploy in progress creates the
+// role, policy and log group of a service before its function. Routes, integrations
+// and rules are only created once the function exists.
+func (o *Orphans) find(scan *Scan, now time.Time) []Result {
+	grace := time.Duration(o.OrphansGrace) * time.Minute
+
+	// untagged resources are attributed to the service of a tagged resource of the same function
+	deployments := map[string]*state.StateMetadata{}
+	for _, resource := range scan.Resources {
+		if resource.Deployment != nil {
+			deployments[resource.Function] = resource.Deployment
+		}
+	}
+
+	var results []Result
+	for _, resource := range scan.Resources {
+		if resource.Deployment == nil {
+			resource.Deployment = deployments[resource.Function]
+		}
+
+		if !o.matches(resource) {
+			continue
+		}
+
+		reason := ""
+		switch {
+		case resource.Kind == Function && !scan.Roles[resource.Role]:
+			reason = "role " + resource.Role + " missing"
+		case resource.Kind != Function && !scan.Functions[resource.Function]:
+			reason = "function " + resource.Function + " missing"
+		default:
+			continue
+		}
+
+		if !resource.Modified.IsZero() && now.Sub(resource.Modified) < grace {
+			log.Info().
+				Str("kind", string(resource.Kind)).
+				Str("name", resource.Name).
+				Time("modified", resource.Modified).
+				Msg("orphan modified within grace period")
+			continue
+		}
+
+		results = append(results, Result{Resource: resource, Reason: reason, Status: Orphaned})
+	}
+
+	slices.SortFunc(results, func(a, b Result) int {
+		if c := slices.Index(kinds, a.Resource.Kind) - slices.Index(kinds, b.Resource.Kind); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Resource.Name, b.Resource.Name)
+	})
+
+	return results
+}
+
+// deletes reports whether --delete names the kind. Functions are deleted only when named,
+// as a function whose role is missing may still be serving.
+func (o *Orphans) deletes(k Kind) bool {
+	for _, name := range o.OrphansDelete {
+		if kind(name) == k || (name == "*" && k != Function) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the resource belongs to the owner and repo, where * matches all.
+// Untagged resources are matched on the repo their function is named after.
+func (o *Orphans) matches(resource Resource) bool {
+	if deployment := resource.Deployment; deployment != nil {
+		return (o.owner == "*" || o.owner == deployment.Owner) && (o.repo == "*" || o.repo == deployment.Repo)
+	}
+
+	return o.repo == "*" || strings.HasPrefix(resource.Function, o.repo+"-")
+}
+
+//
+// Delete
+//
+
+// Delete deletes the resource with the delete operations of the steps derived for its service.
+// A resource of a service is first checked to still be orphaned, as its function or role may
+// have been deployed since the scan. The caller holds the lock of the service meanwhile.
+func Delete(ctx context.Context, steps *step.Steps, resource Resource) error {
+	if resource.Deployment != nil {
+		var prober step.Prober = steps.Lambda()
+		if resource.Kind == Function {
+			prober = steps.IAM()
+		}
+
+		exists, err := prober.Exists(ctx)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			return ErrNotOrphaned
+		}
+	}
+
+	switch resource.Kind {
+	case Route:
+		_, err := steps.ApiGateway().DeleteRoute(ctx, apigateway.Route{ApiId: resource.ApiId, RouteId: resource.Id})
+		return err
+	case Integration:
+		_, err := steps.ApiGateway().DeleteIntegration(ctx, apigateway.Integration{ApiId: resource.ApiId, IntegrationId: resource.Id})
+		return err
+	case Rule:
+		return steps.EventBridge().DeleteRule(ctx, eventbridge.EventBridgeRule{BusName: resource.Bus, RuleName: resource.Name})
+	case Function:
+		_, err := steps.Lambda().DeleteFunction(ctx)
+		return err
+	case Role:
+		if err := steps.IAM().DetachRolePolicy(ctx); err != nil {
+			return err
+		}
+		return steps.IAM().DeleteRole(ctx)
+	case Policy:
+		if resource.Role != "" {
+			if err := steps.IAM().DetachRolePolicy(ctx); err != nil {
+				return err
+			}
+		}
+		return steps.IAM().DeletePolicy(ctx)
+	case LogGroup:
+		return steps.CloudWatch().DeleteLogGroup(ctx)
+	default:
+		return fmt.Errorf("unknown resource kind %s", resource.Kind)
+	}
+}
+
+// kind returns the kind of a --delete name, where spaces are written as dashes
+func kind(name string) Kind {
+	return Kind(strings.ReplaceAll(name, "-", " "))
+}
+
+//
+// Table
+//
+
+// Table renders the results as a table
+func Table(results []Result) string {
+	tbl := table.New()
+	tbl.Headers("Kind", "Name", "Service", "Branch", "Reason", "Status")
+
+	for _, result := range results {
+		service, branch := "", ""
+		if deployment := result.Resource.Deployment; deployment != nil {
+			service, branch = deployment.Service, deployment.Branch
+		}
+
+		tbl.Row(string(result.Resource.Kind), result.Resource.Name, service, branch, result.Reason, string(result.Status))
+	}
+
+	return tbl.Render()
+}
@@ -0,0 +1,212 @@
+package orphans
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bkeane/monad/pkg/state"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeScanner Scan
+
+func (f *fakeScanner) Scan(ctx context.Context) (*Scan, error) {
+	scan := Scan(*f)
+	return &scan, nil
+}
+
+func deployment(repo, branch, service string) *state.StateMetadata {
+	return &state.StateMetadata{Service: service, Owner: "acme", Repo: repo, Branch: branch, Sha: "0123456789abcdef"}
+}
+
+// now is the time orphans are found at
+var now = time.Now()
+
+// scan holds a live api, a destroyed worker whose role and routes remain, a function of
+// another repo, a function whose role was deleted, and the role and log group of a
+// deploy creating its function
+func scan() *Scan {
+	api := deployment("shop", "main", "api")
+	worker := deployment("shop", "wip", "worker")
+	billing := deployment("billing", "main", "api")
+	broken := deployment("shop", "main", "cron")
+	deploying := deployment("shop", "feat", "api")
+
+	return &Scan{
+		Functions: map[string]bool{"shop-main-api": true, "shop-main-cron": true},
+		Roles:     map[string]bool{"shop-main-api": true, "shop-wip-worker": true},
+		Resources: []Resource{
+			{Kind: Function, Name: "shop-main-api", Function: "shop-main-api", Role: "shop-main-api", Deployment: api},
+			{Kind: Role, Name: "shop-main-api", Function: "shop-main-api", Deployment: api},
+			{Kind: Route, Name: "a1 GET /api", Function: "shop-main-api", ApiId: "a1", Id: "r1"},
+			{Kind: Role, Name: "shop-wip-worker", Function: "shop-wip-worker", Deployment: worker},
+			{Kind: Policy, Name: "shop-wip-worker", Function: "shop-wip-worker", Deployment: worker},
+			{Kind: LogGroup, Name: "/aws/lambda/shop-wip-worker", Function: "shop-wip-worker", Deployment: worker},
+			{Kind: Integration, Name: "a1 i2", Function: "shop-wip-worker", ApiId: "a1", Id: "i2"},
+			{Kind: Route, Name: "a1 ANY /worker", Function: "shop-wip-worker", ApiId: "a1", Id: "r2"},
+			{Kind: Rule, Name: "nightly", Function: "shop-wip-worker", Bus: "default", Deployment: worker},
+			{Kind: Role, Name: "billing-main-api", Function: "billing-main-api", Deployment: billing},
+			{Kind: Integration, Name: "a1 i3", Function: "billing-main-api", ApiId: "a1", Id: "i3"},
+			{Kind: Function, Name: "shop-main-cron", Function: "shop-main-cron", Role: "shop-main-cron", Modified: now.Add(-24 * time.Hour), Deployment: broken},
+			{Kind: Role, Name: "shop-feat-api", Function: "shop-feat-api", Modified: now.Add(-time.Minute), Deployment: deploying},
+			{Kind: LogGroup, Name: "/aws/lambda/shop-feat-api", Function: "shop-feat-api", Modified: now.Add(-time.Minute), Deployment: deploying},
+		},
+	}
+}
+
+func orphans(scan *Scan, deleter Deleter) *Orphans {
+	return &Orphans{
+		OrphansGrace: 60,
+		owner:        "acme",
+		repo:         "shop",
+		scanner:      (*fakeScanner)(scan),
+		deleter:      deleter,
+	}
+}
+
+func names(results []Result) []string {
+	var names []string
+	for _, result := range results {
+		names = append(names, string(result.Resource.Kind)+" "+result.Resource.Name)
+	}
+	return names
+}
+
+func TestFind(t *testing.T) {
+	results := orphans(nil, nil).find(scan(), now)
+
+	assert.Equal(t, []string{
+		"route a1 ANY /worker",
+		"rule nightly",
+		"function shop-main-cron",
+		"role shop-wip-worker",
+		"integration a1 i2",
+		"policy shop-wip-worker",
+		"log group /aws/lambda/shop-wip-worker",
+	}, names(results))
+
+	assert.Equal(t, "function shop-wip-worker missing", results[0].Reason)
+	assert.Equal(t, "worker", results[0].Resource.Deployment.Service, "untagged routes belong to the service of their function")
+	assert.Equal(t, "role shop-main-cron missing", results[2].Reason)
+}
+
+func TestFind_Grace(t *testing.T) {
+	o := orphans(nil, nil)
+	o.OrphansGrace = 1
+
+	results := o.find(scan(), now.Add(time.Minute))
+	assert.Contains(t, names(results), "role shop-feat-api", "resources are found once the grace period has passed")
+	assert.Contains(t, names(results), "log group /aws/lambda/shop-feat-api")
+}
+
+func TestFind_AllRepos(t *testing.T) {
+	o := orphans(nil, nil)
+	o.owner, o.repo = "*", "*"
+
+	results := o.find(scan(), now)
+	assert.Contains(t, names(results), "role billing-main-api")
+	assert.Contains(t, names(results), "integration a1 i3")
+}
+
+func TestDo_Report(t *testing.T) {
+	o := orphans(scan(), func(ctx context.Context, resource Resource) error {
+		t.Fatal("nothing should be deleted")
+		return nil
+	})
+
+	results, err := o.Do(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, results, 7)
+	assert.Equal(t, Orphaned, results[0].Status)
+	assert.Contains(t, Table(results), "shop-wip-worker")
+}
+
+func TestDo_Delete(t *testing.T) {
+	var deleted []string
+	o := orphans(scan(), func(ctx context.Context, resource Resource) error {
+		deleted = append(deleted, string(resource.Kind)+" "+resource.Name)
+		if resource.Kind == Policy {
+			return errors.New("policy is attached")
+		}
+		return nil
+	})
+	o.OrphansDelete = []string{"*"}
+
+	results, err := o.Do(context.Background())
+	assert.EqualError(t, err, "policy shop-wip-worker: policy is attached")
+	assert.NotContains(t, deleted, "function shop-main-cron", "functions are deleted only when named")
+	assert.Len(t, deleted, 6)
+	assert.Equal(t, Deleted, results[0].Status)
+	assert.Equal(t, Orphaned, results[2].Status)
+	assert.Equal(t, Failed, results[5].Status)
+}
+
+func TestDo_DeleteKinds(t *testing.T) {
+	var deleted []string
+	o := orphans(scan(), func(ctx context.Context, resource Resource) error {
+		deleted = append(deleted, string(resource.Kind)+" "+resource.Name)
+		if resource.Kind == Route {
+			return ErrNotOrphaned
+		}
+		return nil
+	})
+	o.OrphansDelete = []string{"function", "route", "log-group"}
+
+	results, err := o.Do(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"route a1 ANY /worker",
+		"function shop-main-cron",
+		"log group /aws/lambda/shop-wip-worker",
+	}, deleted)
+	assert.Equal(t, Skipped, results[0].Status, "resources in use again are skipped")
+	assert.Equal(t, Orphaned, results[1].Status)
+	assert.Equal(t, Deleted, results[2].Status)
+}
+
+func TestDo_DeletePolicy(t *testing.T) {
+	var roles []string
+	o := orphans(scan(), func(ctx context.Context, resource Resource) error {
+		if resource.Kind == Policy {
+			roles = append(roles, resource.Role)
+		}
+		return nil
+	})
+
+	o.OrphansDelete = []string{"policy"}
+	_, err := o.Do(context.Background())
+	require.NoError(t, err)
+
+	o.OrphansDelete = []string{"role", "policy"}
+	_, err = o.Do(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"shop-wip-worker", ""}, roles, "a policy is not detached from a role deleted before it")
+}
+
+func TestValidate(t *testing.T) {
+	o := orphans(scan(), nil)
+	o.OrphansDelete = []string{"*", "function", "log-group"}
+	assert.NoError(t, o.Validate())
+
+	o.OrphansDelete = []string{"true"}
+	assert.Error(t, o.Validate())
+}
+
+func TestHelpers(t *testing.T) {
+	assert.Equal(t, "shop-main-api", function("arn:aws:lambda:us-east-1:123456789012:function:shop-main-api:live"))
+	assert.Equal(t, "shop-main-api", function("arn:aws:lambda:us-east-1:123456789012:function:shop-main-api"))
+	assert.Empty(t, function("https://example.com"))
+
+	assert.Equal(t, "shop-main-api", after("arn:aws:iam::123456789012:role/shop-main-api", "/"))
+	assert.Equal(t, "/aws/lambda/shop-main-api", after("arn:aws:logs:us-east-1:123456789012:log-group:/aws/lambda/shop-main-api", ":log-group:"))
+
+	assert.Nil(t, monad(map[string]string{"Service": "api"}, Resource{Kind: Role}))
+	resource := monad(map[string]string{"Monad": "true", "Service": "api", "Repo": "shop"}, Resource{Kind: Role})
+	require.NotNil(t, resource)
+	assert.Equal(t, "api", resource.Deployment.Service)
+}
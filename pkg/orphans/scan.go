@@ -0,0 +1,444 @@
+package orphans
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bkeane/monad/pkg/state"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+)
+
+// scanConcurrency bounds the tag requests in flight while scanning
+const scanConcurrency = 10
+
+// forwardedPrefix is the request parameter monad sets on every integration it creates
+const forwardedPrefix = "overwrite:header.X-Forwarded-Prefix"
+
+// lastModified is the layout of the LastModified time of a lambda function
+const lastModified = "2006-01-02T15:04:05.000-0700"
+
+//
+// AwsScanner
+//
+
+// AwsScanner finds monad resources by their tags, or by the request parameters of
+// api integrations, which cannot be tagged.
+type AwsScanner struct {
+	lambda      *lambda.Client
+	iam         *iam.Client
+	logs        *cloudwatchlogs.Client
+	eventbridge *eventbridge.Client
+	apigateway  *apigatewayv2.Client
+}
+
+func NewScanner(config aws.Config) *AwsScanner {
+	return &AwsScanner{
+		lambda:      lambda.NewFromConfig(config),
+		iam:         iam.NewFromConfig(config),
+		logs:        cloudwatchlogs.NewFromConfig(config),
+		eventbridge: eventbridge.NewFromConfig(config),
+		apigateway:  apigatewayv2.NewFromConfig(config),
+	}
+}
+
+// Scan lists every function and role along with the resources monad created
+func (s *AwsScanner) Scan(ctx context.Context) (*Scan, error) {
+	scan := &Scan{
+		Functions: map[string]bool{},
+		Roles:     map[string]bool{},
+	}
+
+	scanners := []func(context.Context, *Scan) error{
+		s.functions,
+		s.roles,
+		s.policies,
+		s.logGroups,
+		s.rules,
+		s.routes,
+	}
+
+	for _, scanner := range scanners {
+		if err := scanner(ctx, scan); err != nil {
+			return nil, err
+		}
+	}
+
+	return scan, nil
+}
+
+func (s *AwsScanner) functions(ctx context.Context, scan *Scan) error {
+	var arns []string
+	roles := map[string]string{}
+	modified := map[string]time.Time{}
+
+	paginator := lambda.NewListFunctionsPaginator(s.lambda, &lambda.ListFunctionsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, function := range page.Functions {
+			arn := aws.ToString(function.FunctionArn)
+			scan.Functions[aws.ToString(function.FunctionName)] = true
+			roles[arn] = after(aws.ToString(function.Role), "/")
+			modified[arn], _ = time.Parse(lastModified, aws.ToString(function.LastModified))
+			arns = append(arns, arn)
+		}
+	}
+
+	resources, err := tagged(ctx, arns, func(ctx context.Context, arn string) (*Resource, error) {
+		output, err := s.lambda.ListTags(ctx, &lambda.ListTagsInput{Resource: aws.String(arn)})
+		if err != nil {
+			return nil, err
+		}
+
+		name := after(arn, ":")
+		return monad(output.Tags, Resource{Kind: Function, Name: name, Function: name, Role: roles[arn], Modified: modified[arn]}), nil
+	})
+
+	scan.Resources = append(scan.Resources, resources...)
+	return err
+}
+
+func (s *AwsScanner) roles(ctx context.Context, scan *Scan) error {
+	var names []string
+	created := map[string]time.Time{}
+
+	paginator := iam.NewListRolesPaginator(s.iam, &iam.ListRolesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, role := range page.Roles {
+			scan.Roles[aws.ToString(role.RoleName)] = true
+
+			// monad roles are created without a path, unlike service linked roles
+			if aws.ToString(role.Path) == "/" {
+				names = append(names, aws.ToString(role.RoleName))
+				created[aws.ToString(role.RoleName)] = aws.ToTime(role.CreateDate)
+			}
+		}
+	}
+
+	resources, err := tagged(ctx, names, func(ctx context.Context, name string) (*Resource, error) {
+		output, err := s.iam.ListRoleTags(ctx, &iam.ListRoleTagsInput{RoleName: aws.String(name)})
+		if err != nil {
+			return nil, err
+		}
+
+		return monad(iamTags(output.Tags), Resource{Kind: Role, Name: name, Function: name, Modified: created[name]}), nil
+	})
+
+	scan.Resources = append(scan.Resources, resources...)
+	return err
+}
+
+func (s *AwsScanner) policies(ctx context.Context, scan *Scan) error {
+	var arns []string
+	updated := map[string]time.Time{}
+
+	paginator := iam.NewListPoliciesPaginator(s.iam, &iam.ListPoliciesInput{Scope: iamtypes.PolicyScopeTypeLocal})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, policy := range page.Policies {
+			arns = append(arns, aws.ToString(policy.Arn))
+			updated[aws.ToString(policy.Arn)] = aws.ToTime(policy.UpdateDate)
+		}
+	}
+
+	resources, err := tagged(ctx, arns, func(ctx context.Context, arn string) (*Resource, error) {
+		output, err := s.iam.ListPolicyTags(ctx, &iam.ListPolicyTagsInput{PolicyArn: aws.String(arn)})
+		if err != nil {
+			return nil, err
+		}
+
+		name := after(arn, "/")
+		return monad(iamTags(output.Tags), Resource{Kind: Policy, Name: name, Function: name, Modified: updated[arn]}), nil
+	})
+
+	scan.Resources = append(scan.Resources, resources...)
+	return err
+}
+
+func (s *AwsScanner) logGroups(ctx context.Context, scan *Scan) error {
+	var arns []string
+	created := map[string]time.Time{}
+
+	paginator := cloudwatchlogs.NewDescribeLogGroupsPaginator(s.logs, &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String("/aws/lambda/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, logGroup := range page.LogGroups {
+			arns = append(arns, aws.ToString(logGroup.LogGroupArn))
+			if logGroup.CreationTime != nil {
+				created[aws.ToString(logGroup.LogGroupArn)] = time.UnixMilli(*logGroup.CreationTime)
+			}
+		}
+	}
+
+	resources, err := tagged(ctx, arns, func(ctx context.Context, arn string) (*Resource, error) {
+		output, err := s.logs.ListTagsForResource(ctx, &cloudwatchlogs.ListTagsForResourceInput{ResourceArn: aws.String(arn)})
+		if err != nil {
+			return nil, err
+		}
+
+		name := after(arn, ":log-group:")
+		return monad(output.Tags, Resource{Kind: LogGroup, Name: name, Function: strings.TrimPrefix(name, "/aws/lambda/"), Modified: created[arn]}), nil
+	})
+
+	scan.Resources = append(scan.Resources, resources...)
+	return err
+}
+
+func (s *AwsScanner) rules(ctx context.Context, scan *Scan) error {
+	type rule struct{ arn, bus, name string }
+
+	var rules []rule
+	var busToken *string
+
+	for {
+		buses, err := s.eventbridge.ListEventBuses(ctx, &eventbridge.ListEventBusesInput{NextToken: busToken})
+		if err != nil {
+			return err
+		}
+
+		for _, bus := range buses.EventBuses {
+			var ruleToken *string
+
+			for {
+				output, err := s.eventbridge.ListRules(ctx, &eventbridge.ListRulesInput{EventBusName: bus.Name, NextToken: ruleToken})
+				if err != nil {
+					return err
+				}
+
+				for _, found := range output.Rules {
+					rules = append(rules, rule{arn: aws.ToString(found.Arn), bus: aws.ToString(bus.Name), name: aws.ToString(found.Name)})
+				}
+
+				if output.NextToken == nil {
+					break
+				}
+				ruleToken = output.NextToken
+			}
+		}
+
+		if buses.NextToken == nil {
+			break
+		}
+		busToken = buses.NextToken
+	}
+
+	resources, err := tagged(ctx, rules, func(ctx context.Context, rule rule) (*Resource, error) {
+		output, err := s.eventbridge.ListTagsForResource(ctx, &eventbridge.ListTagsForResourceInput{ResourceARN: aws.String(rule.arn)})
+		if err != nil {
+			return nil, err
+		}
+
+		tags := map[string]string{}
+		for _, tag := range output.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+
+		// rule names may be configured, so the function is named from the tags
+		resource := monad(tags, Resource{Kind: Rule, Name: rule.name, Bus: rule.bus})
+		if resource != nil {
+			resource.Function = strings.Join([]string{resource.Deployment.Repo, resource.Deployment.Branch, resource.Deployment.Service}, "-")
+		}
+
+		return resource, nil
+	})
+
+	scan.Resources = append(scan.Resources, resources...)
+	return err
+}
+
+func (s *AwsScanner) routes(ctx context.Context, scan *Scan) error {
+	var apiToken *string
+
+	for {
+		apis, err := s.apigateway.GetApis(ctx, &apigatewayv2.GetApisInput{NextToken: apiToken})
+		if err != nil {
+			return err
+		}
+
+		for _, api := range apis.Items {
+			if err := s.api(ctx, scan, aws.ToString(api.ApiId)); err != nil {
+				return err
+			}
+		}
+
+		if apis.NextToken == nil {
+			break
+		}
+		apiToken = apis.NextToken
+	}
+
+	return nil
+}
+
+// api adds the integrations monad created in the api along with the routes targeting them
+func (s *AwsScanner) api(ctx context.Context, scan *Scan, apiId string) error {
+	functions := map[string]string{}
+	var token *string
+
+	for {
+		output, err := s.apigateway.GetIntegrations(ctx, &apigatewayv2.GetIntegrationsInput{ApiId: aws.String(apiId), NextToken: token})
+		if err != nil {
+			return err
+		}
+
+		for _, integration := range output.Items {
+			if _, ok := integration.RequestParameters[forwardedPrefix]; !ok {
+				continue
+			}
+
+			id := aws.ToString(integration.IntegrationId)
+			functions[id] = function(aws.ToString(integration.IntegrationUri))
+
+			scan.Resources = append(scan.Resources, Resource{
+				Kind:     Integration,
+				Name:     apiId + " " + id,
+				Function: functions[id],
+				ApiId:    apiId,
+				Id:       id,
+			})
+		}
+
+		if output.NextToken == nil {
+			break
+		}
+		token = output.NextToken
+	}
+
+	token = nil
+	for {
+		output, err := s.apigateway.GetRoutes(ctx, &apigatewayv2.GetRoutesInput{ApiId: aws.String(apiId), NextToken: token})
+		if err != nil {
+			return err
+		}
+
+		for _, route := range output.Items {
+			name, ok := functions[strings.TrimPrefix(aws.ToString(route.Target), "integrations/")]
+			if !ok {
+				continue
+			}
+
+			scan.Resources = append(scan.Resources, Resource{
+				Kind:     Route,
+				Name:     apiId + " " + aws.ToString(route.RouteKey),
+				Function: name,
+				ApiId:    apiId,
+				Id:       aws.ToString(route.RouteId),
+			})
+		}
+
+		if output.NextToken == nil {
+			break
+		}
+		token = output.NextToken
+	}
+
+	return nil
+}
+
+//
+// Helpers
+//
+
+// tagged reads the tags of every item with bounded concurrency and returns the monad resources
+func tagged[T any](ctx context.Context, items []T, read func(context.Context, T) (*Resource, error)) ([]Resource, error) {
+	found := make([]*Resource, len(items))
+	errs := make([]error, len(items))
+	semaphore := make(chan struct{}, scanConcurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			found[i], errs[i] = read(ctx, item)
+		}()
+	}
+
+	wg.Wait()
+
+	var resources []Resource
+	for i, resource := range found {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if resource != nil {
+			resources = append(resources, *resource)
+		}
+	}
+
+	return resources, nil
+}
+
+// monad returns the resource with the service it is tagged with, or nil when it is not tagged by monad
+func monad(tags map[string]string, resource Resource) *Resource {
+	if tags["Monad"] != "true" {
+		return nil
+	}
+
+	resource.Deployment = &state.StateMetadata{
+		Service: tags["Service"],
+		Owner:   tags["Owner"],
+		Repo:    tags["Repo"],
+		Branch:  tags["Branch"],
+		Sha:     tags["Sha"],
+	}
+
+	return &resource
+}
+
+func iamTags(tags []iamtypes.Tag) map[string]string {
+	converted := map[string]string{}
+	for _, tag := range tags {
+		converted[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return converted
+}
+
+// function returns the name of the function an integration invokes, without its alias or version
+func function(uri string) string {
+	_, name, found := strings.Cut(uri, ":function:")
+	if !found {
+		return ""
+	}
+
+	name, _, _ = strings.Cut(name, ":")
+	return name
+}
+
+// after returns the part of s following the last separator
+func after(s, separator string) string {
+	if i := strings.LastIndex(s, separator); i >= 0 {
+		return s[i+len(separator):]
+	}
+	return s
+}
@@ -0,0 +1,233 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+//
+// Change
+//
+
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// Change is a single difference between deployed and desired state
+type Change struct {
+	Action   Action
+	Resource string
+	Name     string
+	Field    string
+	Before   string
+	After    string
+}
+
+//
+// Plan
+//
+
+// Plan collects the changes a step would make if mounted
+type Plan struct {
+	Step    string
+	Changes []Change
+}
+
+func New(step string) *Plan {
+	return &Plan{Step: step}
+}
+
+// Create records a resource that does not yet exist
+func (p *Plan) Create(resource, name string) {
+	p.Changes = append(p.Changes, Change{Action: Create, Resource: resource, Name: name})
+}
+
+// Delete records a resource that exists but is no longer desired
+func (p *Plan) Delete(resource, name string) {
+	p.Changes = append(p.Changes, Change{Action: Delete, Resource: resource, Name: name})
+}
+
+// Field records an update when the deployed and desired values differ
+func (p *Plan) Field(resource, name, field, before, after string) {
+	if before == after {
+		return
+	}
+
+	p.Changes = append(p.Changes, Change{
+		Action:   Update,
+		Resource: resource,
+		Name:     name,
+		Field:    field,
+		Before:   before,
+		After:    after,
+	})
+}
+
+// Map records per key additions, removals and updates between two maps
+func (p *Plan) Map(resource, name string, before, after map[string]string) {
+	keys := slices.Sorted(maps.Keys(before))
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		previous, existed := before[key]
+		desired, wanted := after[key]
+
+		switch {
+		case !existed:
+			p.Changes = append(p.Changes, Change{Action: Create, Resource: resource, Name: name, Field: key, After: desired})
+		case !wanted:
+			p.Changes = append(p.Changes, Change{Action: Delete, Resource: resource, Name: name, Field: key, Before: previous})
+		default:
+			p.Field(resource, name, key, previous, desired)
+		}
+	}
+}
+
+// Document records an update when two JSON documents are not semantically equal.
+// Documents that are not JSON (e.g. schedule expressions) are compared verbatim.
+func (p *Plan) Document(resource, name, before, after string) {
+	before, after = Normalize(before), Normalize(after)
+	p.Field(resource, name, "document", before, after)
+}
+
+// Empty reports whether the plan has no changes
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// String renders the plan as an indented diff
+func (p *Plan) String() string {
+	var b strings.Builder
+
+	b.WriteString(p.Step)
+	b.WriteString("\n")
+
+	if p.Empty() {
+		b.WriteString("  (no changes)\n")
+		return b.String()
+	}
+
+	for _, change := range p.Changes {
+		subject := fmt.Sprintf("%s %s", change.Resource, change.Name)
+		if change.Field != "" {
+			subject = fmt.Sprintf("%s %s", subject, change.Field)
+		}
+
+		switch change.Action {
+		case Create:
+			fmt.Fprintf(&b, "  + %s", subject)
+			if change.After != "" {
+				fmt.Fprintf(&b, " = %s", change.After)
+			}
+			b.WriteString("\n")
+		case Delete:
+			fmt.Fprintf(&b, "  - %s\n", subject)
+		case Update:
+			if strings.Contains(change.Before, "\n") || strings.Contains(change.After, "\n") {
+				fmt.Fprintf(&b, "  ~ %s\n", subject)
+				for _, line := range Lines(change.Before, change.After) {
+					fmt.Fprintf(&b, "      %s\n", line)
+				}
+				continue
+			}
+			fmt.Fprintf(&b, "  ~ %s: %s -> %s\n", subject, quote(change.Before), quote(change.After))
+		}
+	}
+
+	return b.String()
+}
+
+//
+// Helpers
+//
+
+// Normalize pretty prints JSON documents so that formatting differences are ignored
+func Normalize(document string) string {
+	var value any
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		return strings.TrimSpace(document)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return strings.TrimSpace(document)
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+// Lines returns a line based diff of two documents prefixed with "-", "+" or " "
+func Lines(before, after string) []string {
+	a := split(before)
+	b := split(after)
+
+	// longest common subsequence table
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, "+ "+b[j])
+	}
+
+	return lines
+}
+
+func split(document string) []string {
+	if document == "" {
+		return nil
+	}
+	return strings.Split(document, "\n")
+}
+
+func quote(value string) string {
+	if value == "" {
+		return `""`
+	}
+	return value
+}
@@ -0,0 +1,71 @@
+package plan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan_FieldIgnoresEqualValues(t *testing.T) {
+	p := New("lambda")
+
+	p.Field("function", "svc", "memory", "128", "128")
+	assert.True(t, p.Empty())
+
+	p.Field("function", "svc", "memory", "128", "256")
+	assert.Len(t, p.Changes, 1)
+	assert.Equal(t, Update, p.Changes[0].Action)
+}
+
+func TestPlan_Map(t *testing.T) {
+	p := New("lambda")
+
+	before := map[string]string{"KEEP": "1", "CHANGE": "a", "REMOVE": "x"}
+	after := map[string]string{"KEEP": "1", "CHANGE": "b", "ADD": "y"}
+
+	p.Map("env", "svc", before, after)
+
+	assert.Equal(t, []Change{
+		{Action: Create, Resource: "env", Name: "svc", Field: "ADD", After: "y"},
+		{Action: Update, Resource: "env", Name: "svc", Field: "CHANGE", Before: "a", After: "b"},
+		{Action: Delete, Resource: "env", Name: "svc", Field: "REMOVE", Before: "x"},
+	}, p.Changes)
+}
+
+func TestPlan_DocumentIgnoresFormatting(t *testing.T) {
+	p := New("iam")
+
+	p.Document("policy", "svc", `{"a":1,"b":[1,2]}`, "{\n  \"b\": [1, 2],\n  \"a\": 1\n}")
+	assert.True(t, p.Empty())
+
+	p.Document("policy", "svc", `{"a":1}`, `{"a":2}`)
+	assert.Len(t, p.Changes, 1)
+}
+
+func TestPlan_DocumentNonJson(t *testing.T) {
+	p := New("eventbridge")
+
+	p.Document("rule", "svc", "rate(5 minutes)", " rate(5 minutes)\n")
+	assert.True(t, p.Empty())
+}
+
+func TestLines(t *testing.T) {
+	lines := Lines("a\nb\nc", "a\nc\nd")
+	assert.Equal(t, []string{"  a", "- b", "  c", "+ d"}, lines)
+}
+
+func TestPlan_String(t *testing.T) {
+	p := New("apigateway")
+	assert.Contains(t, p.String(), "(no changes)")
+
+	p.Create("route", "ANY /svc/{proxy+}")
+	p.Delete("route", "ANY /old/{proxy+}")
+	p.Field("route", "ANY /svc/{proxy+}", "auth", "NONE", "AWS_IAM")
+
+	out := p.String()
+	assert.True(t, strings.HasPrefix(out, "apigateway\n"))
+	assert.Contains(t, out, "  + route ANY /svc/{proxy+}")
+	assert.Contains(t, out, "  - route ANY /old/{proxy+}")
+	assert.Contains(t, out, "  ~ route ANY /svc/{proxy+} auth: NONE -> AWS_IAM")
+}
@@ -0,0 +1,328 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bkeane/monad/pkg/inventory"
+
+	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+	"gopkg.in/yaml.v3"
+)
+
+type Status string
+
+const (
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+	Skipped   Status = "skipped"
+)
+
+// Step records the outcome of a single saga step
+type Step struct {
+	Name     string            `json:"name" yaml:"name"`
+	Status   Status            `json:"status" yaml:"status"`
+	Started  time.Time         `json:"started" yaml:"started"`
+	Duration float64           `json:"duration" yaml:"duration"`
+	Error    string            `json:"error,omitempty" yaml:"error,omitempty"`
+	Summary  any               `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Outputs  map[string]string `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+}
+
+// Report aggregates the step outcomes of a deploy or destroy
+type Report struct {
+	Action   string    `json:"action" yaml:"action"`
+	Status   Status    `json:"status" yaml:"status"`
+	Started  time.Time `json:"started" yaml:"started"`
+	Duration float64   `json:"duration" yaml:"duration"`
+	Error    string    `json:"error,omitempty" yaml:"error,omitempty"`
+	Steps    []Step    `json:"steps" yaml:"steps"`
+}
+
+// New starts a report for the given action, e.g. deploy or destroy
+func New(action string) *Report {
+	return &Report{
+		Action:  action,
+		Started: time.Now().UTC(),
+	}
+}
+
+// Add records a step that ran from started until now
+func (r *Report) Add(step Step, started time.Time, err error) {
+	step.Started = started.UTC()
+	step.Duration = seconds(time.Since(started))
+	step.Status = Succeeded
+
+	if err != nil {
+		step.Status = Failed
+		step.Error = err.Error()
+	}
+
+	r.Steps = append(r.Steps, step)
+}
+
+// Skip records a step that did not run
+func (r *Report) Skip(name string) {
+	r.Steps = append(r.Steps, Step{Name: name, Status: Skipped})
+}
+
+// Finish stamps the total duration and overall status of the report
+func (r *Report) Finish(err error) {
+	r.Duration = seconds(time.Since(r.Started))
+	r.Status = Succeeded
+
+	if err != nil {
+		r.Status = Failed
+		r.Error = err.Error()
+	}
+}
+
+// Sort orders the steps by the given names, with unknown steps last
+func (r *Report) Sort(order []string) {
+	slices.SortStableFunc(r.Steps, func(a, b Step) int {
+		return position(order, a.Name) - position(order, b.Name)
+	})
+}
+
+//
+// Batch
+//
+
+// Service records the outcome of a single service of a monorepo deploy or destroy
+type Service struct {
+	Name     string  `json:"name" yaml:"name"`
+	Dir      string  `json:"dir" yaml:"dir"`
+	Status   Status  `json:"status" yaml:"status"`
+	Duration float64 `json:"duration" yaml:"duration"`
+	Error    string  `json:"error,omitempty" yaml:"error,omitempty"`
+	Report   *Report `json:"report,omitempty" yaml:"report,omitempty"`
+}
+
+// Batch aggregates the service outcomes of a monorepo deploy or destroy
+type Batch struct {
+	Action   string    `json:"action" yaml:"action"`
+	Status   Status    `json:"status" yaml:"status"`
+	Started  time.Time `json:"started" yaml:"started"`
+	Duration float64   `json:"duration" yaml:"duration"`
+	Error    string    `json:"error,omitempty" yaml:"error,omitempty"`
+	Services []Service `json:"services" yaml:"services"`
+}
+
+// NewBatch starts a batch for the given action, e.g. deploy or destroy
+func NewBatch(action string) *Batch {
+	return &Batch{
+		Action:  action,
+		Started: time.Now().UTC(),
+	}
+}
+
+// Add records a service that ran from started until now along with its report, if any
+func (b *Batch) Add(service Service, started time.Time, report *Report, err error) {
+	service.Duration = seconds(time.Since(started))
+	service.Status = Succeeded
+	service.Report = report
+
+	if err != nil {
+		service.Status = Failed
+		service.Error = err.Error()
+	}
+
+	b.Services = append(b.Services, service)
+}
+
+// Skip records a service that did not run
+func (b *Batch) Skip(service Service) {
+	service.Status = Skipped
+	b.Services = append(b.Services, service)
+}
+
+// Finish stamps the total duration and overall status of the batch
+func (b *Batch) Finish(err error) {
+	b.Duration = seconds(time.Since(b.Started))
+	b.Status = Succeeded
+
+	if err != nil {
+		b.Status = Failed
+		b.Error = err.Error()
+	}
+}
+
+// Sort orders the services by the given names, with unknown services last
+func (b *Batch) Sort(order []string) {
+	slices.SortStableFunc(b.Services, func(x, y Service) int {
+		return position(order, x.Name) - position(order, y.Name)
+	})
+}
+
+// Markdown renders the batch as a table of services followed by their errors
+func (b *Batch) Markdown() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "### monad %s %s\n\n", b.Action, b.Status)
+	fmt.Fprintf(&sb, "| Service | Dir | Status | Duration |\n")
+	fmt.Fprintf(&sb, "|---------|-----|--------|----------|\n")
+	for _, service := range b.Services {
+		fmt.Fprintf(&sb, "| %s | %s | %s | %.1fs |\n", service.Name, service.Dir, service.Status, service.Duration)
+	}
+	fmt.Fprintf(&sb, "| **total** | | **%s** | **%.1fs** |\n", b.Status, b.Duration)
+
+	var errs []string
+	for _, service := range b.Services {
+		if service.Error != "" {
+			errs = append(errs, fmt.Sprintf("- **%s**: %s", service.Name, service.Error))
+		}
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintf(&sb, "\n#### Errors\n\n")
+		for _, err := range errs {
+			fmt.Fprintln(&sb, err)
+		}
+	}
+
+	return sb.String()
+}
+
+//
+// Output
+//
+
+type Output struct {
+	ReportFormat string `env:"MONAD_OUTPUT" flag:"--output" usage:"Write a report to stdout (json, yaml, markdown)" hint:"format"`
+}
+
+func Derive() (*Output, error) {
+	var output Output
+
+	if err := env.Parse(&output); err != nil {
+		return nil, err
+	}
+
+	if err := output.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+func (o *Output) Validate() error {
+	return v.ValidateStruct(o,
+		v.Field(&o.ReportFormat, v.In("json", "yaml", "markdown")),
+	)
+}
+
+// Write encodes the report in the requested format. Nothing is written when no format was requested.
+func (o *Output) Write(w io.Writer, report *Report) error {
+	if report == nil {
+		return nil
+	}
+
+	return o.write(w, report, report.Markdown)
+}
+
+// WriteBatch encodes the batch in the requested format. Nothing is written when no format was requested.
+func (o *Output) WriteBatch(w io.Writer, batch *Batch) error {
+	if batch == nil {
+		return nil
+	}
+
+	return o.write(w, batch, batch.Markdown)
+}
+
+// WriteInventory encodes the inventories of a service in the requested format. Nothing is written when no format was requested.
+func (o *Output) WriteInventory(w io.Writer, inventories []*inventory.Inventory) error {
+	return o.write(w, inventories, func() string { return inventory.Markdown(inventories) })
+}
+
+func (o *Output) write(w io.Writer, value any, markdown func() string) error {
+	switch o.ReportFormat {
+	case "":
+		return nil
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "yaml":
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "markdown":
+		_, err := io.WriteString(w, markdown())
+		return err
+	default:
+		return fmt.Errorf("unsupported output format: %s", o.ReportFormat)
+	}
+}
+
+//
+// Markdown
+//
+
+// Markdown renders the report as a table of steps followed by their outputs
+func (r *Report) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "### monad %s %s\n\n", r.Action, r.Status)
+	fmt.Fprintf(&b, "| Step | Status | Duration |\n")
+	fmt.Fprintf(&b, "|------|--------|----------|\n")
+	for _, step := range r.Steps {
+		fmt.Fprintf(&b, "| %s | %s | %.1fs |\n", step.Name, step.Status, step.Duration)
+	}
+	fmt.Fprintf(&b, "| **total** | **%s** | **%.1fs** |\n", r.Status, r.Duration)
+
+	var outputs []string
+	for _, step := range r.Steps {
+		keys := make([]string, 0, len(step.Outputs))
+		for key := range step.Outputs {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			outputs = append(outputs, fmt.Sprintf("| %s | %s | `%s` |", step.Name, key, step.Outputs[key]))
+		}
+	}
+
+	if len(outputs) > 0 {
+		fmt.Fprintf(&b, "\n| Step | Output | Value |\n")
+		fmt.Fprintf(&b, "|------|--------|-------|\n")
+		for _, output := range outputs {
+			fmt.Fprintln(&b, output)
+		}
+	}
+
+	var errs []string
+	for _, step := range r.Steps {
+		if step.Error != "" {
+			errs = append(errs, fmt.Sprintf("- **%s**: %s", step.Name, step.Error))
+		}
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintf(&b, "\n#### Errors\n\n")
+		for _, err := range errs {
+			fmt.Fprintln(&b, err)
+		}
+	}
+
+	return b.String()
+}
+
+func seconds(d time.Duration) float64 {
+	return d.Round(time.Millisecond).Seconds()
+}
+
+func position(order []string, name string) int {
+	if index := slices.Index(order, name); index >= 0 {
+		return index
+	}
+	return len(order)
+}
@@ -0,0 +1,141 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bkeane/monad/pkg/inventory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func sample() *Report {
+	r := New("deploy")
+	r.Add(Step{
+		Name:    "lambda",
+		Outputs: map[string]string{"function_arn": "arn:aws:lambda:us-west-2:123456789012:function:repo-main-svc"},
+	}, time.Now(), nil)
+	r.Add(Step{Name: "iam"}, time.Now(), nil)
+	r.Add(Step{Name: "apigateway"}, time.Now(), errors.New("route conflict"))
+	r.Skip("eventbridge")
+	r.Sort([]string{"iam", "lambda", "apigateway", "eventbridge"})
+	r.Finish(errors.New("apigateway: route conflict"))
+	return r
+}
+
+func TestReport_Sort(t *testing.T) {
+	r := sample()
+
+	var names []string
+	for _, step := range r.Steps {
+		names = append(names, step.Name)
+	}
+
+	assert.Equal(t, []string{"iam", "lambda", "apigateway", "eventbridge"}, names)
+}
+
+func TestReport_Status(t *testing.T) {
+	r := sample()
+
+	assert.Equal(t, Failed, r.Status)
+	assert.Equal(t, Succeeded, r.Steps[0].Status)
+	assert.Equal(t, Failed, r.Steps[2].Status)
+	assert.Equal(t, "route conflict", r.Steps[2].Error)
+	assert.Equal(t, Skipped, r.Steps[3].Status)
+}
+
+func TestOutput_Json(t *testing.T) {
+	var buf bytes.Buffer
+	output := &Output{ReportFormat: "json"}
+	require.NoError(t, output.Write(&buf, sample()))
+
+	var decoded Report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, "deploy", decoded.Action)
+	assert.Equal(t, Failed, decoded.Status)
+	assert.Len(t, decoded.Steps, 4)
+	assert.Contains(t, decoded.Steps[1].Outputs["function_arn"], "function:repo-main-svc")
+}
+
+func TestOutput_Yaml(t *testing.T) {
+	var buf bytes.Buffer
+	output := &Output{ReportFormat: "yaml"}
+	require.NoError(t, output.Write(&buf, sample()))
+
+	var decoded map[string]any
+	require.NoError(t, yaml.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, "failed", decoded["status"])
+	assert.Len(t, decoded["steps"], 4)
+}
+
+func TestOutput_Markdown(t *testing.T) {
+	var buf bytes.Buffer
+	output := &Output{ReportFormat: "markdown"}
+	require.NoError(t, output.Write(&buf, sample()))
+
+	assert.Contains(t, buf.String(), "### monad deploy failed")
+	assert.Contains(t, buf.String(), "| eventbridge | skipped |")
+	assert.Contains(t, buf.String(), "| lambda | function_arn |")
+	assert.Contains(t, buf.String(), "- **apigateway**: route conflict")
+}
+
+func TestOutput_None(t *testing.T) {
+	var buf bytes.Buffer
+	output := &Output{}
+	require.NoError(t, output.Write(&buf, sample()))
+	assert.Empty(t, buf.String())
+}
+
+func TestOutput_Validate(t *testing.T) {
+	assert.NoError(t, (&Output{}).Validate())
+	assert.NoError(t, (&Output{ReportFormat: "markdown"}).Validate())
+	assert.Error(t, (&Output{ReportFormat: "xml"}).Validate())
+}
+
+func TestBatch_Output(t *testing.T) {
+	b := NewBatch("deploy")
+	b.Add(Service{Name: "web", Dir: "services/web"}, time.Now(), sample(), errors.New("apigateway: route conflict"))
+	b.Add(Service{Name: "api", Dir: "services/api"}, time.Now(), New("deploy"), nil)
+	b.Skip(Service{Name: "worker", Dir: "services/worker"})
+	b.Sort([]string{"api", "web", "worker"})
+	b.Finish(errors.New("web: apigateway: route conflict"))
+
+	var buf bytes.Buffer
+	require.NoError(t, (&Output{ReportFormat: "json"}).WriteBatch(&buf, b))
+
+	var decoded Batch
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, Failed, decoded.Status)
+	require.Len(t, decoded.Services, 3)
+	assert.Equal(t, "api", decoded.Services[0].Name)
+	assert.Equal(t, Failed, decoded.Services[1].Status)
+	assert.Len(t, decoded.Services[1].Report.Steps, 4)
+	assert.Equal(t, Skipped, decoded.Services[2].Status)
+
+	buf.Reset()
+	require.NoError(t, (&Output{ReportFormat: "markdown"}).WriteBatch(&buf, b))
+	assert.Contains(t, buf.String(), "| worker | services/worker | skipped |")
+	assert.Contains(t, buf.String(), "- **web**: apigateway: route conflict")
+}
+
+func TestOutput_Inventory(t *testing.T) {
+	function := inventory.New("lambda")
+	function.Add("function", "repo-main-svc").Set("digest", "sha256:abc")
+
+	var buf bytes.Buffer
+	require.NoError(t, (&Output{ReportFormat: "json"}).WriteInventory(&buf, []*inventory.Inventory{function}))
+
+	var decoded []inventory.Inventory
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Len(t, decoded, 1)
+	assert.Equal(t, "sha256:abc", decoded[0].Resources[0].Get("digest"))
+
+	buf.Reset()
+	require.NoError(t, (&Output{ReportFormat: "markdown"}).WriteInventory(&buf, []*inventory.Inventory{function}))
+	assert.Contains(t, buf.String(), "| lambda | function | `repo-main-svc` | digest | `sha256:abc` |")
+}
@@ -0,0 +1,522 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bkeane/monad/pkg/basis/caller"
+	"github.com/bkeane/monad/pkg/basis/resource"
+	"github.com/bkeane/monad/pkg/journal"
+	lambdastep "github.com/bkeane/monad/pkg/step/lambda"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/rs/zerolog/log"
+)
+
+//
+// Dependencies
+//
+
+type Basis interface {
+	Caller() (*caller.Basis, error)
+	Resource() (*resource.Basis, error)
+}
+
+// Locker serializes a rollback with deploys and destroys of the same service
+type Locker interface {
+	// Lock returns a context that is cancelled should the lock be lost before Unlock
+	Lock(ctx context.Context) (context.Context, error)
+	Unlock(ctx context.Context) error
+}
+
+// guarded lists the outputs of the steps a rollback leaves in place. Should they
+// differ between the deploy rolled back to and the current one, the older image
+// may not work with the role or routes it would be served by.
+var guarded = map[string][]string{
+	"iam":        {"role_arn", "policy_digest"},
+	"apigateway": {"api_id", "routes"},
+}
+
+// Version is a published version of the function, described by the sha it deployed
+type Version struct {
+	Version string
+	Sha     string
+	Role    string
+}
+
+//
+// Rollback
+//
+
+type Rollback struct {
+	RollbackSha   string `env:"MONAD_ROLLBACK_SHA" flag:"--to" usage:"Deployed git sha to return to (default the previous deploy)" hint:"sha"`
+	RollbackEnv   bool   `env:"MONAD_ROLLBACK_ENV" flag:"--rollback-env" usage:"Restore the configuration and env of that deploy along with its image"`
+	RollbackForce bool   `env:"MONAD_ROLLBACK_FORCE" flag:"--force" usage:"Roll back despite role or route changes since that deploy"`
+	client        *lambda.Client
+	name          string
+	journal       *journal.Journal
+	locker        Locker
+}
+
+//
+// Derive
+//
+
+func Derive(ctx context.Context, basis Basis, journal *journal.Journal, locker Locker) (*Rollback, error) {
+	var err error
+	var rollback Rollback
+
+	if err = env.Parse(&rollback); err != nil {
+		return nil, err
+	}
+
+	caller, err := basis.Caller()
+	if err != nil {
+		return nil, err
+	}
+
+	resource, err := basis.Resource()
+	if err != nil {
+		return nil, err
+	}
+
+	rollback.client = lambda.NewFromConfig(caller.AwsConfig())
+	rollback.name = resource.Name()
+	rollback.journal = journal
+	rollback.locker = locker
+
+	if err = rollback.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &rollback, nil
+}
+
+func (r *Rollback) Validate() error {
+	return v.ValidateStruct(r,
+		v.Field(&r.client, v.Required),
+		v.Field(&r.name, v.Required),
+		v.Field(&r.journal, v.Required),
+	)
+}
+
+//
+// Rollback
+//
+
+// Do returns the function to the image of an earlier deploy found in the journal or among
+// the published versions, along with its configuration and env when requested.
+func (r *Rollback) Do(ctx context.Context) error {
+	if r.locker != nil {
+		locked, err := r.locker.Lock(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := r.locker.Unlock(context.WithoutCancel(ctx)); err != nil {
+				log.Warn().Err(err).Msg("unlock failed")
+			}
+		}()
+		ctx = locked
+	}
+
+	function, err := r.getFunction(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := function.Tags["Sha"]
+	sha, deployed, err := r.target(ctx, function, current)
+	if err != nil {
+		return err
+	}
+
+	history := r.journal.At(sha)
+	if err := history.Begin(ctx, "rollback"); err != nil {
+		return err
+	}
+
+	outputs, err := r.restore(ctx, sha, deployed)
+	if err != nil && ctx.Err() != nil {
+		// the aws error only says the context was cancelled, the cause says why
+		err = errors.Join(context.Cause(ctx), err)
+	}
+	if recordErr := history.Record(ctx, "lambda", event(err), err, outputs); recordErr != nil {
+		log.Warn().Err(recordErr).Msg("journal record failed")
+	}
+	if endErr := history.End(ctx, err); endErr != nil {
+		log.Warn().Err(endErr).Msg("journal end failed")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("action", "rollback").
+		Str("from", current).
+		Str("to", sha).
+		Str("image", outputs["image"]).
+		Msg("lambda")
+
+	return nil
+}
+
+// target returns the sha and lambda outputs of the deploy to roll back to. Journals kept in the
+// user cache of another machine, as on ci runners, hold no deploys here, so the versions
+// published with --alias are looked through when the journal has none to return to.
+func (r *Rollback) target(ctx context.Context, function *lambda.GetFunctionOutput, current string) (string, map[string]string, error) {
+	runs, err := r.journal.Runs(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	index, err := Target(runs, current, r.RollbackSha)
+	if err == nil {
+		target := runs[index]
+		if changes := Changes(runs, index); len(changes) > 0 && !r.RollbackForce {
+			return "", nil, fmt.Errorf("rolling back to %s is unsafe as %s since, use --force to roll back regardless",
+				target.Sha, strings.Join(changes, " and "))
+		}
+
+		return target.Sha, target.Outputs["lambda"], nil
+	}
+
+	versions, listErr := r.versions(ctx)
+	if listErr != nil {
+		return "", nil, errors.Join(err, listErr)
+	}
+
+	found, versionErr := TargetVersion(versions, current, r.RollbackSha)
+	if versionErr != nil {
+		return "", nil, fmt.Errorf("%w, nor do the published versions of %s: %w", err, r.name, versionErr)
+	}
+
+	target := versions[found]
+	if role := aws.ToString(function.Configuration.Role); target.Role != role && !r.RollbackForce {
+		return "", nil, fmt.Errorf("rolling back to %s is unsafe as its version %s ran as %s, use --force to roll back regardless",
+			target.Sha, target.Version, target.Role)
+	}
+
+	log.Warn().
+		Str("sha", target.Sha).
+		Str("version", target.Version).
+		Msg("journal has no record of the deploy, so policy and route changes since are not checked")
+
+	deployed, err := r.published(ctx, function, target)
+	return target.Sha, deployed, err
+}
+
+// versions returns the published versions of the function described by the sha they deployed, oldest first
+func (r *Rollback) versions(ctx context.Context) ([]Version, error) {
+	var versions []Version
+
+	paginator := lambda.NewListVersionsByFunctionPaginator(r.client, &lambda.ListVersionsByFunctionInput{
+		FunctionName: aws.String(r.name),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, version := range page.Versions {
+			if aws.ToString(version.Version) == "$LATEST" || aws.ToString(version.Description) == "" {
+				continue
+			}
+
+			versions = append(versions, Version{
+				Version: aws.ToString(version.Version),
+				Sha:     aws.ToString(version.Description),
+				Role:    aws.ToString(version.Role),
+			})
+		}
+	}
+
+	slices.SortStableFunc(versions, func(a, b Version) int {
+		return number(a.Version) - number(b.Version)
+	})
+
+	return versions, nil
+}
+
+// published returns the outputs a deploy of version would have journaled, pointing
+// the alias of the function at it when the function has one
+func (r *Rollback) published(ctx context.Context, function *lambda.GetFunctionOutput, target Version) (map[string]string, error) {
+	output, err := r.client.GetFunction(ctx, &lambda.GetFunctionInput{
+		FunctionName: aws.String(r.name),
+		Qualifier:    aws.String(target.Version),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	functionArn := aws.ToString(function.Configuration.FunctionArn)
+	deployed := map[string]string{
+		"function_name": r.name,
+		"function_arn":  functionArn,
+		"version":       target.Version,
+	}
+
+	if output.Code != nil {
+		deployed["image"] = aws.ToString(output.Code.ImageUri)
+	}
+
+	aliases, err := r.client.ListAliases(ctx, &lambda.ListAliasesInput{
+		FunctionName: aws.String(r.name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(aliases.Aliases) {
+	case 0:
+	case 1:
+		deployed["alias_arn"] = functionArn + ":" + aws.ToString(aliases.Aliases[0].Name)
+	default:
+		return nil, fmt.Errorf("function %s has several aliases, roll back with the journal of its deploys instead", r.name)
+	}
+
+	return deployed, nil
+}
+
+// restore points the function at the image of a deploy, returning the outputs of the restored function
+func (r *Rollback) restore(ctx context.Context, sha string, deployed map[string]string) (map[string]string, error) {
+	outputs := map[string]string{
+		"function_name": deployed["function_name"],
+		"function_arn":  deployed["function_arn"],
+		"image":         deployed["image"],
+	}
+
+	version := deployed["version"]
+	alias := ""
+	if arn := deployed["alias_arn"]; arn != "" {
+		alias = arn[strings.LastIndex(arn, ":")+1:]
+		outputs["alias_arn"] = arn
+	}
+
+	if r.RollbackEnv {
+		if version == "" {
+			return nil, fmt.Errorf("restoring the env requires a deploy published with --alias")
+		}
+
+		if err := r.restoreConfiguration(ctx, version); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.updateCode(ctx, deployed["image"]); err != nil {
+		return nil, err
+	}
+
+	if alias != "" {
+		// the published version carries the configuration and env of its deploy,
+		// so without --rollback-env the restored image is published anew
+		if !r.RollbackEnv {
+			published, err := r.client.PublishVersion(ctx, &lambda.PublishVersionInput{
+				FunctionName: aws.String(r.name),
+				Description:  aws.String(sha),
+			}, lambdastep.RetryUpdate)
+			if err != nil {
+				return nil, err
+			}
+			version = aws.ToString(published.Version)
+		}
+
+		_, err := r.client.UpdateAlias(ctx, &lambda.UpdateAliasInput{
+			FunctionName:    aws.String(r.name),
+			Name:            aws.String(alias),
+			FunctionVersion: aws.String(version),
+			RoutingConfig: &types.AliasRoutingConfiguration{
+				AdditionalVersionWeights: map[string]float64{},
+			},
+		}, lambdastep.RetryUpdate)
+		if err != nil {
+			return nil, err
+		}
+
+		outputs["version"] = version
+	}
+
+	return outputs, r.tag(ctx, deployed["function_arn"], sha)
+}
+
+// restoreConfiguration applies the configuration and env of a published version to $LATEST
+func (r *Rollback) restoreConfiguration(ctx context.Context, version string) error {
+	previous, err := r.client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(r.name),
+		Qualifier:    aws.String(version),
+	})
+	if err != nil {
+		return err
+	}
+
+	update := &lambda.UpdateFunctionConfigurationInput{
+		FunctionName:     aws.String(r.name),
+		MemorySize:       previous.MemorySize,
+		Timeout:          previous.Timeout,
+		EphemeralStorage: previous.EphemeralStorage,
+		Environment: &types.Environment{
+			Variables: map[string]string{},
+		},
+	}
+
+	if previous.Environment != nil && previous.Environment.Variables != nil {
+		update.Environment.Variables = previous.Environment.Variables
+	}
+
+	_, err = r.client.UpdateFunctionConfiguration(ctx, update, lambdastep.RetryUpdate)
+	return err
+}
+
+func (r *Rollback) updateCode(ctx context.Context, image string) error {
+	if image == "" {
+		return fmt.Errorf("journal does not record the image of that deploy")
+	}
+
+	_, err := r.client.UpdateFunctionCode(ctx, &lambda.UpdateFunctionCodeInput{
+		FunctionName: aws.String(r.name),
+		ImageUri:     aws.String(image),
+	}, lambdastep.RetryUpdate)
+
+	return err
+}
+
+// tag records the sha of the restored deploy on the function, where later rollbacks read it from
+func (r *Rollback) tag(ctx context.Context, functionArn, sha string) error {
+	_, err := r.client.TagResource(ctx, &lambda.TagResourceInput{
+		Resource: aws.String(functionArn),
+		Tags:     map[string]string{"Sha": sha},
+	}, lambdastep.RetryUpdate)
+
+	return err
+}
+
+func (r *Rollback) getFunction(ctx context.Context) (*lambda.GetFunctionOutput, error) {
+	var notFound *types.ResourceNotFoundException
+
+	output, err := r.client.GetFunction(ctx, &lambda.GetFunctionInput{
+		FunctionName: aws.String(r.name),
+	})
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("function %s is not deployed", r.name)
+	}
+
+	return output, err
+}
+
+//
+// Selection
+//
+
+// Target returns the index of the run to roll back to: the last deploy of sha when one
+// is given, and otherwise the last deploy of a sha other than the current one.
+func Target(runs []journal.Run, current, sha string) (int, error) {
+	for i := len(runs) - 1; i >= 0; i-- {
+		if !deployed(runs[i]) {
+			continue
+		}
+
+		if sha != "" && strings.HasPrefix(runs[i].Sha, sha) {
+			if runs[i].Sha == current {
+				return 0, fmt.Errorf("%s is already deployed", runs[i].Sha)
+			}
+			return i, nil
+		}
+
+		if sha == "" && runs[i].Sha != current {
+			return i, nil
+		}
+	}
+
+	if sha != "" {
+		return 0, fmt.Errorf("journal has no successful deploy of %s", sha)
+	}
+
+	return 0, fmt.Errorf("journal has no earlier deploy to roll back to")
+}
+
+// TargetVersion returns the index of the version to roll back to: the last version of sha when
+// one is given, and otherwise the last version of a sha other than the current one.
+func TargetVersion(versions []Version, current, sha string) (int, error) {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if sha != "" && strings.HasPrefix(versions[i].Sha, sha) {
+			if versions[i].Sha == current {
+				return 0, fmt.Errorf("%s is already deployed", versions[i].Sha)
+			}
+			return i, nil
+		}
+
+		if sha == "" && versions[i].Sha != current {
+			return i, nil
+		}
+	}
+
+	if sha != "" {
+		return 0, fmt.Errorf("no version was published of %s", sha)
+	}
+
+	return 0, fmt.Errorf("no earlier version was published")
+}
+
+// Changes describes the guarded outputs that differ between the run at index and the latest run
+func Changes(runs []journal.Run, index int) []string {
+	var changes []string
+
+	for _, step := range slices.Sorted(maps.Keys(guarded)) {
+		then := state(runs, index, step)
+		now := state(runs, len(runs)-1, step)
+
+		for _, key := range guarded[step] {
+			if then[key] != now[key] {
+				changes = append(changes, fmt.Sprintf("%s %s changed", step, key))
+			}
+		}
+	}
+
+	return changes
+}
+
+//
+// Helpers
+//
+
+// deployed reports whether a run left an image in place that can be returned to
+func deployed(run journal.Run) bool {
+	return (run.Action == "deploy" || run.Action == "rollback") &&
+		run.Event == journal.Succeeded &&
+		run.Outputs["lambda"]["image"] != ""
+}
+
+// state returns the outputs of step as of the run at index, which are those of the last run to mount it
+func state(runs []journal.Run, index int, step string) map[string]string {
+	for i := index; i >= 0; i-- {
+		if runs[i].Action == "deploy" && runs[i].Steps[step] == journal.Succeeded && runs[i].Outputs[step] != nil {
+			return runs[i].Outputs[step]
+		}
+	}
+	return map[string]string{}
+}
+
+func event(err error) journal.Event {
+	if err != nil {
+		return journal.Failed
+	}
+	return journal.Succeeded
+}
+
+// number orders versions numerically, as they are listed by their names
+func number(version string) int {
+	n, _ := strconv.Atoi(version)
+	return n
+}
@@ -0,0 +1,143 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/bkeane/monad/pkg/journal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deploy journals a run of sha mounting iam, apigateway and lambda with the given outputs
+func deploy(t *testing.T, j *journal.Journal, sha string, err error, iam, apigateway map[string]string) {
+	ctx := context.Background()
+	history := j.At(sha)
+
+	require.NoError(t, history.Begin(ctx, "deploy"))
+	require.NoError(t, history.Record(ctx, "iam", journal.Succeeded, nil, iam))
+	require.NoError(t, history.Record(ctx, "apigateway", journal.Succeeded, nil, apigateway))
+	require.NoError(t, history.Record(ctx, "lambda", journal.Succeeded, nil, map[string]string{
+		"function_arn": "arn:aws:lambda:us-west-2:123456789012:function:svc",
+		"image":        "123456789012.dkr.ecr.us-west-2.amazonaws.com/svc@sha256:" + sha,
+	}))
+	require.NoError(t, history.End(ctx, err))
+}
+
+func runs(t *testing.T, j *journal.Journal) []journal.Run {
+	runs, err := j.Runs(context.Background())
+	require.NoError(t, err)
+	return runs
+}
+
+var (
+	role   = map[string]string{"role_arn": "arn:aws:iam::123456789012:role/svc", "policy_digest": "aaa"}
+	routes = map[string]string{"api_id": "abc", "routes": "ANY /svc/{proxy+}=aws_iam"}
+)
+
+func TestTarget(t *testing.T) {
+	j := journal.New(journal.NewFile(t.TempDir()), "svc", "head")
+	deploy(t, j, "aaa111", nil, role, routes)
+	deploy(t, j, "bbb222", nil, role, routes)
+	deploy(t, j, "ccc333", errors.New("lambda: timeout"), role, routes)
+	deploy(t, j, "ddd444", nil, role, routes)
+
+	history := runs(t, j)
+
+	index, err := Target(history, "ddd444", "")
+	require.NoError(t, err)
+	assert.Equal(t, "bbb222", history[index].Sha, "failed deploys are skipped")
+
+	index, err = Target(history, "ddd444", "aaa")
+	require.NoError(t, err)
+	assert.Equal(t, "aaa111", history[index].Sha)
+
+	_, err = Target(history, "ddd444", "ccc333")
+	assert.ErrorContains(t, err, "no successful deploy of ccc333")
+
+	_, err = Target(history, "ddd444", "ddd")
+	assert.ErrorContains(t, err, "already deployed")
+
+	_, err = Target(history[:1], "aaa111", "")
+	assert.ErrorContains(t, err, "no earlier deploy")
+}
+
+func TestTarget_AfterRollback(t *testing.T) {
+	ctx := context.Background()
+	j := journal.New(journal.NewFile(t.TempDir()), "svc", "head")
+	deploy(t, j, "aaa111", nil, role, routes)
+	deploy(t, j, "bbb222", nil, role, routes)
+
+	rollback := j.At("aaa111")
+	require.NoError(t, rollback.Begin(ctx, "rollback"))
+	require.NoError(t, rollback.Record(ctx, "lambda", journal.Succeeded, nil, map[string]string{"image": "svc@sha256:aaa111"}))
+	require.NoError(t, rollback.End(ctx, nil))
+
+	history := runs(t, j)
+
+	index, err := Target(history, "aaa111", "")
+	require.NoError(t, err)
+	assert.Equal(t, "bbb222", history[index].Sha, "rolling back again returns to the deploy rolled back from")
+}
+
+func TestTargetVersion(t *testing.T) {
+	versions := []Version{
+		{Version: "1", Sha: "aaa111"},
+		{Version: "2", Sha: "bbb222"},
+		{Version: "3", Sha: "ccc333"},
+		{Version: "4", Sha: "ccc333"},
+	}
+
+	index, err := TargetVersion(versions, "ccc333", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2", versions[index].Version, "versions of the current sha are skipped")
+
+	index, err = TargetVersion(versions, "ccc333", "aaa")
+	require.NoError(t, err)
+	assert.Equal(t, "1", versions[index].Version)
+
+	_, err = TargetVersion(versions, "ccc333", "ccc")
+	assert.ErrorContains(t, err, "already deployed")
+
+	_, err = TargetVersion(versions, "ccc333", "ddd444")
+	assert.ErrorContains(t, err, "no version was published of ddd444")
+
+	_, err = TargetVersion(versions[:1], "aaa111", "")
+	assert.ErrorContains(t, err, "no earlier version")
+}
+
+func TestNumber(t *testing.T) {
+	versions := []string{"10", "9", "100"}
+	slices.SortFunc(versions, func(a, b string) int { return number(a) - number(b) })
+	assert.Equal(t, []string{"9", "10", "100"}, versions)
+}
+
+func TestChanges(t *testing.T) {
+	j := journal.New(journal.NewFile(t.TempDir()), "svc", "head")
+	deploy(t, j, "aaa111", nil, role, routes)
+	deploy(t, j, "bbb222", nil, role, routes)
+	assert.Empty(t, Changes(runs(t, j), 0))
+
+	policy := map[string]string{"role_arn": role["role_arn"], "policy_digest": "bbb"}
+	public := map[string]string{"api_id": "abc", "routes": "ANY /svc/{proxy+}=none"}
+	deploy(t, j, "ccc333", nil, policy, public)
+
+	assert.Equal(t, []string{
+		"apigateway routes changed",
+		"iam policy_digest changed",
+	}, Changes(runs(t, j), 1))
+}
+
+func TestChanges_RemovedRoutes(t *testing.T) {
+	j := journal.New(journal.NewFile(t.TempDir()), "svc", "head")
+	deploy(t, j, "aaa111", nil, role, routes)
+	deploy(t, j, "bbb222", nil, role, map[string]string{"api_id": ""})
+
+	assert.Equal(t, []string{
+		"apigateway api_id changed",
+		"apigateway routes changed",
+	}, Changes(runs(t, j), 0))
+}
@@ -2,102 +2,682 @@ package saga
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/bkeane/monad/internal/dag"
+	"github.com/bkeane/monad/pkg/hook"
+	"github.com/bkeane/monad/pkg/inventory"
+	"github.com/bkeane/monad/pkg/journal"
+	"github.com/bkeane/monad/pkg/plan"
+	"github.com/bkeane/monad/pkg/report"
 	"github.com/bkeane/monad/pkg/step"
-	"github.com/bkeane/monad/pkg/step/apigateway"
-	"github.com/bkeane/monad/pkg/step/cloudwatch"
-	"github.com/bkeane/monad/pkg/step/eventbridge"
-	"github.com/bkeane/monad/pkg/step/iam"
-	"github.com/bkeane/monad/pkg/step/lambda"
 
+	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/rs/zerolog/log"
 )
 
 type StepCollection interface {
-	IAM() *iam.Step
-	CloudWatch() *cloudwatch.Step
-	Lambda() *lambda.Step
-	ApiGateway() *apigateway.Step
-	EventBridge() *eventbridge.Step
+	Names() []string
+	Step(name string) step.Step
+	Dependencies(name string) []string
 }
 
-type Step interface {
-	Mount(ctx context.Context) error
-	Unmount(ctx context.Context) error
+type node struct {
+	step         step.Step
+	dependencies []string
+}
+
+// Selection restricts a saga to a subset of its steps
+type Selection struct {
+	SagaOnly []string `env:"MONAD_ONLY" flag:"--only" usage:"Run only the named steps" hint:"step"`
+	SagaSkip []string `env:"MONAD_SKIP" flag:"--skip" usage:"Skip the named steps" hint:"step"`
+}
+
+// Journal records the transitions of every step so that a failed deploy can be resumed
+type Journal interface {
+	Begin(ctx context.Context, action string) error
+	Record(ctx context.Context, step string, event journal.Event, err error, outputs map[string]string) error
+	End(ctx context.Context, err error) error
+	Completed(ctx context.Context, action string) ([]string, error)
+}
+
+// Locker serializes the deploys and destroys of a service
+type Locker interface {
+	// Lock returns a context that is cancelled should the lock be lost before Unlock
+	Lock(ctx context.Context) (context.Context, error)
+	Unlock(ctx context.Context) error
+}
+
+// Hooks run commands before and after the saga and each of its steps
+type Hooks interface {
+	Steps() []string
+	Run(ctx context.Context, event hook.Event, outputs map[string]map[string]string) error
+}
+
+// Dependencies are the optional collaborators of a saga. Nil members are disabled.
+type Dependencies struct {
+	Journal Journal
+	Locker  Locker
+	Hooks   Hooks
 }
 
 type Saga struct {
-	iam         Step
-	eventbridge Step
-	apigateway  Step
-	cloudwatch  Step
-	lambda      Step
+	SagaRollback  bool `env:"MONAD_ROLLBACK_ON_FAILURE" flag:"--rollback-on-failure" usage:"Restore mounted steps when deploy fails"`
+	SagaResume    bool `env:"MONAD_RESUME" flag:"--resume" usage:"Skip steps that succeeded in the last unfinished deploy"`
+	SagaReconcile bool `env:"MONAD_RECONCILE" flag:"--reconcile" usage:"Change only resources that differ from the config instead of replacing them"`
+	Selection     Selection
+	steps         map[string]node
+	graph         *dag.Graph
+	journal       Journal
+	locker        Locker
+	hooks         Hooks
+	mu            sync.Mutex
+	outputs       map[string]map[string]string // outputs of the steps finished in this run
 }
 
-func Derive(ctx context.Context, steps *step.Steps) *Saga {
-	return &Saga{
-		iam:         steps.IAM(),
-		cloudwatch:  steps.CloudWatch(),
-		lambda:      steps.Lambda(),
-		apigateway:  steps.ApiGateway(),
-		eventbridge: steps.EventBridge(),
+func Derive(ctx context.Context, steps StepCollection, dependencies Dependencies) (*Saga, error) {
+	var saga Saga
+
+	if err := env.Parse(&saga); err != nil {
+		return nil, err
+	}
+
+	saga.steps = map[string]node{}
+	saga.graph = dag.New()
+	saga.journal = dependencies.Journal
+	saga.locker = dependencies.Locker
+	saga.hooks = dependencies.Hooks
+
+	// steps that share a dependency, such as API Gateway and EventBridge on the function, mount concurrently
+	for _, name := range steps.Names() {
+		saga.steps[name] = node{
+			step:         steps.Step(name),
+			dependencies: steps.Dependencies(name),
+		}
+
+		if err := saga.graph.Add(name, steps.Dependencies(name)...); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := saga.graph.Sort(); err != nil {
+		return nil, err
+	}
+
+	if err := saga.Validate(); err != nil {
+		return nil, err
 	}
+
+	return &saga, nil
 }
 
-func (a *Saga) Do(ctx context.Context) error {
-	if err := a.iam.Mount(ctx); err != nil {
-		log.Error().Err(err).Msg("iam mount failed")
+//
+// Validate
+//
+
+func (a *Saga) Validate() error {
+	names := a.graph.Names()
+	known := v.In(toAny(names)...).Error("must be one of " + strings.Join(names, ", "))
+
+	if err := v.ValidateStruct(a,
+		v.Field(&a.journal, v.When(a.SagaResume, v.Required.Error("is required to resume"))),
+	); err != nil {
 		return err
 	}
 
-	if err := a.cloudwatch.Mount(ctx); err != nil {
-		log.Error().Err(err).Msg("cloudwatch mount failed")
-		return err
+	if a.hooks != nil {
+		for _, name := range a.hooks.Steps() {
+			if !a.graph.Has(name) {
+				return fmt.Errorf("hook step %s must be one of %s", name, strings.Join(names, ", "))
+			}
+		}
 	}
 
-	if err := a.lambda.Mount(ctx); err != nil {
-		log.Error().Err(err).Msg("lambda mount failed")
-		return err
+	return v.ValidateStruct(&a.Selection,
+		v.Field(&a.Selection.SagaOnly, v.Each(known)),
+		v.Field(&a.Selection.SagaSkip, v.Each(known)),
+	)
+}
+
+// Do mounts every step once its dependencies have mounted.
+// Independent steps mount concurrently and their errors are aggregated.
+// The returned report describes every step, including those that failed or were skipped.
+func (a *Saga) Do(ctx context.Context) (*report.Report, error) {
+	var mounted []string
+	result := report.New("deploy")
+	a.outputs = map[string]map[string]string{}
+
+	parent := ctx
+	ctx, err := a.lock(parent)
+	if err != nil {
+		return a.finish(result, err)
 	}
+	defer a.unlock(parent)
 
-	if err := a.apigateway.Mount(ctx); err != nil {
-		log.Error().Err(err).Msg("apigateway mount failed")
-		return err
+	selected, err := a.selected()
+	if err != nil {
+		return a.finish(result, err)
 	}
 
-	if err := a.eventbridge.Mount(ctx); err != nil {
-		log.Error().Err(err).Msg("eventbridge mount failed")
-		return err
+	resumed, err := a.resumable(ctx, selected)
+	if err != nil {
+		return a.finish(result, err)
+	}
+
+	if len(resumed) == len(selected.Names()) {
+		log.Info().Msg("nothing to resume, every selected step succeeded in the last deploy")
+		return a.finish(result, nil)
+	}
+
+	if len(resumed) > 0 {
+		selected = selected.Subset(slices.DeleteFunc(selected.Names(), func(name string) bool {
+			return slices.Contains(resumed, name)
+		}))
+	}
+
+	if err := a.checkSkippedDependencies(ctx, selected); err != nil {
+		return a.finish(result, err)
+	}
+
+	if err := a.hook(ctx, hook.Event{Action: "deploy", When: hook.Pre}); err != nil {
+		return a.finish(result, err)
+	}
+
+	a.begin(ctx, "deploy")
+	for _, name := range resumed {
+		log.Info().Str("action", "resume").Msg(name)
+		a.journalRecord(ctx, name, journal.Resumed, nil, nil)
+	}
+
+	err = selected.Walk(ctx, 0, func(ctx context.Context, name string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		current := a.steps[name].step
+		started := time.Now()
+		a.journalRecord(ctx, name, journal.Started, nil, nil)
+
+		if err := a.hook(ctx, hook.Event{Action: "deploy", When: hook.Pre, Step: name}); err != nil {
+			a.record(ctx, result, name, started, err)
+			return err
+		}
+
+		if restorer, ok := current.(step.Restorer); ok && a.SagaRollback {
+			if err := restorer.Snapshot(ctx); err != nil {
+				log.Error().Err(err).Msg(name + " snapshot failed")
+				a.record(ctx, result, name, started, err)
+				return err
+			}
+		}
+
+		err := a.mount(ctx, current)
+		if err == nil {
+			err = a.hook(ctx, hook.Event{Action: "deploy", When: hook.Post, Step: name})
+		}
+		a.record(ctx, result, name, started, err)
+
+		// the failed step may have partially mounted, so it is compensated too
+		a.mu.Lock()
+		mounted = append(mounted, name)
+		a.mu.Unlock()
+
+		if err != nil {
+			log.Error().Err(err).Msg(name + " mount failed")
+			return err
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = a.hook(ctx, hook.Event{Action: "deploy", When: hook.Post})
+	}
+
+	if cause := lost(parent, ctx); cause != nil {
+		// another owner may hold the lock by now, so nothing is compensated behind its back
+		err = errors.Join(cause, err)
+	} else if err != nil {
+		err = a.rollback(ctx, mounted, err)
+	}
+
+	a.end(parent, err)
+	return a.finish(result, err)
+}
+
+// Undo unmounts every step in reverse dependency order
+func (a *Saga) Undo(ctx context.Context) (*report.Report, error) {
+	result := report.New("destroy")
+	a.outputs = map[string]map[string]string{}
+
+	parent := ctx
+	ctx, err := a.lock(parent)
+	if err != nil {
+		return a.finish(result, err)
+	}
+	defer a.unlock(parent)
+
+	selected, err := a.selected()
+	if err != nil {
+		return a.finish(result, err)
+	}
+
+	a.warnSkippedDependents(selected)
+
+	if err := a.hook(ctx, hook.Event{Action: "destroy", When: hook.Pre}); err != nil {
+		return a.finish(result, err)
+	}
+
+	a.begin(ctx, "destroy")
+
+	err = selected.Reverse().Walk(ctx, 0, func(ctx context.Context, name string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		started := time.Now()
+		a.journalRecord(ctx, name, journal.Started, nil, nil)
+
+		err := a.hook(ctx, hook.Event{Action: "destroy", When: hook.Pre, Step: name})
+		if err == nil {
+			err = a.steps[name].step.Unmount(ctx)
+		}
+		if err == nil {
+			err = a.hook(ctx, hook.Event{Action: "destroy", When: hook.Post, Step: name})
+		}
+		a.record(ctx, result, name, started, err)
+
+		if err != nil {
+			log.Error().Err(err).Msg(name + " unmount failed")
+			return err
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = a.hook(ctx, hook.Event{Action: "destroy", When: hook.Post})
+	}
+
+	if cause := lost(parent, ctx); cause != nil {
+		err = errors.Join(cause, err)
+	}
+
+	a.end(parent, err)
+	return a.finish(result, err)
+}
+
+// Plan collects the changes each step would make without writing to AWS
+func (a *Saga) Plan(ctx context.Context) ([]*plan.Plan, error) {
+	return a.plan(ctx, a.graph)
+}
+
+// Drift collects the changes deploying would make to the selected steps, omitting those
+// whose deployed resources match the config. Run at the deployed commit, any change is
+// an edit made outside of monad.
+func (a *Saga) Drift(ctx context.Context) ([]*plan.Plan, error) {
+	selected, err := a.selected()
+	if err != nil {
+		return nil, err
+	}
+
+	plans, err := a.plan(ctx, selected)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(plans, (*plan.Plan).Empty), nil
+}
+
+// plan collects the plans of every step of graph in dependency order
+func (a *Saga) plan(ctx context.Context, graph *dag.Graph) ([]*plan.Plan, error) {
+	order, err := graph.Sort()
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]*plan.Plan, len(order))
+
+	err = graph.Walk(ctx, 0, func(ctx context.Context, name string) error {
+		planner, ok := a.steps[name].step.(step.Planner)
+		if !ok {
+			return nil
+		}
+
+		p, err := planner.Plan(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg(name + " plan failed")
+			return err
+		}
+
+		plans[slices.Index(order, name)] = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(plans, func(p *plan.Plan) bool { return p == nil }), nil
+}
+
+// Describe collects the resources each step has deployed, in dependency order, without writing to AWS
+func (a *Saga) Describe(ctx context.Context) ([]*inventory.Inventory, error) {
+	order, err := a.graph.Sort()
+	if err != nil {
+		return nil, err
+	}
+
+	inventories := make([]*inventory.Inventory, len(order))
+
+	err = a.graph.Walk(ctx, 0, func(ctx context.Context, name string) error {
+		describer, ok := a.steps[name].step.(step.Describer)
+		if !ok {
+			return nil
+		}
+
+		i, err := describer.Describe(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg(name + " describe failed")
+			return err
+		}
+
+		inventories[slices.Index(order, name)] = i
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(inventories, func(i *inventory.Inventory) bool { return i == nil }), nil
+}
+
+// mount reconciles a step when enabled and the step is able to, and otherwise mounts it
+func (a *Saga) mount(ctx context.Context, current step.Step) error {
+	if reconciler, ok := current.(step.Reconciler); ok && a.SagaReconcile {
+		return reconciler.Reconcile(ctx)
+	}
+
+	return current.Mount(ctx)
+}
+
+// rollback compensates mounted steps in reverse dependency order when enabled.
+// Steps able to restore a snapshot are restored, all others are unmounted. A step that
+// fails to compensate does not stop the steps it depends on from being compensated.
+func (a *Saga) rollback(ctx context.Context, mounted []string, cause error) error {
+	if !a.SagaRollback {
+		return cause
 	}
 
+	var mu sync.Mutex
+	var errs []error
+
+	// errors are collected rather than returned, as Walk skips the dependencies of a failed step
+	err := a.graph.Subset(mounted).Reverse().Walk(ctx, 0, func(ctx context.Context, name string) error {
+		if err := a.compensate(ctx, name); err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(cause, errors.Join(errs...))
+}
+
+// compensate restores the step when it is a restorer, and otherwise unmounts it
+func (a *Saga) compensate(ctx context.Context, name string) error {
+	current := a.steps[name].step
+
+	if restorer, ok := current.(step.Restorer); ok {
+		if err := restorer.Restore(ctx); err != nil {
+			log.Error().Err(err).Msg(name + " restore failed")
+			return fmt.Errorf("%s restore: %w", name, err)
+		}
+	} else if err := current.Unmount(ctx); err != nil {
+		log.Error().Err(err).Msg(name + " unmount failed")
+		return fmt.Errorf("%s unmount: %w", name, err)
+	}
+
+	a.journalRecord(ctx, name, journal.RolledBack, nil, nil)
 	return nil
 }
 
-func (a *Saga) Undo(ctx context.Context) error {
-	if err := a.eventbridge.Unmount(ctx); err != nil {
-		log.Error().Err(err).Msg("eventbridge unmount failed")
-		return err
+//
+// Report
+//
+
+// record adds the outcome of a step to the report and journal, along with its summary and outputs when it is a reporter.
+// The outputs are kept for the hooks of the steps that follow.
+func (a *Saga) record(ctx context.Context, result *report.Report, name string, started time.Time, err error) {
+	entry := report.Step{Name: name}
+
+	if reporter, ok := a.steps[name].step.(step.Reporter); ok {
+		entry.Summary = reporter.Summary()
+		entry.Outputs = reporter.Outputs()
 	}
 
-	if err := a.apigateway.Unmount(ctx); err != nil {
-		log.Error().Err(err).Msg("apigateway unmount failed")
-		return err
+	event := journal.Succeeded
+	if err != nil {
+		event = journal.Failed
 	}
+	a.journalRecord(ctx, name, event, err, entry.Outputs)
 
-	if err := a.cloudwatch.Unmount(ctx); err != nil {
-		log.Error().Err(err).Msg("cloudwatch unmount failed")
-		return err
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result.Add(entry, started, err)
+	if entry.Outputs != nil {
+		a.outputs[name] = entry.Outputs
 	}
+}
 
-	if err := a.lambda.Unmount(ctx); err != nil {
-		log.Error().Err(err).Msg("lambda unmount failed")
-		return err
+// finish marks the steps that never ran as skipped and orders the report by dependency
+func (a *Saga) finish(result *report.Report, err error) (*report.Report, error) {
+	for _, name := range a.graph.Names() {
+		if !slices.ContainsFunc(result.Steps, func(s report.Step) bool { return s.Name == name }) {
+			result.Skip(name)
+		}
 	}
 
-	if err := a.iam.Unmount(ctx); err != nil {
-		log.Error().Err(err).Msg("iam unmount failed")
-		return err
+	if order, sortErr := a.graph.Sort(); sortErr == nil {
+		if result.Action == "destroy" {
+			slices.Reverse(order)
+		}
+		result.Sort(order)
+	}
+
+	result.Finish(err)
+	return result, err
+}
+
+//
+// Journal
+//
+
+// resumable returns the selected steps that succeeded in the last unfinished deploy when resuming
+func (a *Saga) resumable(ctx context.Context, selected *dag.Graph) ([]string, error) {
+	if !a.SagaResume {
+		return nil, nil
+	}
+
+	completed, err := a.journal.Completed(ctx, "deploy")
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(completed, func(name string) bool {
+		return !selected.Has(name)
+	}), nil
+}
+
+// journal failures are logged rather than failing the saga, as the AWS resources are the source of truth
+
+func (a *Saga) begin(ctx context.Context, action string) {
+	if a.journal == nil {
+		return
+	}
+
+	if err := a.journal.Begin(ctx, action); err != nil {
+		log.Warn().Err(err).Msg("journal")
+	}
+}
+
+func (a *Saga) journalRecord(ctx context.Context, name string, event journal.Event, cause error, outputs map[string]string) {
+	if a.journal == nil {
+		return
+	}
+
+	if err := a.journal.Record(ctx, name, event, cause, outputs); err != nil {
+		log.Warn().Err(err).Msg("journal")
+	}
+}
+
+func (a *Saga) end(ctx context.Context, cause error) {
+	if a.journal == nil {
+		return
+	}
+
+	if err := a.journal.End(ctx, cause); err != nil {
+		log.Warn().Err(err).Msg("journal")
+	}
+}
+
+//
+// Hooks
+//
+
+// hook runs the hooks of event with the outputs of the steps that have finished. Steps
+// still running are left out, as their outputs are being written concurrently.
+func (a *Saga) hook(ctx context.Context, event hook.Event) error {
+	if a.hooks == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	outputs := maps.Clone(a.outputs)
+	a.mu.Unlock()
+
+	return a.hooks.Run(ctx, event, outputs)
+}
+
+//
+// Lock
+//
+
+// lock returns the context the run continues with, which is cancelled should the lock be lost
+func (a *Saga) lock(ctx context.Context) (context.Context, error) {
+	if a.locker == nil {
+		return ctx, nil
+	}
+
+	return a.locker.Lock(ctx)
+}
+
+// lost returns why the locked context was cancelled, unless it was the caller cancelling parent
+func lost(parent, locked context.Context) error {
+	if locked.Err() == nil || parent.Err() != nil {
+		return nil
+	}
+
+	return context.Cause(locked)
+}
+
+// unlock releases the lock even when ctx was cancelled, so that an interrupted run does not hold it until expiry
+func (a *Saga) unlock(ctx context.Context) {
+	if a.locker == nil {
+		return
+	}
+
+	if err := a.locker.Unlock(context.WithoutCancel(ctx)); err != nil {
+		log.Warn().Err(err).Msg("unlock failed")
+	}
+}
+
+//
+// Selection
+//
+
+// selected returns the graph of steps chosen by --only and --skip
+func (a *Saga) selected() (*dag.Graph, error) {
+	names := a.graph.Names()
+	if len(a.Selection.SagaOnly) > 0 {
+		names = slices.DeleteFunc(names, func(name string) bool {
+			return !slices.Contains(a.Selection.SagaOnly, name)
+		})
+	}
+
+	names = slices.DeleteFunc(names, func(name string) bool {
+		return slices.Contains(a.Selection.SagaSkip, name)
+	})
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no steps selected")
+	}
+
+	return a.graph.Subset(names), nil
+}
+
+// checkSkippedDependencies refuses to mount a step whose skipped dependency has never been mounted
+func (a *Saga) checkSkippedDependencies(ctx context.Context, selected *dag.Graph) error {
+	checked := map[string]bool{}
+
+	for _, name := range selected.Names() {
+		for _, dependency := range a.graph.Dependencies(name) {
+			if selected.Has(dependency) || checked[dependency] {
+				continue
+			}
+			checked[dependency] = true
+
+			prober, ok := a.steps[dependency].step.(step.Prober)
+			if !ok {
+				log.Warn().
+					Str("step", name).
+					Str("skipped", dependency).
+					Msg("skipped dependency cannot be verified")
+				continue
+			}
+
+			exists, err := prober.Exists(ctx)
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				return fmt.Errorf("%s depends on %s which was skipped and has never been mounted", name, dependency)
+			}
+		}
 	}
 
 	return nil
 }
+
+// warnSkippedDependents warns when unmounting a step that skipped steps still depend upon
+func (a *Saga) warnSkippedDependents(selected *dag.Graph) {
+	for _, name := range selected.Names() {
+		for _, dependent := range a.graph.Dependents(name) {
+			if !selected.Has(dependent) {
+				log.Warn().
+					Str("step", name).
+					Str("skipped", dependent).
+					Msg("unmounting a step that a skipped step depends on")
+			}
+		}
+	}
+}
+
+func toAny(values []string) []any {
+	var result []any
+	for _, value := range values {
+		result = append(result, value)
+	}
+	return result
+}
@@ -0,0 +1,541 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bkeane/monad/pkg/hook"
+	"github.com/bkeane/monad/pkg/inventory"
+	"github.com/bkeane/monad/pkg/journal"
+	"github.com/bkeane/monad/pkg/lock"
+	"github.com/bkeane/monad/pkg/plan"
+	"github.com/bkeane/monad/pkg/report"
+	"github.com/bkeane/monad/pkg/step"
+)
+
+type fakeStep struct {
+	name    string
+	exists  bool
+	fail    error
+	mu      *sync.Mutex
+	mounted *[]string
+}
+
+func (f *fakeStep) Mount(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.mounted = append(*f.mounted, f.name)
+	return f.fail
+}
+
+func (f *fakeStep) Unmount(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.mounted = append(*f.mounted, f.name)
+	return nil
+}
+
+func (f *fakeStep) Exists(ctx context.Context) (bool, error) {
+	return f.exists, nil
+}
+
+type fakeSteps struct {
+	names        []string
+	steps        map[string]step.Step
+	dependencies map[string][]string
+}
+
+func (f *fakeSteps) Names() []string                   { return f.names }
+func (f *fakeSteps) Step(name string) step.Step        { return f.steps[name] }
+func (f *fakeSteps) Dependencies(name string) []string { return f.dependencies[name] }
+
+func collection(exists bool, ran *[]string) *fakeSteps {
+	var mu sync.Mutex
+	steps := &fakeSteps{
+		names: []string{"iam", "lambda", "apigateway"},
+		steps: map[string]step.Step{},
+		dependencies: map[string][]string{
+			"lambda":     {"iam"},
+			"apigateway": {"lambda"},
+		},
+	}
+
+	for _, name := range steps.names {
+		steps.steps[name] = &fakeStep{name: name, exists: exists, mu: &mu, mounted: ran}
+	}
+
+	return steps
+}
+
+type fakeRestorer struct {
+	fakeStep
+	restoreFail error
+}
+
+func (f *fakeRestorer) Snapshot(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.mounted = append(*f.mounted, "snapshot "+f.name)
+	return nil
+}
+
+func (f *fakeRestorer) Restore(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.mounted = append(*f.mounted, "restore "+f.name)
+	return f.restoreFail
+}
+
+// restorers replaces iam and lambda with restorers and fails apigateway, which is unmounted.
+// eventbridge depends on apigateway, so it never mounts.
+func restorers(ran *[]string) *fakeSteps {
+	steps := collection(true, ran)
+	for _, name := range []string{"iam", "lambda"} {
+		steps.steps[name] = &fakeRestorer{fakeStep: *steps.steps[name].(*fakeStep)}
+	}
+	steps.steps["apigateway"].(*fakeStep).fail = errors.New("route conflict")
+
+	steps.names = append(steps.names, "eventbridge")
+	steps.steps["eventbridge"] = &fakeStep{name: "eventbridge", mu: steps.steps["apigateway"].(*fakeStep).mu, mounted: ran}
+	steps.dependencies["eventbridge"] = []string{"apigateway"}
+	return steps
+}
+
+func TestSaga_DoRollbackOnFailure(t *testing.T) {
+	t.Setenv("MONAD_ROLLBACK_ON_FAILURE", "true")
+
+	var ran []string
+	saga, err := Derive(context.Background(), restorers(&ran), Dependencies{})
+	require.NoError(t, err)
+
+	_, err = saga.Do(context.Background())
+	assert.ErrorContains(t, err, "route conflict")
+	assert.Equal(t, []string{
+		"snapshot iam", "iam",
+		"snapshot lambda", "lambda",
+		"apigateway",
+		// compensation in reverse order, unmounting the failed step as it may have partially mounted
+		"apigateway",
+		"restore lambda",
+		"restore iam",
+	}, ran, "eventbridge never mounted, so it is not compensated")
+}
+
+func TestSaga_DoRollbackContinuesPastFailures(t *testing.T) {
+	t.Setenv("MONAD_ROLLBACK_ON_FAILURE", "true")
+
+	var ran []string
+	steps := restorers(&ran)
+	steps.steps["lambda"].(*fakeRestorer).restoreFail = errors.New("alias in use")
+
+	saga, err := Derive(context.Background(), steps, Dependencies{})
+	require.NoError(t, err)
+
+	_, err = saga.Do(context.Background())
+	assert.ErrorContains(t, err, "route conflict")
+	assert.ErrorContains(t, err, "lambda restore: alias in use")
+	assert.Equal(t, []string{"apigateway", "restore lambda", "restore iam"}, ran[5:], "iam is restored although lambda failed to")
+}
+
+func TestSaga_DoNoRollback(t *testing.T) {
+	var ran []string
+	saga, err := Derive(context.Background(), restorers(&ran), Dependencies{})
+	require.NoError(t, err)
+
+	_, err = saga.Do(context.Background())
+	assert.ErrorContains(t, err, "route conflict")
+	assert.Equal(t, []string{"iam", "lambda", "apigateway"}, ran, "without --rollback-on-failure nothing is snapshot or compensated")
+}
+
+func TestSaga_DoOnly(t *testing.T) {
+	t.Setenv("MONAD_ONLY", "lambda")
+
+	var ran []string
+	saga, err := Derive(context.Background(), collection(true, &ran), Dependencies{})
+	require.NoError(t, err)
+
+	_, err = saga.Do(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lambda"}, ran)
+}
+
+func TestSaga_DoSkip(t *testing.T) {
+	t.Setenv("MONAD_SKIP", "apigateway")
+
+	var ran []string
+	saga, err := Derive(context.Background(), collection(true, &ran), Dependencies{})
+	require.NoError(t, err)
+
+	_, err = saga.Do(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iam", "lambda"}, ran)
+}
+
+func TestSaga_DoSkippedDependencyNeverMounted(t *testing.T) {
+	t.Setenv("MONAD_ONLY", "lambda")
+
+	var ran []string
+	saga, err := Derive(context.Background(), collection(false, &ran), Dependencies{})
+	require.NoError(t, err)
+
+	_, err = saga.Do(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "lambda depends on iam")
+	assert.Empty(t, ran)
+}
+
+func TestSaga_UndoOnly(t *testing.T) {
+	t.Setenv("MONAD_ONLY", "apigateway,lambda")
+
+	var ran []string
+	saga, err := Derive(context.Background(), collection(true, &ran), Dependencies{})
+	require.NoError(t, err)
+
+	_, err = saga.Undo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apigateway", "lambda"}, ran)
+}
+
+func TestSaga_UnknownStep(t *testing.T) {
+	t.Setenv("MONAD_SKIP", "dynamo")
+
+	var ran []string
+	_, err := Derive(context.Background(), collection(true, &ran), Dependencies{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be one of iam, lambda, apigateway")
+}
+
+func TestSaga_NothingSelected(t *testing.T) {
+	t.Setenv("MONAD_ONLY", "lambda")
+	t.Setenv("MONAD_SKIP", "lambda")
+
+	var ran []string
+	saga, err := Derive(context.Background(), collection(true, &ran), Dependencies{})
+	require.NoError(t, err)
+	_, err = saga.Do(context.Background())
+	assert.Error(t, err)
+}
+
+func TestSaga_DoReport(t *testing.T) {
+	t.Setenv("MONAD_SKIP", "apigateway")
+
+	var ran []string
+	saga, err := Derive(context.Background(), collection(true, &ran), Dependencies{})
+	require.NoError(t, err)
+
+	result, err := saga.Do(context.Background())
+	require.NoError(t, err)
+	require.Len(t, result.Steps, 3)
+
+	assert.Equal(t, "deploy", result.Action)
+	assert.Equal(t, report.Succeeded, result.Status)
+	assert.Equal(t, "iam", result.Steps[0].Name)
+	assert.Equal(t, report.Succeeded, result.Steps[1].Status)
+	assert.Equal(t, report.Skipped, result.Steps[2].Status)
+}
+
+func TestSaga_DoResume(t *testing.T) {
+	var ran []string
+	steps := collection(true, &ran)
+	steps.steps["apigateway"].(*fakeStep).fail = errors.New("timeout")
+	history := journal.New(journal.NewFile(t.TempDir()), "repo-main-svc", "abc123")
+
+	saga, err := Derive(context.Background(), steps, Dependencies{Journal: history})
+	require.NoError(t, err)
+
+	_, err = saga.Do(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, []string{"iam", "lambda", "apigateway"}, ran)
+
+	t.Setenv("MONAD_RESUME", "true")
+	steps.steps["apigateway"].(*fakeStep).fail = nil
+	ran = nil
+
+	saga, err = Derive(context.Background(), steps, Dependencies{Journal: history})
+	require.NoError(t, err)
+
+	_, err = saga.Do(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apigateway"}, ran)
+
+	ran = nil
+	_, err = saga.Do(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iam", "lambda", "apigateway"}, ran, "a finished deploy has nothing to resume")
+}
+
+func TestSaga_ResumeRequiresJournal(t *testing.T) {
+	t.Setenv("MONAD_RESUME", "true")
+
+	var ran []string
+	_, err := Derive(context.Background(), collection(true, &ran), Dependencies{})
+	assert.ErrorContains(t, err, "required to resume")
+}
+
+func TestSaga_DoLocked(t *testing.T) {
+	ctx := context.Background()
+	backend := lock.NewFile(t.TempDir())
+
+	holder := lock.New(backend, "repo-main-svc", "other", time.Minute, 0)
+	_, err := holder.Lock(ctx)
+	require.NoError(t, err)
+
+	var ran []string
+	saga, err := Derive(ctx, collection(true, &ran), Dependencies{Locker: lock.New(backend, "repo-main-svc", "self", time.Minute, 0)})
+	require.NoError(t, err)
+
+	_, err = saga.Do(ctx)
+	assert.ErrorContains(t, err, "locked by other")
+	assert.Empty(t, ran)
+
+	require.NoError(t, holder.Unlock(ctx))
+
+	_, err = saga.Do(ctx)
+	require.NoError(t, err)
+
+	lease, err := backend.Get(ctx, "repo-main-svc")
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+}
+
+// takeover is a step during whose mount another owner forces the lock and takes it over
+type takeover struct {
+	backend lock.Backend
+}
+
+func (s *takeover) Mount(ctx context.Context) error {
+	other := lock.New(s.backend, "repo-main-svc", "other", time.Minute, 0)
+	if _, err := other.Force(ctx); err != nil {
+		return err
+	}
+	if _, err := other.Lock(ctx); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func (s *takeover) Unmount(ctx context.Context) error { return nil }
+
+func TestSaga_DoLockLost(t *testing.T) {
+	t.Setenv("MONAD_ROLLBACK_ON_FAILURE", "true")
+	ctx := context.Background()
+	backend := lock.NewFile(t.TempDir())
+
+	var ran []string
+	steps := collection(true, &ran)
+	steps.steps["lambda"] = &takeover{backend: backend}
+
+	saga, err := Derive(ctx, steps, Dependencies{Locker: lock.New(backend, "repo-main-svc", "self", time.Second, 0)})
+	require.NoError(t, err)
+
+	result, err := saga.Do(ctx)
+	var lost *lock.LostError
+	assert.ErrorAs(t, err, &lost)
+	assert.Equal(t, report.Failed, result.Status)
+	assert.Equal(t, []string{"iam"}, ran, "nothing mounts or is compensated once the lock is lost")
+
+	lease, err := backend.Get(ctx, "repo-main-svc")
+	require.NoError(t, err)
+	assert.Equal(t, "other", lease.Owner)
+}
+
+func TestSaga_DoHooks(t *testing.T) {
+	var ran []string
+	hooks, err := hook.New([]hook.Hook{
+		{Name: "gate", When: hook.Pre, Step: "apigateway", Command: "exit 1"},
+	}, nil)
+	require.NoError(t, err)
+
+	saga, err := Derive(context.Background(), collection(true, &ran), Dependencies{Hooks: hooks})
+	require.NoError(t, err)
+
+	result, err := saga.Do(context.Background())
+	assert.ErrorContains(t, err, "pre-deploy apigateway hook gate failed")
+	assert.Equal(t, []string{"iam", "lambda"}, ran)
+	assert.Equal(t, report.Failed, result.Status)
+}
+
+func TestSaga_UnknownHookStep(t *testing.T) {
+	var ran []string
+	hooks, err := hook.New([]hook.Hook{
+		{Name: "gate", When: hook.Pre, Step: "dynamodb", Command: "true"},
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = Derive(context.Background(), collection(true, &ran), Dependencies{Hooks: hooks})
+	assert.ErrorContains(t, err, "hook step dynamodb must be one of")
+}
+
+type fakeReconciler struct {
+	fakeStep
+	reconciled bool
+}
+
+func (f *fakeReconciler) Reconcile(ctx context.Context) error {
+	f.reconciled = true
+	return nil
+}
+
+func TestSaga_DoReconcile(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		if enabled {
+			t.Setenv("MONAD_RECONCILE", "true")
+		}
+
+		var ran []string
+		steps := collection(true, &ran)
+		reconciler := &fakeReconciler{fakeStep: *steps.steps["lambda"].(*fakeStep)}
+		steps.steps["lambda"] = reconciler
+
+		saga, err := Derive(context.Background(), steps, Dependencies{})
+		require.NoError(t, err)
+
+		_, err = saga.Do(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, enabled, reconciler.reconciled)
+		if enabled {
+			assert.ElementsMatch(t, []string{"iam", "apigateway"}, ran)
+		} else {
+			assert.ElementsMatch(t, []string{"iam", "lambda", "apigateway"}, ran)
+		}
+	}
+}
+
+type fakePlanner struct {
+	fakeStep
+	plan *plan.Plan
+}
+
+func (f *fakePlanner) Plan(ctx context.Context) (*plan.Plan, error) {
+	return f.plan, nil
+}
+
+func TestSaga_Drift(t *testing.T) {
+	var ran []string
+	steps := collection(true, &ran)
+
+	drifted := plan.New("lambda")
+	drifted.Field("function", "svc", "memory", "128", "256")
+
+	steps.steps["iam"] = &fakePlanner{plan: plan.New("iam")}
+	steps.steps["lambda"] = &fakePlanner{plan: drifted}
+
+	saga, err := Derive(context.Background(), steps, Dependencies{})
+	require.NoError(t, err)
+
+	plans, err := saga.Drift(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []*plan.Plan{drifted}, plans)
+
+	t.Setenv("MONAD_SKIP", "lambda")
+	saga, err = Derive(context.Background(), steps, Dependencies{})
+	require.NoError(t, err)
+
+	plans, err = saga.Drift(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, plans)
+	assert.Empty(t, ran, "drift never mounts")
+}
+
+type fakeDescriber struct {
+	fakeStep
+	inventory *inventory.Inventory
+}
+
+func (f *fakeDescriber) Describe(ctx context.Context) (*inventory.Inventory, error) {
+	return f.inventory, nil
+}
+
+func TestSaga_Describe(t *testing.T) {
+	var ran []string
+	steps := collection(true, &ran)
+
+	role := inventory.New("iam")
+	role.Add("role", "svc")
+	function := inventory.New("lambda")
+	function.Add("function", "svc").Set("memory", "128 MB")
+
+	// apigateway remains a plain step, which is left out
+	steps.steps["lambda"] = &fakeDescriber{inventory: function}
+	steps.steps["iam"] = &fakeDescriber{inventory: role}
+
+	saga, err := Derive(context.Background(), steps, Dependencies{})
+	require.NoError(t, err)
+
+	inventories, err := saga.Describe(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []*inventory.Inventory{role, function}, inventories)
+	assert.Empty(t, ran, "describe never mounts")
+}
+
+// fakeReporter writes its outputs while mounting, as the steps do
+type fakeReporter struct {
+	fakeStep
+	outputs map[string]string
+}
+
+func (f *fakeReporter) Mount(ctx context.Context) error {
+	for i := range 100 {
+		f.outputs = map[string]string{"attempt": strconv.Itoa(i)}
+		time.Sleep(10 * time.Microsecond)
+	}
+	return f.fakeStep.Mount(ctx)
+}
+
+func (f *fakeReporter) Summary() any               { return nil }
+func (f *fakeReporter) Outputs() map[string]string { return f.outputs }
+
+// TestSaga_DoHookOutputs runs the hooks of two reporters mounting concurrently, and is
+// meant to be run with -race
+func TestSaga_DoHookOutputs(t *testing.T) {
+	var mu sync.Mutex
+	var ran []string
+	steps := &fakeSteps{
+		names: []string{"lambda", "apigateway", "eventbridge"},
+		steps: map[string]step.Step{},
+		dependencies: map[string][]string{
+			"apigateway":  {"lambda"},
+			"eventbridge": {"lambda"},
+		},
+	}
+	for _, name := range steps.names {
+		steps.steps[name] = &fakeReporter{fakeStep: fakeStep{name: name, mu: &mu, mounted: &ran}}
+	}
+
+	var hooks []hook.Hook
+	for _, name := range steps.names {
+		for _, when := range []string{hook.Pre, hook.Post} {
+			hooks = append(hooks, hook.Hook{Name: when + "-" + name, When: when, Step: name, Command: "true"})
+		}
+	}
+
+	runner, err := hook.New(hooks, nil)
+	require.NoError(t, err)
+
+	saga, err := Derive(context.Background(), steps, Dependencies{Hooks: runner})
+	require.NoError(t, err)
+
+	result, err := saga.Do(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"lambda", "apigateway", "eventbridge"}, ran)
+	assert.Equal(t, map[string]string{"attempt": "99"}, result.Steps[1].Outputs)
+	assert.Equal(t, map[string]map[string]string{
+		"lambda":      {"attempt": "99"},
+		"apigateway":  {"attempt": "99"},
+		"eventbridge": {"attempt": "99"},
+	}, saga.outputs)
+}
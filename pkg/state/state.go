@@ -2,15 +2,26 @@ package state
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/bkeane/monad/pkg/basis"
 	"github.com/bkeane/monad/pkg/basis/caller"
 	"github.com/bkeane/monad/pkg/basis/git"
 	"github.com/bkeane/monad/pkg/basis/service"
+	"github.com/bkeane/monad/pkg/format"
 
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
-	"github.com/charmbracelet/lipgloss/table"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
 )
 
 //
@@ -23,16 +34,30 @@ type Basis interface {
 	Service() (*service.Basis, error)
 }
 
+// LambdaClient interface for dependency injection and testing
+type LambdaClient interface {
+	ListFunctions(ctx context.Context, params *lambda.ListFunctionsInput, optFns ...func(*lambda.Options)) (*lambda.ListFunctionsOutput, error)
+	ListTags(ctx context.Context, params *lambda.ListTagsInput, optFns ...func(*lambda.Options)) (*lambda.ListTagsOutput, error)
+}
+
+// tagConcurrency bounds the ListTags requests in flight while listing
+const tagConcurrency = 10
+
+// lastModified is the layout of the LastModified time of a lambda function
+const lastModified = "2006-01-02T15:04:05.000-0700"
+
 //
 // StateMetadata
 //
 
 type StateMetadata struct {
-	Service string
-	Owner   string
-	Repo    string
-	Branch  string
-	Sha     string
+	Service  string    `json:"service" yaml:"service"`
+	Owner    string    `json:"owner" yaml:"owner"`
+	Repo     string    `json:"repo" yaml:"repo"`
+	Branch   string    `json:"branch" yaml:"branch"`
+	Sha      string    `json:"sha" yaml:"sha"`
+	Modified time.Time `json:"modified" yaml:"modified"`
+	Digest   string    `json:"digest,omitempty" yaml:"digest,omitempty"`
 }
 
 //
@@ -40,16 +65,30 @@ type StateMetadata struct {
 //
 
 type State struct {
-	basis  Basis
-	client *lambda.Client
-	caller *caller.Basis
-	git    *git.Basis
+	StateColumns []string `env:"MONAD_COLUMNS" flag:"--columns" usage:"Add columns to the table (modified, digest)" hint:"column"`
+	StateSort    string   `env:"MONAD_SORT" flag:"--sort" usage:"Sort by service, owner, repo, branch, sha or modified, descending when prefixed with -" hint:"column"`
+	StateLimit   int      `env:"MONAD_LIMIT" flag:"--limit" usage:"List at most this many services" hint:"count"`
+	basis        Basis
+	client       LambdaClient
+	caller       *caller.Basis
+	git          *git.Basis
+	service      string
+	sha          string
+	services     []string
+	patterns     map[string]*regexp.Regexp
 }
 
+// sortable are the fields services may be sorted by
+var sortable = []string{"service", "owner", "repo", "branch", "sha", "modified"}
+
 func Init(ctx context.Context, basis *basis.Basis) (*State, error) {
 	var err error
 	var state State
 
+	if err = env.Parse(&state); err != nil {
+		return nil, err
+	}
+
 	state.basis = basis
 	state.caller, err = basis.Caller()
 	if err != nil {
@@ -61,89 +100,224 @@ func Init(ctx context.Context, basis *basis.Basis) (*State, error) {
 		return nil, err
 	}
 
+	// service and sha only filter when given, as their defaults come from the working
+	// directory and HEAD rather than naming what to list
+	serviceBasis, err := basis.Service()
+	if err != nil {
+		return nil, err
+	}
+
+	if serviceBasis.Explicit() {
+		state.service = serviceBasis.Name()
+	}
+
+	if state.git.ExplicitSha() {
+		state.sha = state.git.Sha()
+	}
+
 	state.client = lambda.NewFromConfig(state.caller.AwsConfig())
 
+	if err = state.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &state, nil
 }
 
+// Validate checks the flags and compiles the filter patterns, which are matched against every function
+func (s *State) Validate() error {
+	if err := v.ValidateStruct(s,
+		v.Field(&s.StateColumns, v.Each(v.In("modified", "digest"))),
+		v.Field(&s.StateSort, v.By(sortBy)),
+		v.Field(&s.StateLimit, v.Min(0)),
+		v.Field(&s.service, v.By(validPattern)),
+	); err != nil {
+		return err
+	}
+
+	patterns := []string{s.service}
+	if s.git != nil {
+		patterns = append(patterns, s.git.Owner(), s.git.Repo(), s.git.Branch())
+	}
+
+	s.patterns = map[string]*regexp.Regexp{}
+	for _, pattern := range patterns {
+		re, err := compile(pattern)
+		if err != nil {
+			return err
+		}
+		s.patterns[pattern] = re
+	}
+
+	return nil
+}
+
+func sortBy(value interface{}) error {
+	field := strings.TrimPrefix(value.(string), "-")
+	if field != "" && !slices.Contains(sortable, field) {
+		return fmt.Errorf("must be one of %s", strings.Join(sortable, ", "))
+	}
+	return nil
+}
+
+func validPattern(value interface{}) error {
+	_, err := compile(value.(string))
+	return err
+}
+
+// Services restricts listing to the named services, e.g. those of a monorepo
+func (s *State) Services(names []string) {
+	s.services = names
+}
+
 func (s *State) List(ctx context.Context) ([]*StateMetadata, error) {
-	list := &lambda.ListFunctionsInput{}
-	functions, err := s.client.ListFunctions(ctx, list)
+	all, err := s.All(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	var services []*StateMetadata
-	for _, function := range functions.Functions {
-		if metadata := s.extractFromTags(ctx, *function.FunctionArn); metadata != nil {
-			// Apply filtering based on basis values (* means all)
-			if s.matchesFilter(metadata) {
-				services = append(services, metadata)
+	for _, metadata := range all {
+		// Apply filtering based on basis values (* means all)
+		if s.matchesFilter(metadata) {
+			services = append(services, metadata)
+		}
+	}
+
+	return services, nil
+}
+
+// All returns every deployed service regardless of the basis filter values.
+// Every page of functions is listed, and their tags are read concurrently.
+func (s *State) All(ctx context.Context) ([]*StateMetadata, error) {
+	var functions []types.FunctionConfiguration
+
+	paginator := lambda.NewListFunctionsPaginator(s.client, &lambda.ListFunctionsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, page.Functions...)
+	}
+
+	found := make([]*StateMetadata, len(functions))
+	errs := make([]error, len(functions))
+	semaphore := make(chan struct{}, tagConcurrency)
+	var wg sync.WaitGroup
+
+	for i, function := range functions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			metadata, err := s.extractFromTags(ctx, *function.FunctionArn)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to list tags of %s: %w", *function.FunctionArn, err)
+				return
+			}
+
+			if metadata != nil {
+				if function.LastModified != nil {
+					metadata.Modified, _ = time.Parse(lastModified, *function.LastModified)
+				}
+				// the code sha of an image function is the digest of its image
+				if function.CodeSha256 != nil {
+					metadata.Digest = "sha256:" + *function.CodeSha256
+				}
+				found[i] = metadata
 			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// a function whose tags could not be read may be a service, so listing fails
+	// rather than leaving it out
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var services []*StateMetadata
+	for _, metadata := range found {
+		if metadata != nil {
+			services = append(services, metadata)
 		}
 	}
 
 	return services, nil
 }
 
-func (s *State) Table(ctx context.Context) (string, error) {
+// Rows returns the listed services sorted and limited by --sort and --limit, along with
+// their rows including the --columns
+func (s *State) Rows(ctx context.Context) (format.Rows[*StateMetadata], error) {
 	services, err := s.List(ctx)
 	if err != nil {
-		return "", err
+		return format.Rows[*StateMetadata]{}, err
 	}
 
-	// Sort by repo first, then by branch with current branch first within each repo
-	currentBranch := s.git.Branch()
-	sort.Slice(services, func(i, j int) bool {
-		// First group by repo
-		if services[i].Repo != services[j].Repo {
-			return services[i].Repo < services[j].Repo
-		}
+	s.sort(services)
 
-		// Within the same repo, current branch comes first
-		if services[i].Branch == currentBranch && services[j].Branch != currentBranch {
-			return true
-		}
-		if services[i].Branch != currentBranch && services[j].Branch == currentBranch {
-			return false
-		}
+	if s.StateLimit > 0 && len(services) > s.StateLimit {
+		services = services[:s.StateLimit]
+	}
 
-		// Otherwise sort alphabetically by branch within the same repo
-		return services[i].Branch < services[j].Branch
-	})
+	headers := []string{"Service", "Owner", "Repo", "Branch", "Sha"}
+	for _, column := range s.StateColumns {
+		headers = append(headers, strings.ToUpper(column[:1])+column[1:])
+	}
+
+	return format.Rows[*StateMetadata]{
+		Headers: headers,
+		Records: services,
+		Row:     func(m *StateMetadata) []string { return s.row(m, false) },
+		Short:   func(m *StateMetadata) []string { return s.row(m, true) },
+	}, nil
+}
 
-	tbl := table.New()
-	tbl.Headers("Service", "Owner", "Repo", "Branch", "Sha")
+func (s *State) Table(ctx context.Context) (string, error) {
+	rows, err := s.Rows(ctx)
+	if err != nil {
+		return "", err
+	}
 
-	for _, service := range services {
-		tbl.Row(service.Service, service.Owner, service.Repo, service.Branch, truncate(service.Sha))
+	var values [][]string
+	for _, service := range rows.Records {
+		values = append(values, rows.Short(service))
 	}
 
-	return tbl.Render(), nil
+	return format.Table(rows.Headers, values), nil
 }
 
 //
 // Helpers
 //
 
-func (s *State) extractFromTags(ctx context.Context, functionArn string) *StateMetadata {
+// extractFromTags returns the metadata of a function tagged by monad, or nil for other functions
+func (s *State) extractFromTags(ctx context.Context, functionArn string) (*StateMetadata, error) {
 	listTags := &lambda.ListTagsInput{
 		Resource: &functionArn,
 	}
 
-	tagsOutput, err := s.client.ListTags(ctx, listTags)
+	tagsOutput, err := s.client.ListTags(ctx, listTags, retryThrottled)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	tags := tagsOutput.Tags
 	if tags == nil {
-		return nil
+		return nil, nil
 	}
 
 	// Check for Monad tag to identify our functions
 	if monad, ok := tags["Monad"]; !ok || monad != "true" {
-		return nil
+		return nil, nil
 	}
 
 	metadata := &StateMetadata{}
@@ -151,51 +325,205 @@ func (s *State) extractFromTags(ctx context.Context, functionArn string) *StateM
 	// Extract required tags
 	var ok bool
 	if metadata.Service, ok = tags["Service"]; !ok {
-		return nil
+		return nil, nil
 	}
 	if metadata.Owner, ok = tags["Owner"]; !ok {
-		return nil
+		return nil, nil
 	}
 	if metadata.Repo, ok = tags["Repo"]; !ok {
-		return nil
+		return nil, nil
 	}
 	if metadata.Branch, ok = tags["Branch"]; !ok {
-		return nil
+		return nil, nil
 	}
 	if metadata.Sha, ok = tags["Sha"]; !ok {
-		return nil
+		return nil, nil
 	}
 
-	return metadata
+	return metadata, nil
 }
 
-// matchesFilter checks if metadata matches the basis filter values
-// * means match all for that field
+// retryThrottled retries the ListTags requests throttled while tags are read concurrently
+func retryThrottled(options *lambda.Options) {
+	options.Retryer = retry.AddWithErrorCodes(options.Retryer,
+		(*types.TooManyRequestsException)(nil).ErrorCode(),
+	)
+	options.Retryer = retry.AddWithMaxAttempts(options.Retryer, 10)
+}
+
+// matchesFilter checks if metadata matches the basis filter values, see match for
+// the patterns they may be. The service and sha only filter when given explicitly.
 func (s *State) matchesFilter(metadata *StateMetadata) bool {
 	gitBasis := s.git
 	
 	// Check owner filter
-	if gitBasis.Owner() != "*" && gitBasis.Owner() != metadata.Owner {
+	if !s.match(gitBasis.Owner(), metadata.Owner) {
 		return false
 	}
 	
 	// Check repo filter
-	if gitBasis.Repo() != "*" && gitBasis.Repo() != metadata.Repo {
+	if !s.match(gitBasis.Repo(), metadata.Repo) {
 		return false
 	}
 	
 	// Check branch filter
-	if gitBasis.Branch() != "*" && gitBasis.Branch() != metadata.Branch {
+	if !s.match(gitBasis.Branch(), metadata.Branch) {
 		return false
 	}
 	
-	// Service filtering is disabled for now since there's no clean way to distinguish
-	// between explicitly set service names vs defaults from directory name
-	// Users can still filter manually after getting the results
+	// Check service filter, given by --service or by the services of a monorepo
+	if s.service != "" && !s.match(s.service, metadata.Service) {
+		return false
+	}
+	if len(s.services) > 0 && !slices.Contains(s.services, metadata.Service) {
+		return false
+	}
+
+	// Check sha prefix
+	if s.sha != "" && !strings.HasPrefix(metadata.Sha, s.sha) {
+		return false
+	}
 	
 	return true
 }
 
+// match reports whether the value matches the pattern, using the pattern compiled by
+// Validate and compiling those it has not seen
+func (s *State) match(pattern, value string) bool {
+	re, ok := s.patterns[pattern]
+	if !ok {
+		var err error
+		if re, err = compile(pattern); err != nil {
+			return false
+		}
+
+		if s.patterns == nil {
+			s.patterns = map[string]*regexp.Regexp{}
+		}
+		s.patterns[pattern] = re
+	}
+
+	return re.MatchString(value)
+}
+
+// compile returns the regexp of a pattern, which is /regexp/ for a regular expression or
+// otherwise a glob whose * matches any characters, slashes included, such that feat*
+// matches feat/cart. Other characters match themselves, so names holding [ or ? match
+// exactly.
+func compile(pattern string) (*regexp.Regexp, error) {
+	if isRegexp(pattern) {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %s: %w", pattern, err)
+		}
+		return re, nil
+	}
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$"), nil
+}
+
+func isRegexp(pattern string) bool {
+	return len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// sort orders services by --sort, or by default by repo, then by branch with the current
+// branch first within each repo
+func (s *State) sort(services []*StateMetadata) {
+	if s.StateSort != "" {
+		field := strings.TrimPrefix(s.StateSort, "-")
+		descending := strings.HasPrefix(s.StateSort, "-")
+
+		slices.SortStableFunc(services, func(a, b *StateMetadata) int {
+			c := a.compare(b, field)
+			if descending {
+				return -c
+			}
+			return c
+		})
+		return
+	}
+
+	currentBranch := s.git.Branch()
+	sort.Slice(services, func(i, j int) bool {
+		// First group by repo
+		if services[i].Repo != services[j].Repo {
+			return services[i].Repo < services[j].Repo
+		}
+
+		// Within the same repo, current branch comes first
+		if services[i].Branch == currentBranch && services[j].Branch != currentBranch {
+			return true
+		}
+		if services[i].Branch != currentBranch && services[j].Branch == currentBranch {
+			return false
+		}
+
+		// Otherwise sort alphabetically by branch within the same repo
+		return services[i].Branch < services[j].Branch
+	})
+}
+
+// compare compares services by a sortable field
+func (m *StateMetadata) compare(other *StateMetadata, field string) int {
+	switch field {
+	case "service":
+		return strings.Compare(m.Service, other.Service)
+	case "owner":
+		return strings.Compare(m.Owner, other.Owner)
+	case "repo":
+		return strings.Compare(m.Repo, other.Repo)
+	case "branch":
+		return strings.Compare(m.Branch, other.Branch)
+	case "sha":
+		return strings.Compare(m.Sha, other.Sha)
+	case "modified":
+		return m.Modified.Compare(other.Modified)
+	default:
+		return 0
+	}
+}
+
+// row returns the values of a service in the order of the headers, abbreviated when short
+func (s *State) row(m *StateMetadata, short bool) []string {
+	sha := m.Sha
+	if short {
+		sha = truncate(sha)
+	}
+
+	row := []string{m.Service, m.Owner, m.Repo, m.Branch, sha}
+	for _, column := range s.StateColumns {
+		row = append(row, m.column(column, short))
+	}
+
+	return row
+}
+
+// column returns the value of an optional table column, abbreviated when short
+func (m *StateMetadata) column(name string, short bool) string {
+	switch name {
+	case "modified":
+		if m.Modified.IsZero() {
+			return ""
+		}
+		if short {
+			return m.Modified.Format("2006-01-02 15:04")
+		}
+		return m.Modified.Format(time.RFC3339)
+	case "digest":
+		if short && len(m.Digest) > 19 {
+			return m.Digest[:19]
+		}
+		return m.Digest
+	default:
+		return ""
+	}
+}
+
 // truncate shortens git SHA to 7 characters for display
 func truncate(s string) string {
 	if len(s) <= 7 {
@@ -1,13 +1,24 @@
 package state
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bkeane/monad/pkg/basis/caller"
 	"github.com/bkeane/monad/pkg/basis/git"
 	"github.com/bkeane/monad/pkg/basis/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
 )
 
 // Mock implementations for testing
@@ -216,4 +227,254 @@ func TestMatchesFilter_ServiceBasisError(t *testing.T) {
 	
 	// Should still work even if Service() returns an error
 	assert.True(t, state.matchesFilter(metadata))
-}
\ No newline at end of file
+}
+func TestMatchesFilter_Services(t *testing.T) {
+	gitBasis := createTestGitBasis("testowner", "testrepo", "testbranch")
+
+	state := &State{git: gitBasis}
+	state.Services([]string{"api", "web"})
+
+	metadata := &StateMetadata{
+		Service: "api",
+		Owner:   "testowner",
+		Repo:    "testrepo",
+		Branch:  "testbranch",
+		Sha:     "abc123",
+	}
+	assert.True(t, state.matchesFilter(metadata))
+
+	metadata.Service = "worker"
+	assert.False(t, state.matchesFilter(metadata))
+}
+
+// fakeLambda serves functions in pages of 50, tagging every third as unmanaged and
+// throttling the tags of the function numbered throttled when it is set
+type fakeLambda struct {
+	functions     int
+	throttled     int
+	pages         atomic.Int32
+	running, peak atomic.Int32
+}
+
+func (f *fakeLambda) ListFunctions(ctx context.Context, params *lambda.ListFunctionsInput, optFns ...func(*lambda.Options)) (*lambda.ListFunctionsOutput, error) {
+	f.pages.Add(1)
+
+	start := 0
+	if params.Marker != nil {
+		start, _ = strconv.Atoi(*params.Marker)
+	}
+
+	end := min(start+50, f.functions)
+	output := &lambda.ListFunctionsOutput{}
+	for i := start; i < end; i++ {
+		output.Functions = append(output.Functions, types.FunctionConfiguration{
+			FunctionArn:  aws.String(fmt.Sprintf("arn:aws:lambda:us-east-1:123456789012:function:fn-%d", i)),
+			LastModified: aws.String("2025-06-01T12:00:00.000+0000"),
+			CodeSha256:   aws.String(fmt.Sprintf("%064d", i)),
+		})
+	}
+
+	if end < f.functions {
+		output.NextMarker = aws.String(strconv.Itoa(end))
+	}
+
+	return output, nil
+}
+
+func (f *fakeLambda) ListTags(ctx context.Context, params *lambda.ListTagsInput, optFns ...func(*lambda.Options)) (*lambda.ListTagsOutput, error) {
+	current := f.running.Add(1)
+	defer f.running.Add(-1)
+
+	for {
+		previous := f.peak.Load()
+		if current <= previous || f.peak.CompareAndSwap(previous, current) {
+			break
+		}
+	}
+
+	time.Sleep(time.Millisecond)
+
+	var i int
+	fmt.Sscanf(*params.Resource, "arn:aws:lambda:us-east-1:123456789012:function:fn-%d", &i)
+	if f.throttled > 0 && i == f.throttled {
+		return nil, &types.TooManyRequestsException{Message: aws.String("Rate exceeded")}
+	}
+
+	if i%3 == 0 {
+		return &lambda.ListTagsOutput{Tags: map[string]string{}}, nil
+	}
+
+	return &lambda.ListTagsOutput{Tags: map[string]string{
+		"Monad":   "true",
+		"Service": fmt.Sprintf("svc-%d", i),
+		"Owner":   "testowner",
+		"Repo":    "testrepo",
+		"Branch":  "main",
+		"Sha":     "abc123",
+	}}, nil
+}
+
+func TestAll_Paginated(t *testing.T) {
+	client := &fakeLambda{functions: 120}
+	state := &State{client: client}
+
+	services, err := state.All(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(3), client.pages.Load())
+	assert.LessOrEqual(t, client.peak.Load(), int32(tagConcurrency))
+	require.Len(t, services, 80)
+
+	// Functions keep their listed order
+	assert.Equal(t, "svc-1", services[0].Service)
+	assert.Equal(t, "svc-2", services[1].Service)
+	assert.Equal(t, "svc-119", services[79].Service)
+	assert.Equal(t, 2025, services[0].Modified.Year())
+}
+
+func TestAll_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	state := &State{client: &fakeLambda{functions: 10}}
+	_, err := state.All(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAll_Throttled(t *testing.T) {
+	state := &State{client: &fakeLambda{functions: 10, throttled: 4}}
+
+	_, err := state.All(context.Background())
+	var throttled *types.TooManyRequestsException
+	assert.ErrorAs(t, err, &throttled, "a service whose tags cannot be read is not left out")
+	assert.ErrorContains(t, err, "function:fn-4")
+}
+
+func TestRetryThrottled(t *testing.T) {
+	options := lambda.Options{Retryer: retry.NewStandard()}
+	retryThrottled(&options)
+
+	assert.Equal(t, 10, options.Retryer.MaxAttempts())
+	assert.True(t, options.Retryer.IsErrorRetryable(&types.TooManyRequestsException{Message: aws.String("Rate exceeded")}))
+}
+
+func TestTable_Columns(t *testing.T) {
+	state := &State{client: &fakeLambda{functions: 3}, git: createTestGitBasis("*", "*", "*")}
+
+	table, err := state.Table(context.Background())
+	require.NoError(t, err)
+	assert.NotContains(t, table, "Digest")
+
+	state.StateColumns = []string{"digest", "modified"}
+	table, err = state.Table(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, table, "Digest")
+	assert.Contains(t, table, "Modified")
+	assert.Contains(t, table, "sha256:000000000000")
+	assert.Contains(t, table, "2025-06-01")
+
+	assert.NoError(t, state.Validate())
+	state.StateColumns = []string{"size"}
+	assert.Error(t, state.Validate())
+}
+
+func TestRows_Full(t *testing.T) {
+	state := &State{client: &fakeLambda{functions: 3}, git: createTestGitBasis("*", "*", "*")}
+	state.StateColumns = []string{"digest", "modified"}
+
+	rows, err := state.Rows(context.Background())
+	require.NoError(t, err)
+	require.Len(t, rows.Records, 2)
+
+	assert.Equal(t, []string{"Service", "Owner", "Repo", "Branch", "Sha", "Digest", "Modified"}, rows.Headers)
+
+	full, short := rows.Row(rows.Records[0]), rows.Short(rows.Records[0])
+	assert.Len(t, full, len(rows.Headers))
+	assert.Greater(t, len(full[5]), len(short[5]), "digests are only abbreviated in the table")
+	assert.Contains(t, full[6], "2025-06-01T")
+}
+
+func TestMatchesFilter_Patterns(t *testing.T) {
+	state := &State{git: createTestGitBasis("acme", "shop-*", "/^feat/.+-wip$/")}
+
+	metadata := &StateMetadata{Service: "api", Owner: "acme", Repo: "shop-api", Branch: "feat/cart-wip", Sha: "abc1234def"}
+	assert.True(t, state.matchesFilter(metadata))
+
+	metadata.Repo = "billing"
+	assert.False(t, state.matchesFilter(metadata), "globs match the whole repo")
+
+	metadata.Repo = "shop-web"
+	metadata.Branch = "main"
+	assert.False(t, state.matchesFilter(metadata))
+
+	assert.True(t, state.match("*", "feat/cart"), "* matches branches holding a slash")
+	assert.True(t, state.match("feat*", "feat/cart"), "globs match across slashes")
+	assert.True(t, state.match("feat/*-wip", "feat/cart/v2-wip"))
+	assert.False(t, state.match("feat*", "hotfix/feat"))
+	assert.True(t, state.match("/cart/", "feat/cart-wip"), "regexps are unanchored")
+	assert.False(t, state.match("/[/", "["))
+
+	assert.True(t, state.match("fix[1]?", "fix[1]?"), "only * is a glob character")
+	assert.False(t, state.match("fix[1]?", "fix1x"))
+}
+
+func TestValidate_Patterns(t *testing.T) {
+	state := &State{git: createTestGitBasis("acme", "shop-*", "/^feat/"), service: "api"}
+	require.NoError(t, state.Validate())
+	assert.Len(t, state.patterns, 4, "patterns are compiled once")
+
+	state = &State{git: createTestGitBasis("acme", "/shop-(/", "*")}
+	assert.ErrorContains(t, state.Validate(), "invalid regexp /shop-(/")
+}
+
+func TestMatchesFilter_ServiceAndSha(t *testing.T) {
+	state := &State{git: createTestGitBasis("*", "*", "*"), service: "api-*", sha: "abc1"}
+
+	metadata := &StateMetadata{Service: "api-users", Owner: "acme", Repo: "shop", Branch: "main", Sha: "abc1234def"}
+	assert.True(t, state.matchesFilter(metadata))
+
+	metadata.Sha = "abd1234def"
+	assert.False(t, state.matchesFilter(metadata), "shas match by prefix")
+
+	metadata.Sha = "abc1234def"
+	metadata.Service = "worker"
+	assert.False(t, state.matchesFilter(metadata))
+}
+
+func TestRows_SortAndLimit(t *testing.T) {
+	state := &State{client: &fakeLambda{functions: 6}, git: createTestGitBasis("*", "*", "*")}
+	state.StateSort = "-service"
+	state.StateLimit = 3
+
+	rows, err := state.Rows(context.Background())
+	require.NoError(t, err)
+
+	var services []string
+	for _, record := range rows.Records {
+		services = append(services, record.Service)
+	}
+	assert.Equal(t, []string{"svc-5", "svc-4", "svc-2"}, services)
+}
+
+func TestValidate_Filters(t *testing.T) {
+	state := &State{git: createTestGitBasis("*", "*", "*")}
+	assert.NoError(t, state.Validate())
+
+	state.StateSort = "-modified"
+	assert.NoError(t, state.Validate())
+
+	state.StateSort = "size"
+	assert.Error(t, state.Validate())
+
+	state.StateSort = ""
+	state.StateLimit = -1
+	assert.Error(t, state.Validate())
+
+	state.StateLimit = 0
+	state.service = "api-["
+	assert.NoError(t, state.Validate(), "names holding [ match exactly")
+
+	state.service = ""
+	state.git = createTestGitBasis("*", "*", "/feat(/")
+	assert.ErrorContains(t, state.Validate(), "invalid regexp")
+}
@@ -5,8 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
+	"github.com/bkeane/monad/pkg/inventory"
+	"github.com/bkeane/monad/pkg/plan"
+	lambdastep "github.com/bkeane/monad/pkg/step/lambda"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
 	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2/types"
@@ -30,43 +35,82 @@ type ApiGatewayConfig interface {
 
 type LambdaConfig interface {
 	FunctionArn() string
+	TargetArn() string
+	FunctionArns() []string
 	Client() *lambda.Client
 }
 
 type Api struct {
-	ApiId string
+	ApiId    string `json:"api_id" yaml:"api_id"`
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
 }
 
 type Route struct {
-	ApiId             string
-	RouteId           string
-	RouteKey          string
-	AuthorizationType string
-	AuthorizerId      string
+	ApiId             string `json:"api_id" yaml:"api_id"`
+	RouteId           string `json:"route_id" yaml:"route_id"`
+	RouteKey          string `json:"route_key" yaml:"route_key"`
+	AuthorizationType string `json:"authorization_type" yaml:"authorization_type"`
+	AuthorizerId      string `json:"authorizer_id,omitempty" yaml:"authorizer_id,omitempty"`
+	IntegrationId     string `json:"integration_id" yaml:"integration_id"`
+	Url               string `json:"url,omitempty" yaml:"url,omitempty"`
 }
 
 type Integration struct {
-	ApiId         string
-	IntegrationId string
+	ApiId           string `json:"api_id" yaml:"api_id"`
+	IntegrationId   string `json:"integration_id" yaml:"integration_id"`
+	IntegrationUri  string `json:"integration_uri,omitempty" yaml:"integration_uri,omitempty"`
+	ForwardedPrefix string `json:"forwarded_prefix,omitempty" yaml:"forwarded_prefix,omitempty"`
 }
 
 type Permission struct {
-	FunctionArn string
-	StatementId string
+	FunctionArn string `json:"function_arn" yaml:"function_arn"`
+	StatementId string `json:"statement_id" yaml:"statement_id"`
+	SourceArn   string `json:"source_arn,omitempty" yaml:"source_arn,omitempty"`
 }
 
 type Summary struct {
-	RoutesDeleted       []Route
-	RoutesCreated       []Route
-	IntegrationsDeleted []Integration
-	IntegrationsCreated []Integration
-	PermissionsDeleted  []Permission
-	PermissionsCreated  []Permission
+	RoutesDeleted       []Route       `json:"routes_deleted,omitempty" yaml:"routes_deleted,omitempty"`
+	RoutesCreated       []Route       `json:"routes_created,omitempty" yaml:"routes_created,omitempty"`
+	RoutesUpdated       []Route       `json:"routes_updated,omitempty" yaml:"routes_updated,omitempty"`
+	RoutesUnchanged     []Route       `json:"routes_unchanged,omitempty" yaml:"routes_unchanged,omitempty"`
+	IntegrationsDeleted []Integration `json:"integrations_deleted,omitempty" yaml:"integrations_deleted,omitempty"`
+	IntegrationsCreated []Integration `json:"integrations_created,omitempty" yaml:"integrations_created,omitempty"`
+	IntegrationsUpdated []Integration `json:"integrations_updated,omitempty" yaml:"integrations_updated,omitempty"`
+	PermissionsDeleted  []Permission  `json:"permissions_deleted,omitempty" yaml:"permissions_deleted,omitempty"`
+	PermissionsCreated  []Permission  `json:"permissions_created,omitempty" yaml:"permissions_created,omitempty"`
+}
+
+// Desired is a route the config binds to the function
+type Desired struct {
+	ApiId        string
+	RouteKey     string
+	AuthType     string
+	AuthorizerId string
+	Prefix       string
+}
+
+// Changes are the writes that bring the routes bound to the function in line with the config
+type Changes struct {
+	Create             []Desired
+	UpdateRoutes       []Route
+	UpdateIntegrations []Integration
+	Keep               []Route
+	DeleteRoutes       []Route
+	DeleteIntegrations []Integration
+}
+
+// Snapshot records the routes bound to the function before a mount
+type Snapshot struct {
+	Routes       []Route
+	Integrations []Integration
+	Permissions  []Permission
 }
 
 type Step struct {
 	apigateway ApiGatewayConfig
 	lambda     LambdaConfig
+	snapshot   *Snapshot
+	summary    Summary
 }
 
 //
@@ -88,6 +132,7 @@ func (s *Step) Mount(ctx context.Context) error {
 
 	// Call internal mount and log only the creates as action=put
 	summary, err := s.mount(ctx)
+	s.summary = summary
 	if err != nil {
 		return err
 	}
@@ -106,9 +151,43 @@ func (s *Step) Mount(ctx context.Context) error {
 	return nil
 }
 
+// Reconcile changes only the routes, integrations and permissions that differ from the config,
+// so that unchanged routes keep serving throughout the deploy
+func (s *Step) Reconcile(ctx context.Context) error {
+	summary, err := s.reconcile(ctx)
+	s.summary = summary
+	if err != nil {
+		return err
+	}
+
+	actions := []struct {
+		action string
+		routes []Route
+	}{
+		{"put", summary.RoutesCreated},
+		{"update", summary.RoutesUpdated},
+		{"keep", summary.RoutesUnchanged},
+		{"delete", summary.RoutesDeleted},
+	}
+
+	for _, logged := range actions {
+		for _, route := range logged.routes {
+			log.Info().
+				Str("id", route.ApiId).
+				Str("route", route.RouteKey).
+				Str("auth", strings.ToLower(route.AuthorizationType)).
+				Str("action", logged.action).
+				Msg("apigatewayv2")
+		}
+	}
+
+	return nil
+}
+
 func (s *Step) Unmount(ctx context.Context) error {
 	// Call internal unmount and log the deletes as action=delete
 	summary, err := s.unmount(ctx)
+	s.summary = summary
 	if err != nil {
 		return err
 	}
@@ -127,6 +206,241 @@ func (s *Step) Unmount(ctx context.Context) error {
 	return nil
 }
 
+// Summary returns the work done by the last mount or unmount
+func (s *Step) Summary() any {
+	return s.summary
+}
+
+// Outputs returns the api along with the url and authorization of every route created by the last mount
+func (s *Step) Outputs() map[string]string {
+	outputs := map[string]string{
+		"api_id": s.apigateway.ApiId(),
+	}
+
+	bound := slices.Concat(s.summary.RoutesCreated, s.summary.RoutesUpdated, s.summary.RoutesUnchanged)
+
+	var urls []string
+	for _, route := range bound {
+		if route.Url != "" && !slices.Contains(urls, route.Url) {
+			urls = append(urls, route.Url)
+		}
+	}
+
+	if len(urls) > 0 {
+		outputs["url"] = strings.Join(urls, ",")
+	}
+
+	var routes []string
+	for _, route := range bound {
+		routes = append(routes, route.RouteKey+"="+strings.ToLower(route.AuthorizationType))
+	}
+
+	if len(routes) > 0 {
+		slices.Sort(routes)
+		outputs["routes"] = strings.Join(routes, ",")
+	}
+
+	return outputs
+}
+
+// Snapshot captures the bound routes so that Restore can return to them
+func (s *Step) Snapshot(ctx context.Context) error {
+	var snapshot Snapshot
+
+	apis, err := s.GetApis(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot.Routes, err = s.GetRoutes(ctx, apis)
+	if err != nil {
+		return err
+	}
+
+	snapshot.Integrations, err = s.GetIntegrations(ctx, apis)
+	if err != nil {
+		return err
+	}
+
+	snapshot.Permissions, err = s.GetPermissions(ctx, apis)
+	if err != nil {
+		return err
+	}
+
+	s.snapshot = &snapshot
+	return nil
+}
+
+// Restore replaces the bound routes with those of the snapshot
+func (s *Step) Restore(ctx context.Context) error {
+	if s.snapshot == nil {
+		return nil
+	}
+
+	if _, err := s.unmount(ctx); err != nil {
+		return err
+	}
+
+	prefixes := map[string]string{}
+	for _, integration := range s.snapshot.Integrations {
+		prefixes[integration.IntegrationId] = integration.ForwardedPrefix
+	}
+
+	for _, previous := range s.snapshot.Routes {
+		integration, err := s.putIntegration(ctx, previous.ApiId, prefixes[previous.IntegrationId])
+		if err != nil {
+			return fmt.Errorf("failed to restore integration for route %s: %w", previous.RouteKey, err)
+		}
+
+		authType := types.AuthorizationType(previous.AuthorizationType)
+		if _, err := s.putRoute(ctx, previous.ApiId, integration.IntegrationId, previous.RouteKey, authType, previous.AuthorizerId); err != nil {
+			return fmt.Errorf("failed to restore route %s: %w", previous.RouteKey, err)
+		}
+
+		log.Info().
+			Str("id", previous.ApiId).
+			Str("route", previous.RouteKey).
+			Str("auth", strings.ToLower(previous.AuthorizationType)).
+			Str("action", "restore").
+			Msg("apigatewayv2")
+	}
+
+	for _, previous := range s.snapshot.Permissions {
+		if _, err := s.putPermission(ctx, previous.StatementId, previous.SourceArn); err != nil {
+			return fmt.Errorf("failed to restore permission %s: %w", previous.StatementId, err)
+		}
+	}
+
+	return nil
+}
+
+// Plan reports the routes and integrations mounting would add, change or remove
+func (s *Step) Plan(ctx context.Context) (*plan.Plan, error) {
+	p := plan.New("apigateway")
+
+	apis, err := s.GetApis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := s.GetRoutes(ctx, apis)
+	if err != nil {
+		return nil, err
+	}
+
+	integrations, err := s.GetIntegrations(ctx, apis)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := map[string]string{}
+	for _, integration := range integrations {
+		prefixes[integration.IntegrationId] = integration.ForwardedPrefix
+	}
+
+	existing := map[string]Route{}
+	for _, route := range routes {
+		existing[route.ApiId+" "+route.RouteKey] = route
+	}
+
+	desired := map[string]bool{}
+	if s.apigateway.ApiId() != "" {
+		routeKeys := s.apigateway.Route()
+		authTypes := s.apigateway.AuthType()
+		authorizerIds := s.apigateway.AuthorizerId()
+
+		forwardedPrefixes, err := s.apigateway.ForwardedPrefixes()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(routeKeys) != len(authTypes) || len(routeKeys) != len(authorizerIds) {
+			return nil, fmt.Errorf("route/auth configuration mismatch: %d routes, %d auth types, %d authorizer ids",
+				len(routeKeys), len(authTypes), len(authorizerIds))
+		}
+
+		for i, routeKey := range routeKeys {
+			subject := s.apigateway.ApiId() + " " + routeKey
+			desired[subject] = true
+
+			route, exists := existing[subject]
+			if !exists {
+				p.Create("route", subject)
+				p.Field("route", subject, "auth", "", authTypes[i])
+				p.Create("integration", subject)
+				continue
+			}
+
+			p.Field("route", subject, "auth", route.AuthorizationType, authTypes[i])
+			p.Field("route", subject, "authorizer", route.AuthorizerId, authorizerIds[i])
+			p.Field("integration", subject, "prefix", prefixes[route.IntegrationId], forwardedPrefixes[i])
+		}
+	}
+
+	routed := map[string]bool{}
+	for _, route := range routes {
+		routed[route.IntegrationId] = true
+
+		subject := route.ApiId + " " + route.RouteKey
+		if !desired[subject] {
+			p.Delete("route", subject)
+			p.Delete("integration", subject)
+		}
+	}
+
+	for _, integration := range integrations {
+		if !routed[integration.IntegrationId] {
+			p.Delete("integration", integration.ApiId+" "+integration.IntegrationId)
+		}
+	}
+
+	return p, nil
+}
+
+// Describe lists the routes bound to the function along with their authorization and url
+func (s *Step) Describe(ctx context.Context) (*inventory.Inventory, error) {
+	i := inventory.New("apigateway")
+
+	apis, err := s.GetApis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := s.GetRoutes(ctx, apis)
+	if err != nil {
+		return nil, err
+	}
+
+	integrations, err := s.GetIntegrations(ctx, apis)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := map[string]string{}
+	for _, api := range apis {
+		endpoints[api.ApiId] = api.Endpoint
+	}
+
+	prefixes := map[string]string{}
+	for _, integration := range integrations {
+		prefixes[integration.IntegrationId] = integration.ForwardedPrefix
+	}
+
+	slices.SortFunc(routes, func(a, b Route) int {
+		return strings.Compare(a.ApiId+" "+a.RouteKey, b.ApiId+" "+b.RouteKey)
+	})
+
+	for _, route := range routes {
+		i.Add("route", route.ApiId+" "+route.RouteKey).
+			Set("auth", strings.ToLower(route.AuthorizationType)).
+			Set("authorizer", route.AuthorizerId).
+			Set("prefix", prefixes[route.IntegrationId]).
+			Set("url", routeUrl(endpoints[route.ApiId], route.RouteKey))
+	}
+
+	return i, nil
+}
+
 // Internal methods that return summaries of work done
 func (s *Step) mount(ctx context.Context) (Summary, error) {
 	var summary Summary
@@ -158,6 +472,7 @@ func (s *Step) mount(ctx context.Context) (Summary, error) {
 		if err != nil {
 			return summary, fmt.Errorf("failed to create route %d: %w", i, err)
 		}
+		route.Url = routeUrl(api.Endpoint, route.RouteKey)
 		summary.RoutesCreated = append(summary.RoutesCreated, route)
 
 		permission, err := s.CreatePermission(ctx, api, i)
@@ -170,6 +485,230 @@ func (s *Step) mount(ctx context.Context) (Summary, error) {
 	return summary, nil
 }
 
+func (s *Step) reconcile(ctx context.Context) (Summary, error) {
+	var summary Summary
+
+	apis, err := s.GetApis(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	routes, err := s.GetRoutes(ctx, apis)
+	if err != nil {
+		return summary, err
+	}
+
+	integrations, err := s.GetIntegrations(ctx, apis)
+	if err != nil {
+		return summary, err
+	}
+
+	permissions, err := s.GetPermissions(ctx, apis)
+	if err != nil {
+		return summary, err
+	}
+
+	var api Api
+	var desired []Desired
+	var granted []Permission
+
+	if s.apigateway.ApiId() != "" {
+		api, err = s.GetApi(ctx, s.apigateway.ApiId())
+		if err != nil {
+			return summary, err
+		}
+
+		desired, granted, err = s.desired(api)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	changes := diff(desired, routes, integrations, s.lambda.TargetArn())
+
+	// integrations are updated in place, so routes served by them never stop resolving
+	for _, integration := range changes.UpdateIntegrations {
+		if err := s.UpdateIntegration(ctx, integration); err != nil {
+			return summary, fmt.Errorf("failed to update integration %s: %w", integration.IntegrationId, err)
+		}
+		summary.IntegrationsUpdated = append(summary.IntegrationsUpdated, integration)
+	}
+
+	for _, route := range changes.UpdateRoutes {
+		if err := s.UpdateRoute(ctx, route); err != nil {
+			return summary, fmt.Errorf("failed to update route %s: %w", route.RouteKey, err)
+		}
+		route.Url = routeUrl(api.Endpoint, route.RouteKey)
+		summary.RoutesUpdated = append(summary.RoutesUpdated, route)
+	}
+
+	for _, route := range changes.Keep {
+		route.Url = routeUrl(api.Endpoint, route.RouteKey)
+		summary.RoutesUnchanged = append(summary.RoutesUnchanged, route)
+	}
+
+	for _, want := range changes.Create {
+		integration, err := s.putIntegration(ctx, want.ApiId, want.Prefix)
+		if err != nil {
+			return summary, fmt.Errorf("failed to create integration for route %s: %w", want.RouteKey, err)
+		}
+		summary.IntegrationsCreated = append(summary.IntegrationsCreated, integration)
+
+		route, err := s.putRoute(ctx, want.ApiId, integration.IntegrationId, want.RouteKey, types.AuthorizationType(want.AuthType), want.AuthorizerId)
+		if err != nil {
+			return summary, fmt.Errorf("failed to create route %s: %w", want.RouteKey, err)
+		}
+		route.Url = routeUrl(api.Endpoint, route.RouteKey)
+		summary.RoutesCreated = append(summary.RoutesCreated, route)
+	}
+
+	for _, route := range changes.DeleteRoutes {
+		if _, err := s.DeleteRoute(ctx, route); err != nil {
+			return summary, err
+		}
+		summary.RoutesDeleted = append(summary.RoutesDeleted, route)
+	}
+
+	for _, integration := range changes.DeleteIntegrations {
+		if _, err := s.DeleteIntegration(ctx, integration); err != nil {
+			return summary, err
+		}
+		summary.IntegrationsDeleted = append(summary.IntegrationsDeleted, integration)
+	}
+
+	// a statement id is unique within the function policy, so changed grants are removed before they are added again
+	for _, permission := range permissions {
+		if !slices.Contains(granted, permission) {
+			if err := s.DeletePermissions(ctx, permission); err != nil {
+				return summary, err
+			}
+			summary.PermissionsDeleted = append(summary.PermissionsDeleted, permission)
+		}
+	}
+
+	for _, permission := range granted {
+		if !slices.Contains(permissions, permission) {
+			created, err := s.putPermission(ctx, permission.StatementId, permission.SourceArn)
+			if err != nil {
+				return summary, fmt.Errorf("failed to create permission %s: %w", permission.StatementId, err)
+			}
+			summary.PermissionsCreated = append(summary.PermissionsCreated, created)
+		}
+	}
+
+	return summary, nil
+}
+
+// desired returns the routes and permissions the config binds to the function within api
+func (s *Step) desired(api Api) ([]Desired, []Permission, error) {
+	routeKeys := s.apigateway.Route()
+	authTypes := s.apigateway.AuthType()
+	authorizerIds := s.apigateway.AuthorizerId()
+
+	if len(routeKeys) != len(authTypes) || len(routeKeys) != len(authorizerIds) {
+		return nil, nil, fmt.Errorf("route/auth configuration mismatch: %d routes, %d auth types, %d authorizer ids",
+			len(routeKeys), len(authTypes), len(authorizerIds))
+	}
+
+	forwardedPrefixes, err := s.apigateway.ForwardedPrefixes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sourceArns, err := s.apigateway.PermissionSourceArns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(forwardedPrefixes) != len(routeKeys) || len(sourceArns) != len(routeKeys) {
+		return nil, nil, fmt.Errorf("route configuration mismatch: %d routes, %d prefixes, %d source arns",
+			len(routeKeys), len(forwardedPrefixes), len(sourceArns))
+	}
+
+	var desired []Desired
+	var permissions []Permission
+
+	for i, routeKey := range routeKeys {
+		desired = append(desired, Desired{
+			ApiId:        api.ApiId,
+			RouteKey:     routeKey,
+			AuthType:     authTypes[i],
+			AuthorizerId: authorizerIds[i],
+			Prefix:       forwardedPrefixes[i],
+		})
+
+		permissions = append(permissions, Permission{
+			FunctionArn: s.lambda.TargetArn(),
+			StatementId: fmt.Sprintf("%s-%d", s.apigateway.PermissionStatementId(api.ApiId), i),
+			SourceArn:   sourceArns[i],
+		})
+	}
+
+	return desired, permissions, nil
+}
+
+// diff compares the desired routes with those bound to the function. Routes are matched by api and
+// route key; a matched route whose authorization differs is updated, as is its integration when its
+// prefix or target differ. Everything bound but not desired is deleted.
+func diff(desired []Desired, routes []Route, integrations []Integration, targetArn string) Changes {
+	var changes Changes
+
+	existing := map[string]Route{}
+	for _, route := range routes {
+		existing[route.ApiId+" "+route.RouteKey] = route
+	}
+
+	byId := map[string]Integration{}
+	for _, integration := range integrations {
+		byId[integration.IntegrationId] = integration
+	}
+
+	matched := map[string]bool{}
+	used := map[string]bool{}
+
+	for _, want := range desired {
+		subject := want.ApiId + " " + want.RouteKey
+		route, exists := existing[subject]
+		if !exists || matched[subject] {
+			changes.Create = append(changes.Create, want)
+			continue
+		}
+		matched[subject] = true
+
+		if integration, ok := byId[route.IntegrationId]; ok && !used[route.IntegrationId] {
+			if integration.ForwardedPrefix != want.Prefix || integration.IntegrationUri != targetArn {
+				integration.ForwardedPrefix = want.Prefix
+				integration.IntegrationUri = targetArn
+				changes.UpdateIntegrations = append(changes.UpdateIntegrations, integration)
+			}
+		}
+		used[route.IntegrationId] = true
+
+		if route.AuthorizationType != want.AuthType || route.AuthorizerId != want.AuthorizerId {
+			route.AuthorizationType = want.AuthType
+			route.AuthorizerId = want.AuthorizerId
+			changes.UpdateRoutes = append(changes.UpdateRoutes, route)
+			continue
+		}
+
+		changes.Keep = append(changes.Keep, route)
+	}
+
+	for _, route := range routes {
+		if !matched[route.ApiId+" "+route.RouteKey] {
+			changes.DeleteRoutes = append(changes.DeleteRoutes, route)
+		}
+	}
+
+	for _, integration := range integrations {
+		if !used[integration.IntegrationId] {
+			changes.DeleteIntegrations = append(changes.DeleteIntegrations, integration)
+		}
+	}
+
+	return changes
+}
+
 func (s *Step) unmount(ctx context.Context) (Summary, error) {
 	var summary Summary
 
@@ -228,32 +767,10 @@ func (s *Step) CreateIntegration(ctx context.Context, api Api, routeIndex int) (
 		return Integration{}, fmt.Errorf("route index %d out of bounds, only %d prefixes available", routeIndex, len(forwardedPrefixes))
 	}
 
-	create := &apigatewayv2.CreateIntegrationInput{
-		ApiId:                aws.String(api.ApiId),
-		ConnectionType:       types.ConnectionTypeInternet,
-		IntegrationType:      types.IntegrationTypeAwsProxy,
-		IntegrationUri:       aws.String(s.lambda.FunctionArn()),
-		PayloadFormatVersion: aws.String("2.0"),
-		RequestParameters: map[string]string{
-			"overwrite:path":                      "/$request.path.proxy",
-			"overwrite:header.X-Forwarded-Prefix": forwardedPrefixes[routeIndex],
-		},
-	}
-
-	client := s.apigateway.Client()
-	integration, err := client.CreateIntegration(ctx, create)
-	if err != nil {
-		return Integration{}, err
-	}
-
-	return Integration{
-		ApiId:         api.ApiId,
-		IntegrationId: *integration.IntegrationId,
-	}, nil
+	return s.putIntegration(ctx, api.ApiId, forwardedPrefixes[routeIndex])
 }
 
 func (s *Step) CreateRoute(ctx context.Context, api Api, integration Integration, routeIndex int) (Route, error) {
-	client := s.apigateway.Client()
 	authTypeMap := map[string]types.AuthorizationType{
 		"NONE":    types.AuthorizationTypeNone,
 		"AWS_IAM": types.AuthorizationTypeAwsIam,
@@ -274,55 +791,90 @@ func (s *Step) CreateRoute(ctx context.Context, api Api, integration Integration
 		return Route{}, fmt.Errorf("unsupported authorization type %s", authTypes[routeIndex])
 	}
 
+	return s.putRoute(ctx, api.ApiId, integration.IntegrationId, routeKeys[routeIndex], authType, authorizerIds[routeIndex])
+}
+
+func (s *Step) CreatePermission(ctx context.Context, api Api, routeIndex int) (Permission, error) {
+	sourceArns, err := s.apigateway.PermissionSourceArns()
+	if err != nil {
+		return Permission{}, err
+	}
+
+	if routeIndex >= len(sourceArns) {
+		return Permission{}, fmt.Errorf("route index %d out of bounds, only %d source ARNs available", routeIndex, len(sourceArns))
+	}
+
+	// Create unique statement ID for each route
+	statementId := fmt.Sprintf("%s-%d", s.apigateway.PermissionStatementId(api.ApiId), routeIndex)
+
+	return s.putPermission(ctx, statementId, sourceArns[routeIndex])
+}
+
+func (s *Step) putIntegration(ctx context.Context, apiId string, forwardedPrefix string) (Integration, error) {
+	create := &apigatewayv2.CreateIntegrationInput{
+		ApiId:                aws.String(apiId),
+		ConnectionType:       types.ConnectionTypeInternet,
+		IntegrationType:      types.IntegrationTypeAwsProxy,
+		IntegrationUri:       aws.String(s.lambda.TargetArn()),
+		PayloadFormatVersion: aws.String("2.0"),
+		RequestParameters: map[string]string{
+			"overwrite:path":                      "/$request.path.proxy",
+			"overwrite:header.X-Forwarded-Prefix": forwardedPrefix,
+		},
+	}
+
+	integration, err := s.apigateway.Client().CreateIntegration(ctx, create)
+	if err != nil {
+		return Integration{}, err
+	}
+
+	return Integration{
+		ApiId:           apiId,
+		IntegrationId:   *integration.IntegrationId,
+		IntegrationUri:  aws.ToString(integration.IntegrationUri),
+		ForwardedPrefix: forwardedPrefix,
+	}, nil
+}
+
+func (s *Step) putRoute(ctx context.Context, apiId string, integrationId string, routeKey string, authType types.AuthorizationType, authorizerId string) (Route, error) {
 	create := &apigatewayv2.CreateRouteInput{
-		ApiId:             aws.String(api.ApiId),
-		RouteKey:          aws.String(routeKeys[routeIndex]),
-		Target:            aws.String(fmt.Sprintf("integrations/%s", integration.IntegrationId)),
+		ApiId:             aws.String(apiId),
+		RouteKey:          aws.String(routeKey),
+		Target:            aws.String(fmt.Sprintf("integrations/%s", integrationId)),
 		AuthorizationType: authType,
-		AuthorizerId:      aws.String(authorizerIds[routeIndex]),
+		AuthorizerId:      aws.String(authorizerId),
 	}
 
-	route, err := client.CreateRoute(ctx, create)
+	route, err := s.apigateway.Client().CreateRoute(ctx, create)
 	if err != nil {
 		return Route{}, err
 	}
 
-	authorizerId := ""
+	routeAuthorizerId := ""
 	if route.AuthorizerId != nil {
-		authorizerId = *route.AuthorizerId
+		routeAuthorizerId = *route.AuthorizerId
 	}
 
 	return Route{
-		ApiId:             api.ApiId,
+		ApiId:             apiId,
 		RouteId:           *route.RouteId,
 		RouteKey:          *route.RouteKey,
 		AuthorizationType: string(route.AuthorizationType),
-		AuthorizerId:      authorizerId,
+		AuthorizerId:      routeAuthorizerId,
+		IntegrationId:     integrationId,
 	}, nil
 }
 
-func (s *Step) CreatePermission(ctx context.Context, api Api, routeIndex int) (Permission, error) {
-	sourceArns, err := s.apigateway.PermissionSourceArns()
-	if err != nil {
-		return Permission{}, err
-	}
-
-	if routeIndex >= len(sourceArns) {
-		return Permission{}, fmt.Errorf("route index %d out of bounds, only %d source ARNs available", routeIndex, len(sourceArns))
-	}
-
-	// Create unique statement ID for each route
-	statementId := fmt.Sprintf("%s-%d", s.apigateway.PermissionStatementId(api.ApiId), routeIndex)
-
+func (s *Step) putPermission(ctx context.Context, statementId string, sourceArn string) (Permission, error) {
 	create := &lambda.AddPermissionInput{
-		FunctionName: aws.String(s.lambda.FunctionArn()),
+		FunctionName: aws.String(s.lambda.TargetArn()),
 		Action:       aws.String("lambda:InvokeFunction"),
 		Principal:    aws.String("apigateway.amazonaws.com"),
-		SourceArn:    aws.String(sourceArns[routeIndex]),
+		SourceArn:    aws.String(sourceArn),
 		StatementId:  aws.String(statementId),
 	}
 
-	_, err = s.lambda.Client().AddPermission(ctx, create)
+	_, err := s.lambda.Client().AddPermission(ctx, create, lambdastep.RetryPolicy)
 	if err != nil {
 		return Permission{}, err
 	}
@@ -330,9 +882,38 @@ func (s *Step) CreatePermission(ctx context.Context, api Api, routeIndex int) (P
 	return Permission{
 		FunctionArn: *create.FunctionName,
 		StatementId: *create.StatementId,
+		SourceArn:   *create.SourceArn,
 	}, nil
 }
 
+// Internal update functions (no logging)
+func (s *Step) UpdateIntegration(ctx context.Context, integration Integration) error {
+	update := &apigatewayv2.UpdateIntegrationInput{
+		ApiId:          aws.String(integration.ApiId),
+		IntegrationId:  aws.String(integration.IntegrationId),
+		IntegrationUri: aws.String(integration.IntegrationUri),
+		RequestParameters: map[string]string{
+			"overwrite:path":                      "/$request.path.proxy",
+			"overwrite:header.X-Forwarded-Prefix": integration.ForwardedPrefix,
+		},
+	}
+
+	_, err := s.apigateway.Client().UpdateIntegration(ctx, update)
+	return err
+}
+
+func (s *Step) UpdateRoute(ctx context.Context, route Route) error {
+	update := &apigatewayv2.UpdateRouteInput{
+		ApiId:             aws.String(route.ApiId),
+		RouteId:           aws.String(route.RouteId),
+		AuthorizationType: types.AuthorizationType(route.AuthorizationType),
+		AuthorizerId:      aws.String(route.AuthorizerId),
+	}
+
+	_, err := s.apigateway.Client().UpdateRoute(ctx, update)
+	return err
+}
+
 // Internal delete functions (no logging)
 func (s *Step) DeleteRoute(ctx context.Context, route Route) (*apigatewayv2.DeleteRouteOutput, error) {
 	input := &apigatewayv2.DeleteRouteInput{
@@ -360,7 +941,7 @@ func (s *Step) DeletePermissions(ctx context.Context, permission Permission) err
 		StatementId:  aws.String(permission.StatementId),
 	}
 
-	_, err := s.lambda.Client().RemovePermission(ctx, input)
+	_, err := s.lambda.Client().RemovePermission(ctx, input, lambdastep.RetryPolicy)
 	if err != nil {
 		switch errors.As(err, &apiErr) {
 		case apiErr.ErrorCode() == "ResourceNotFoundException":
@@ -390,7 +971,8 @@ func (s *Step) GetApis(ctx context.Context) ([]Api, error) {
 
 		for _, api := range result.Items {
 			apis = append(apis, Api{
-				ApiId: *api.ApiId,
+				ApiId:    *api.ApiId,
+				Endpoint: aws.ToString(api.ApiEndpoint),
 			})
 		}
 
@@ -439,6 +1021,7 @@ func (s *Step) GetRoutes(ctx context.Context, apis []Api) ([]Route, error) {
 							RouteKey:          *route.RouteKey,
 							AuthorizationType: string(route.AuthorizationType),
 							AuthorizerId:      authorizerId,
+							IntegrationId:     integration.IntegrationId,
 						})
 					}
 				}
@@ -486,10 +1069,12 @@ func (s *Step) GetIntegrations(ctx context.Context, apis []Api) ([]Integration,
 			}
 
 			for _, integration := range result.Items {
-				if s.lambda.FunctionArn() == *integration.IntegrationUri {
+				if s.invokes(aws.ToString(integration.IntegrationUri)) {
 					integrations = append(integrations, Integration{
-						ApiId:         api.ApiId,
-						IntegrationId: *integration.IntegrationId,
+						ApiId:           api.ApiId,
+						IntegrationId:   *integration.IntegrationId,
+						IntegrationUri:  aws.ToString(integration.IntegrationUri),
+						ForwardedPrefix: integration.RequestParameters["overwrite:header.X-Forwarded-Prefix"],
 					})
 				}
 			}
@@ -507,9 +1092,25 @@ func (s *Step) GetIntegrations(ctx context.Context, apis []Api) ([]Integration,
 func (s *Step) GetPermissions(ctx context.Context, apis []Api) ([]Permission, error) {
 	var permissions []Permission
 
+	// Permissions are granted to the alias when there is one, but may remain on the
+	// unqualified function from deploys made without it
+	for _, functionArn := range s.lambda.FunctionArns() {
+		found, err := s.getPermissions(ctx, functionArn)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, found...)
+	}
+
+	return permissions, nil
+}
+
+func (s *Step) getPermissions(ctx context.Context, functionArn string) ([]Permission, error) {
+	var permissions []Permission
+
 	// Get Lambda's resource-based policy
 	policy, err := s.lambda.Client().GetPolicy(ctx, &lambda.GetPolicyInput{
-		FunctionName: aws.String(s.lambda.FunctionArn()),
+		FunctionName: aws.String(functionArn),
 	})
 	if err != nil {
 		var resourceNotFound *lambdatypes.ResourceNotFoundException
@@ -526,6 +1127,9 @@ func (s *Step) GetPermissions(ctx context.Context, apis []Api) ([]Permission, er
 			Principal struct {
 				Service string `json:"Service"`
 			} `json:"Principal"`
+			Condition struct {
+				ArnLike map[string]string `json:"ArnLike"`
+			} `json:"Condition"`
 		} `json:"Statement"`
 	}
 
@@ -537,11 +1141,34 @@ func (s *Step) GetPermissions(ctx context.Context, apis []Api) ([]Permission, er
 	for _, stmt := range policyDoc.Statement {
 		if stmt.Principal.Service == "apigateway.amazonaws.com" {
 			permissions = append(permissions, Permission{
-				FunctionArn: s.lambda.FunctionArn(),
+				FunctionArn: functionArn,
 				StatementId: stmt.Sid,
+				SourceArn:   stmt.Condition.ArnLike["AWS:SourceArn"],
 			})
 		}
 	}
 
 	return permissions, nil
 }
+
+// invokes reports whether an integration uri targets the function or any of its aliases and versions
+func (s *Step) invokes(uri string) bool {
+	return uri == s.lambda.FunctionArn() || strings.HasPrefix(uri, s.lambda.FunctionArn()+":")
+}
+
+// Util
+
+// routeUrl joins the api endpoint with the static prefix of a route key such as "ANY /users/{proxy+}"
+func routeUrl(endpoint, routeKey string) string {
+	if endpoint == "" {
+		return ""
+	}
+
+	_, path, found := strings.Cut(routeKey, " ")
+	if !found {
+		return endpoint
+	}
+
+	path = strings.TrimSuffix(path, "{proxy+}")
+	return strings.TrimSuffix(endpoint, "/") + path
+}
@@ -0,0 +1,66 @@
+package apigateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const target = "arn:aws:lambda:us-west-2:123456789012:function:svc"
+
+func TestDiff_Unchanged(t *testing.T) {
+	desired := []Desired{{ApiId: "api", RouteKey: "ANY /svc/{proxy+}", AuthType: "AWS_IAM", Prefix: "/svc"}}
+	routes := []Route{{ApiId: "api", RouteId: "r1", RouteKey: "ANY /svc/{proxy+}", AuthorizationType: "AWS_IAM", IntegrationId: "i1"}}
+	integrations := []Integration{{ApiId: "api", IntegrationId: "i1", IntegrationUri: target, ForwardedPrefix: "/svc"}}
+
+	changes := diff(desired, routes, integrations, target)
+
+	assert.Equal(t, routes, changes.Keep)
+	assert.Empty(t, changes.Create)
+	assert.Empty(t, changes.UpdateRoutes)
+	assert.Empty(t, changes.UpdateIntegrations)
+	assert.Empty(t, changes.DeleteRoutes)
+	assert.Empty(t, changes.DeleteIntegrations)
+}
+
+func TestDiff_Changes(t *testing.T) {
+	desired := []Desired{
+		{ApiId: "api", RouteKey: "ANY /svc/{proxy+}", AuthType: "NONE", Prefix: "/svc"},
+		{ApiId: "api", RouteKey: "GET /svc/admin/{proxy+}", AuthType: "JWT", AuthorizerId: "auth", Prefix: "/svc/admin"},
+	}
+	routes := []Route{
+		{ApiId: "api", RouteId: "r1", RouteKey: "ANY /svc/{proxy+}", AuthorizationType: "AWS_IAM", IntegrationId: "i1"},
+		{ApiId: "api", RouteId: "r2", RouteKey: "ANY /old/{proxy+}", AuthorizationType: "AWS_IAM", IntegrationId: "i2"},
+	}
+	integrations := []Integration{
+		{ApiId: "api", IntegrationId: "i1", IntegrationUri: target, ForwardedPrefix: "/svc"},
+		{ApiId: "api", IntegrationId: "i2", IntegrationUri: target, ForwardedPrefix: "/old"},
+		{ApiId: "api", IntegrationId: "i3", IntegrationUri: target},
+	}
+
+	changes := diff(desired, routes, integrations, target+":live")
+
+	assert.Equal(t, []Desired{desired[1]}, changes.Create)
+	assert.Equal(t, []Route{{ApiId: "api", RouteId: "r1", RouteKey: "ANY /svc/{proxy+}", AuthorizationType: "NONE", IntegrationId: "i1"}}, changes.UpdateRoutes)
+	assert.Equal(t, []Integration{{ApiId: "api", IntegrationId: "i1", IntegrationUri: target + ":live", ForwardedPrefix: "/svc"}}, changes.UpdateIntegrations)
+	assert.Equal(t, []Route{routes[1]}, changes.DeleteRoutes)
+	assert.Equal(t, []Integration{integrations[1], integrations[2]}, changes.DeleteIntegrations)
+	assert.Empty(t, changes.Keep)
+}
+
+func TestDiff_OtherApi(t *testing.T) {
+	desired := []Desired{{ApiId: "new", RouteKey: "ANY /svc/{proxy+}", AuthType: "AWS_IAM", Prefix: "/svc"}}
+	routes := []Route{{ApiId: "old", RouteId: "r1", RouteKey: "ANY /svc/{proxy+}", AuthorizationType: "AWS_IAM", IntegrationId: "i1"}}
+	integrations := []Integration{{ApiId: "old", IntegrationId: "i1", IntegrationUri: target, ForwardedPrefix: "/svc"}}
+
+	changes := diff(desired, routes, integrations, target)
+
+	assert.Equal(t, desired, changes.Create)
+	assert.Equal(t, routes, changes.DeleteRoutes)
+	assert.Equal(t, integrations, changes.DeleteIntegrations)
+}
+
+func TestRouteUrl(t *testing.T) {
+	assert.Equal(t, "https://abc.execute-api.us-west-2.amazonaws.com/svc/", routeUrl("https://abc.execute-api.us-west-2.amazonaws.com/", "ANY /svc/{proxy+}"))
+	assert.Equal(t, "", routeUrl("", "ANY /svc/{proxy+}"))
+}
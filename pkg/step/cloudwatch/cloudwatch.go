@@ -3,9 +3,14 @@ package cloudwatch
 import (
 	"context"
 	"errors"
+	"strconv"
+
+	"github.com/bkeane/monad/pkg/inventory"
+	"github.com/bkeane/monad/pkg/plan"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
 	"github.com/aws/smithy-go"
 	"github.com/rs/zerolog/log"
 )
@@ -19,13 +24,19 @@ type CloudWatchConfig interface {
 }
 
 type LogGroup struct {
-	Name      string
-	Retention int32
+	Name      string `json:"name" yaml:"name"`
+	Retention int32  `json:"retention" yaml:"retention"`
 }
 
 type Summary struct {
-	LogGroupsCreated []LogGroup
-	LogGroupsDeleted []LogGroup
+	LogGroupsCreated []LogGroup `json:"log_groups_created,omitempty" yaml:"log_groups_created,omitempty"`
+	LogGroupsDeleted []LogGroup `json:"log_groups_deleted,omitempty" yaml:"log_groups_deleted,omitempty"`
+}
+
+// Snapshot records the log group as it was before a mount
+type Snapshot struct {
+	Exists    bool
+	Retention *int32
 }
 
 //
@@ -34,6 +45,8 @@ type Summary struct {
 
 type Step struct {
 	cloudwatch CloudWatchConfig
+	snapshot   *Snapshot
+	summary    Summary
 }
 
 //
@@ -48,6 +61,7 @@ func Derive(cloudwatch CloudWatchConfig) *Step {
 
 func (s *Step) Mount(ctx context.Context) error {
 	summary, err := s.mount(ctx)
+	s.summary = summary
 	if err != nil {
 		return err
 	}
@@ -66,6 +80,7 @@ func (s *Step) Mount(ctx context.Context) error {
 
 func (s *Step) Unmount(ctx context.Context) error {
 	summary, err := s.unmount(ctx)
+	s.summary = summary
 	if err != nil {
 		return err
 	}
@@ -81,6 +96,131 @@ func (s *Step) Unmount(ctx context.Context) error {
 	return nil
 }
 
+// Summary returns the work done by the last mount or unmount
+func (s *Step) Summary() any {
+	return s.summary
+}
+
+// Outputs returns the identifiers of the log group
+func (s *Step) Outputs() map[string]string {
+	return map[string]string{
+		"log_group":     s.cloudwatch.Name(),
+		"log_group_arn": s.cloudwatch.Arn(),
+	}
+}
+
+// Snapshot captures the log group so that Restore can return to it
+func (s *Step) Snapshot(ctx context.Context) error {
+	logGroup, err := s.GetLogGroup(ctx)
+	if err != nil {
+		return err
+	}
+
+	if logGroup == nil {
+		s.snapshot = &Snapshot{Exists: false}
+		return nil
+	}
+
+	s.snapshot = &Snapshot{
+		Exists:    true,
+		Retention: logGroup.RetentionInDays,
+	}
+
+	return nil
+}
+
+// Restore returns the log group to its snapshot, deleting it if it did not exist
+func (s *Step) Restore(ctx context.Context) error {
+	if s.snapshot == nil {
+		return nil
+	}
+
+	if !s.snapshot.Exists {
+		return s.Unmount(ctx)
+	}
+
+	if s.snapshot.Retention == nil {
+		_, err := s.cloudwatch.Client().DeleteRetentionPolicy(ctx, &cloudwatchlogs.DeleteRetentionPolicyInput{
+			LogGroupName: aws.String(s.cloudwatch.Name()),
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err := s.cloudwatch.Client().PutRetentionPolicy(ctx, &cloudwatchlogs.PutRetentionPolicyInput{
+			LogGroupName:    aws.String(s.cloudwatch.Name()),
+			RetentionInDays: s.snapshot.Retention,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().
+		Str("action", "restore").
+		Str("group", s.cloudwatch.Name()).
+		Msg("cloudwatch")
+
+	return nil
+}
+
+// Exists reports whether the log group has been created
+func (s *Step) Exists(ctx context.Context) (bool, error) {
+	logGroup, err := s.GetLogGroup(ctx)
+	return logGroup != nil, err
+}
+
+// Plan reports how mounting would change the log group
+func (s *Step) Plan(ctx context.Context) (*plan.Plan, error) {
+	p := plan.New("cloudwatch")
+	name := s.cloudwatch.Name()
+
+	logGroup, err := s.GetLogGroup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if logGroup == nil {
+		p.Create("group", name)
+		p.Field("group", name, "retention", "", strconv.Itoa(int(s.cloudwatch.Retention())))
+		return p, nil
+	}
+
+	retention := "never"
+	if logGroup.RetentionInDays != nil {
+		retention = strconv.Itoa(int(*logGroup.RetentionInDays))
+	}
+
+	p.Field("group", name, "retention", retention, strconv.Itoa(int(s.cloudwatch.Retention())))
+
+	return p, nil
+}
+
+// Describe lists the deployed log group and its retention
+func (s *Step) Describe(ctx context.Context) (*inventory.Inventory, error) {
+	i := inventory.New("cloudwatch")
+
+	logGroup, err := s.GetLogGroup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if logGroup == nil {
+		return i, nil
+	}
+
+	retention := "never"
+	if logGroup.RetentionInDays != nil {
+		retention = strconv.Itoa(int(*logGroup.RetentionInDays)) + " days"
+	}
+
+	i.Add("group", s.cloudwatch.Name()).
+		Set("arn", s.cloudwatch.Arn()).
+		Set("retention", retention)
+
+	return i, nil
+}
+
 // Internal methods that return summaries of work done
 func (s *Step) mount(ctx context.Context) (Summary, error) {
 	var summary Summary
@@ -120,6 +260,27 @@ func (s *Step) unmount(ctx context.Context) (Summary, error) {
 	return summary, nil
 }
 
+func (s *Step) GetLogGroup(ctx context.Context) (*types.LogGroup, error) {
+	paginator := cloudwatchlogs.NewDescribeLogGroupsPaginator(s.cloudwatch.Client(), &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(s.cloudwatch.Name()),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, logGroup := range page.LogGroups {
+			if logGroup.LogGroupName != nil && *logGroup.LogGroupName == s.cloudwatch.Name() {
+				return &logGroup, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
 func (s *Step) PutLogGroup(ctx context.Context) error {
 	var apiErr smithy.APIError
 
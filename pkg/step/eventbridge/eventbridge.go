@@ -3,9 +3,15 @@ package eventbridge
 import (
 	"context"
 	"errors"
+	"maps"
+	"slices"
 	"strings"
 	"unicode"
 
+	"github.com/bkeane/monad/pkg/inventory"
+	"github.com/bkeane/monad/pkg/plan"
+	lambdastep "github.com/bkeane/monad/pkg/step/lambda"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
@@ -26,6 +32,8 @@ type EventBridgeConfig interface {
 type LambdaConfig interface {
 	FunctionName() string
 	FunctionArn() string
+	TargetArn() string
+	FunctionArns() []string
 	Client() *lambda.Client
 	Tags() map[string]string
 }
@@ -37,18 +45,26 @@ type EventBridgeRule struct {
 }
 
 type Rule struct {
-	BusName  string
-	RuleName string
+	BusName  string `json:"bus_name" yaml:"bus_name"`
+	RuleName string `json:"rule_name" yaml:"rule_name"`
 }
 
 type Summary struct {
-	RulesCreated []Rule
-	RulesDeleted []Rule
+	RulesCreated   []Rule `json:"rules_created,omitempty" yaml:"rules_created,omitempty"`
+	RulesUnchanged []Rule `json:"rules_unchanged,omitempty" yaml:"rules_unchanged,omitempty"`
+	RulesDeleted   []Rule `json:"rules_deleted,omitempty" yaml:"rules_deleted,omitempty"`
+}
+
+// Snapshot records the rules targeting the function before a mount
+type Snapshot struct {
+	Rules map[string]map[string]EventBridgeRule
 }
 
 type Step struct {
 	eventbridge EventBridgeConfig
 	lambda      LambdaConfig
+	snapshot    *Snapshot
+	summary     Summary
 }
 
 func Derive(eventbridge EventBridgeConfig, lambda LambdaConfig) *Step {
@@ -66,6 +82,7 @@ func (s *Step) Mount(ctx context.Context) error {
 
 	// Call internal mount and log only the creates as action=put
 	summary, err := s.mount(ctx)
+	s.summary = summary
 	if err != nil {
 		return err
 	}
@@ -82,8 +99,40 @@ func (s *Step) Mount(ctx context.Context) error {
 	return nil
 }
 
+// Reconcile puts only the rules whose document or target differ from the config and prunes
+// those no longer defined. Rules are updated in place, so no events are missed while deploying.
+func (s *Step) Reconcile(ctx context.Context) error {
+	summary, err := s.reconcile(ctx)
+	s.summary = summary
+	if err != nil {
+		return err
+	}
+
+	actions := []struct {
+		action string
+		rules  []Rule
+	}{
+		{"put", summary.RulesCreated},
+		{"keep", summary.RulesUnchanged},
+		{"delete", summary.RulesDeleted},
+	}
+
+	for _, logged := range actions {
+		for _, rule := range logged.rules {
+			log.Info().
+				Str("action", logged.action).
+				Str("bus", rule.BusName).
+				Str("rule", rule.RuleName).
+				Msg("eventbridge")
+		}
+	}
+
+	return nil
+}
+
 func (s *Step) Unmount(ctx context.Context) error {
 	summary, err := s.unmount(ctx)
+	s.summary = summary
 	if err != nil {
 		return err
 	}
@@ -100,6 +149,132 @@ func (s *Step) Unmount(ctx context.Context) error {
 	return nil
 }
 
+// Summary returns the work done by the last mount or unmount
+func (s *Step) Summary() any {
+	return s.summary
+}
+
+// Outputs returns the rules created by the last mount as bus/rule pairs
+func (s *Step) Outputs() map[string]string {
+	var rules []string
+	for _, rule := range slices.Concat(s.summary.RulesCreated, s.summary.RulesUnchanged) {
+		rules = append(rules, rule.BusName+"/"+rule.RuleName)
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return map[string]string{
+		"rules": strings.Join(rules, ","),
+	}
+}
+
+// Snapshot captures the associated rules so that Restore can return to them
+func (s *Step) Snapshot(ctx context.Context) error {
+	rules, err := s.GetAssociatedRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.snapshot = &Snapshot{Rules: rules}
+	return nil
+}
+
+// Restore replaces the associated rules with those of the snapshot
+func (s *Step) Restore(ctx context.Context) error {
+	if s.snapshot == nil {
+		return nil
+	}
+
+	if _, err := s.unmount(ctx); err != nil {
+		return err
+	}
+
+	for bus, rules := range s.snapshot.Rules {
+		for name, rule := range rules {
+			if err := s.PutRule(ctx, rule); err != nil {
+				return err
+			}
+
+			log.Info().
+				Str("action", "restore").
+				Str("bus", bus).
+				Str("rule", name).
+				Msg("eventbridge")
+		}
+	}
+
+	return nil
+}
+
+// Plan reports the rules mounting would add, change or prune
+func (s *Step) Plan(ctx context.Context) (*plan.Plan, error) {
+	p := plan.New("eventbridge")
+
+	definedRules, err := s.GetDefinedRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	associatedRules, err := s.GetAssociatedRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bus := range slices.Sorted(maps.Keys(definedRules)) {
+		for _, name := range slices.Sorted(maps.Keys(definedRules[bus])) {
+			rule := definedRules[bus][name]
+			subject := bus + "/" + name
+			associated, exists := associatedRules[bus][name]
+			if !exists {
+				p.Create("rule", subject)
+				p.Document("rule", subject, "", rule.Document)
+				continue
+			}
+
+			p.Document("rule", subject, associated.Document, rule.Document)
+		}
+	}
+
+	for _, bus := range slices.Sorted(maps.Keys(associatedRules)) {
+		for _, name := range slices.Sorted(maps.Keys(associatedRules[bus])) {
+			if _, exists := definedRules[bus][name]; !exists {
+				p.Delete("rule", bus+"/"+name)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Describe lists the rules targeting the function along with their bus and pattern or schedule
+func (s *Step) Describe(ctx context.Context) (*inventory.Inventory, error) {
+	i := inventory.New("eventbridge")
+
+	associatedRules, err := s.GetAssociatedRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bus := range slices.Sorted(maps.Keys(associatedRules)) {
+		for _, name := range slices.Sorted(maps.Keys(associatedRules[bus])) {
+			rule := associatedRules[bus][name]
+
+			key := "pattern"
+			if !strings.HasPrefix(chomp(rule.Document), "{") {
+				key = "schedule"
+			}
+
+			i.Add("rule", name).
+				Set("bus", bus).
+				Set(key, plan.Normalize(rule.Document))
+		}
+	}
+
+	return i, nil
+}
+
 // Internal methods that return summaries of work done
 func (s *Step) mount(ctx context.Context) (Summary, error) {
 	var summary Summary
@@ -157,6 +332,60 @@ func (s *Step) unmount(ctx context.Context) (Summary, error) {
 	return summary, nil
 }
 
+func (s *Step) reconcile(ctx context.Context) (Summary, error) {
+	var summary Summary
+
+	definedRules, err := s.GetDefinedRules(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	associatedRules, err := s.GetAssociatedRules(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, bus := range slices.Sorted(maps.Keys(definedRules)) {
+		for _, name := range slices.Sorted(maps.Keys(definedRules[bus])) {
+			rule := definedRules[bus][name]
+
+			associated, exists := associatedRules[bus][name]
+			if exists && plan.Normalize(associated.Document) == plan.Normalize(rule.Document) {
+				targeted, err := s.Targeted(ctx, rule)
+				if err != nil {
+					return summary, err
+				}
+
+				if targeted {
+					summary.RulesUnchanged = append(summary.RulesUnchanged, Rule{BusName: bus, RuleName: name})
+					continue
+				}
+			}
+
+			// PutRule and PutTargets replace an existing rule and target in place
+			if err := s.PutRule(ctx, rule); err != nil {
+				return summary, err
+			}
+			summary.RulesCreated = append(summary.RulesCreated, Rule{BusName: bus, RuleName: name})
+		}
+	}
+
+	for _, bus := range slices.Sorted(maps.Keys(associatedRules)) {
+		for _, name := range slices.Sorted(maps.Keys(associatedRules[bus])) {
+			if _, defined := definedRules[bus][name]; defined {
+				continue
+			}
+
+			if err := s.DeleteRule(ctx, associatedRules[bus][name]); err != nil {
+				return summary, err
+			}
+			summary.RulesDeleted = append(summary.RulesDeleted, Rule{BusName: bus, RuleName: name})
+		}
+	}
+
+	return summary, nil
+}
+
 func (s *Step) prune(ctx context.Context) error {
 	undefinedRules, err := s.GetUndefinedRules(ctx)
 	if err != nil {
@@ -177,8 +406,6 @@ func (s *Step) prune(ctx context.Context) error {
 
 // PUT Operations
 func (s *Step) PutRule(ctx context.Context, rule EventBridgeRule) error {
-	var apiErr smithy.APIError
-
 	putRuleInput := eventbridge.PutRuleInput{
 		EventBusName: aws.String(rule.BusName),
 		Name:         aws.String(rule.RuleName),
@@ -198,12 +425,12 @@ func (s *Step) PutRule(ctx context.Context, rule EventBridgeRule) error {
 	}
 
 	putTargetsInput := eventbridge.PutTargetsInput{
-		EventBusName: aws.String(s.eventbridge.BusName()),
-		Rule:         aws.String(s.eventbridge.RuleName()),
+		EventBusName: aws.String(rule.BusName),
+		Rule:         aws.String(rule.RuleName),
 		Targets: []eventbridgetypes.Target{
 			{
 				Id:  aws.String(s.lambda.FunctionName()),
-				Arn: aws.String(s.lambda.FunctionArn()),
+				Arn: aws.String(s.lambda.TargetArn()),
 			},
 		},
 	}
@@ -219,21 +446,18 @@ func (s *Step) PutRule(ctx context.Context, rule EventBridgeRule) error {
 	}
 
 	addPermissionsInput := lambda.AddPermissionInput{
-		FunctionName: aws.String(s.lambda.FunctionName()),
+		FunctionName: aws.String(s.lambda.TargetArn()),
 		StatementId:  aws.String(s.eventbridge.PermissionStatementId()),
 		Action:       aws.String("lambda:InvokeFunction"),
 		Principal:    aws.String("events.amazonaws.com"),
 		SourceArn:    aws.String(*putRuleOutput.RuleArn),
 	}
 
-	if _, err := s.lambda.Client().AddPermission(ctx, &addPermissionsInput); err != nil {
-		if errors.As(err, &apiErr) {
-			switch apiErr.ErrorCode() {
-			case "ResourceConflictException":
-				break
-			default:
-				return err
-			}
+	// conflicts with concurrent edits of the function policy are retried, and only a
+	// statement which already exists is left as it is
+	if _, err := s.lambda.Client().AddPermission(ctx, &addPermissionsInput, lambdastep.RetryPolicy); err != nil {
+		if !lambdastep.StatementExists(err) {
+			return err
 		}
 	}
 
@@ -253,11 +477,6 @@ func (s *Step) PutRule(ctx context.Context, rule EventBridgeRule) error {
 func (s *Step) DeleteRule(ctx context.Context, rule EventBridgeRule) error {
 	var apiErr smithy.APIError
 
-	deletePermissionInput := lambda.RemovePermissionInput{
-		FunctionName: aws.String(s.lambda.FunctionName()),
-		StatementId:  aws.String(s.eventbridge.PermissionStatementId()),
-	}
-
 	deleteTargetsInput := eventbridge.RemoveTargetsInput{
 		EventBusName: aws.String(rule.BusName),
 		Rule:         aws.String(rule.RuleName),
@@ -269,13 +488,22 @@ func (s *Step) DeleteRule(ctx context.Context, rule EventBridgeRule) error {
 		Name:         aws.String(rule.RuleName),
 	}
 
-	if _, err := s.lambda.Client().RemovePermission(ctx, &deletePermissionInput); err != nil {
-		if errors.As(err, &apiErr) {
-			switch apiErr.ErrorCode() {
-			case "ResourceNotFoundException":
-				break
-			default:
-				return err
+	// the permission is granted to the alias when there is one, but may remain on the
+	// unqualified function from deploys made without it
+	for _, functionArn := range s.lambda.FunctionArns() {
+		deletePermissionInput := lambda.RemovePermissionInput{
+			FunctionName: aws.String(functionArn),
+			StatementId:  aws.String(s.eventbridge.PermissionStatementId()),
+		}
+
+		if _, err := s.lambda.Client().RemovePermission(ctx, &deletePermissionInput, lambdastep.RetryPolicy); err != nil {
+			if errors.As(err, &apiErr) {
+				switch apiErr.ErrorCode() {
+				case "ResourceNotFoundException":
+					break
+				default:
+					return err
+				}
 			}
 		}
 	}
@@ -299,6 +527,26 @@ func (s *Step) DeleteRule(ctx context.Context, rule EventBridgeRule) error {
 }
 
 // GET Operations
+
+// Targeted reports whether the rule targets the function through the configured arn
+func (s *Step) Targeted(ctx context.Context, rule EventBridgeRule) (bool, error) {
+	output, err := s.eventbridge.Client().ListTargetsByRule(ctx, &eventbridge.ListTargetsByRuleInput{
+		EventBusName: aws.String(rule.BusName),
+		Rule:         aws.String(rule.RuleName),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, target := range output.Targets {
+		if aws.ToString(target.Id) == s.lambda.FunctionName() && aws.ToString(target.Arn) == s.lambda.TargetArn() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (s *Step) GetDefinedRules(ctx context.Context) (map[string]map[string]EventBridgeRule, error) {
 	// This code _can_ handle many defined rules, but monad currently will only support one until more are necessary.
 	busName := s.eventbridge.BusName()
@@ -362,17 +610,22 @@ func (s *Step) GetAssociatedRules(ctx context.Context) (map[string]map[string]Ev
 	for _, bus := range buses.EventBuses {
 		associatedRules[*bus.Name] = make(map[string]EventBridgeRule)
 
-		listRuleNames := &eventbridge.ListRuleNamesByTargetInput{
-			TargetArn:    aws.String(s.lambda.FunctionArn()),
-			EventBusName: bus.Name,
-		}
+		var ruleNames []string
+		for _, functionArn := range s.lambda.FunctionArns() {
+			listRuleNames := &eventbridge.ListRuleNamesByTargetInput{
+				TargetArn:    aws.String(functionArn),
+				EventBusName: bus.Name,
+			}
 
-		target, err := s.eventbridge.Client().ListRuleNamesByTarget(ctx, listRuleNames)
-		if err != nil {
-			return nil, err
+			target, err := s.eventbridge.Client().ListRuleNamesByTarget(ctx, listRuleNames)
+			if err != nil {
+				return nil, err
+			}
+
+			ruleNames = append(ruleNames, target.RuleNames...)
 		}
 
-		for _, associated := range target.RuleNames {
+		for _, associated := range ruleNames {
 			listRules := &eventbridge.ListRulesInput{
 				EventBusName: bus.Name,
 				NamePrefix:   &associated,
@@ -407,6 +660,7 @@ func (s *Step) GetAssociatedRules(ctx context.Context) (map[string]map[string]Ev
 }
 
 // Utility
+
 func chomp(s string) string {
 	s = strings.TrimLeftFunc(s, unicode.IsSpace)
 	s = strings.TrimRightFunc(s, unicode.IsSpace)
@@ -0,0 +1,373 @@
+package health
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/rs/zerolog/log"
+)
+
+type HealthConfig interface {
+	Enabled() bool
+	Path() string
+	Payload() string
+	Status() int32
+	Body() string
+	Timeout() int32
+	AwsConfig() aws.Config
+}
+
+type ApiGatewayConfig interface {
+	Client() *apigatewayv2.Client
+	ApiId() string
+	Route() []string
+	AuthType() []string
+}
+
+type LambdaConfig interface {
+	Client() *lambda.Client
+	FunctionName() string
+	FunctionArn() string
+	TargetArn() string
+}
+
+// Reverter returns a function to the state it was in before the deploy,
+// reporting false when there was no prior deploy to return to.
+type Reverter interface {
+	Revert(ctx context.Context) (bool, error)
+}
+
+type Check struct {
+	Target string `json:"target" yaml:"target"`
+	Status int32  `json:"status,omitempty" yaml:"status,omitempty"`
+	Passed bool   `json:"passed" yaml:"passed"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type Summary struct {
+	Checks   []Check `json:"checks,omitempty" yaml:"checks,omitempty"`
+	Reverted bool    `json:"reverted,omitempty" yaml:"reverted,omitempty"`
+}
+
+// target is a single request of a check, returning the response status and body
+type target struct {
+	name string
+	call func(ctx context.Context) (int32, string, error)
+}
+
+type Step struct {
+	health     HealthConfig
+	apigateway ApiGatewayConfig
+	lambda     LambdaConfig
+	reverter   Reverter
+	client     *http.Client
+	interval   time.Duration
+	summary    Summary
+}
+
+//
+// Derive
+//
+
+func Derive(health HealthConfig, apigateway ApiGatewayConfig, lambda LambdaConfig) *Step {
+	return &Step{
+		health:     health,
+		apigateway: apigateway,
+		lambda:     lambda,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		interval:   2 * time.Second,
+	}
+}
+
+// RevertWith sets the function reverted when a check fails
+func (s *Step) RevertWith(reverter Reverter) {
+	s.reverter = reverter
+}
+
+// Enabled reports whether the step checks deploys
+func (s *Step) Enabled() bool {
+	return s.health.Enabled()
+}
+
+// Mount checks the deployed service, reverting the function when the check does not pass in time
+func (s *Step) Mount(ctx context.Context) error {
+	s.summary = Summary{}
+
+	if !s.health.Enabled() {
+		return nil
+	}
+
+	targets, err := s.targets(ctx, s.lambda.TargetArn())
+	if err != nil {
+		return err
+	}
+
+	return s.run(ctx, targets)
+}
+
+// Probe checks a version serving a canary share of the alias traffic. A payload is
+// invoked on the version itself, while routes reach it only in proportion to its share.
+func (s *Step) Probe(ctx context.Context, version string) error {
+	if !s.health.Enabled() {
+		return nil
+	}
+
+	targets, err := s.targets(ctx, s.lambda.FunctionArn()+":"+version)
+	if err != nil {
+		return err
+	}
+
+	return s.check(ctx, targets)
+}
+
+// run checks targets, reverting the function when they do not pass
+func (s *Step) run(ctx context.Context, targets []target) error {
+	err := s.check(ctx, targets)
+	if err == nil {
+		return nil
+	}
+
+	if s.reverter == nil {
+		return err
+	}
+
+	reverted, revertErr := s.reverter.Revert(ctx)
+	if revertErr != nil {
+		return errors.Join(err, fmt.Errorf("revert failed: %w", revertErr))
+	}
+
+	s.summary.Reverted = reverted
+	return err
+}
+
+// Unmount has nothing to remove
+func (s *Step) Unmount(ctx context.Context) error {
+	s.summary = Summary{}
+	return nil
+}
+
+// Summary returns the checks made by the last mount
+func (s *Step) Summary() any {
+	return s.summary
+}
+
+// Outputs returns nothing, as the step manages no resources
+func (s *Step) Outputs() map[string]string {
+	return nil
+}
+
+//
+// Checks
+//
+
+// check runs every target until all of them pass at once or the timeout elapses
+func (s *Step) check(ctx context.Context, targets []target) error {
+	deadline := time.Now().Add(time.Duration(s.health.Timeout()) * time.Second)
+
+	for {
+		var failed error
+		s.summary.Checks = nil
+
+		for _, target := range targets {
+			check := s.verify(ctx, target)
+			s.summary.Checks = append(s.summary.Checks, check)
+
+			if !check.Passed && failed == nil {
+				failed = fmt.Errorf("health check of %s failed: %s", check.Target, check.Error)
+			}
+		}
+
+		if failed == nil {
+			for _, check := range s.summary.Checks {
+				log.Info().
+					Str("action", "check").
+					Str("target", check.Target).
+					Int32("status", check.Status).
+					Msg("health")
+			}
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			log.Error().Err(failed).Msg("health")
+			return failed
+		}
+
+		log.Info().Err(failed).Msg("health check retrying")
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(failed, ctx.Err())
+		case <-time.After(min(s.interval, time.Until(deadline)+time.Millisecond)):
+		}
+	}
+}
+
+// verify calls target and compares its response to the expected status and body
+func (s *Step) verify(ctx context.Context, target target) Check {
+	check := Check{Target: target.name}
+
+	status, body, err := target.call(ctx)
+	check.Status = status
+
+	switch {
+	case err != nil:
+		check.Error = err.Error()
+	case status != s.health.Status():
+		check.Error = fmt.Sprintf("status %d, expected %d", status, s.health.Status())
+	case !strings.Contains(body, s.health.Body()):
+		check.Error = fmt.Sprintf("body does not contain %q", s.health.Body())
+	default:
+		check.Passed = true
+	}
+
+	return check
+}
+
+// targets returns an invocation of function when a payload is configured, and otherwise a request of every route
+// monad can authorize
+func (s *Step) targets(ctx context.Context, function string) ([]target, error) {
+	if s.health.Payload() != "" {
+		return []target{{
+			name: "lambda:" + s.lambda.FunctionName() + strings.TrimPrefix(function, s.lambda.FunctionArn()),
+			call: func(ctx context.Context) (int32, string, error) {
+				return s.invoke(ctx, function)
+			},
+		}}, nil
+	}
+
+	if s.apigateway.ApiId() == "" {
+		return nil, fmt.Errorf("health check requires an api to request or a payload to invoke the function with")
+	}
+
+	api, err := s.apigateway.Client().GetApi(ctx, &apigatewayv2.GetApiInput{
+		ApiId: aws.String(s.apigateway.ApiId()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []target
+	for i, route := range s.apigateway.Route() {
+		method, path, _ := strings.Cut(route, " ")
+		if method == "ANY" {
+			method = http.MethodGet
+		}
+
+		url := strings.TrimSuffix(aws.ToString(api.ApiEndpoint), "/") +
+			strings.TrimSuffix(path, "/{proxy+}") + s.health.Path()
+
+		auth := "NONE"
+		if i < len(s.apigateway.AuthType()) {
+			auth = s.apigateway.AuthType()[i]
+		}
+
+		// monad holds no token for jwt and custom authorizers, so such routes would always be refused
+		if auth != "NONE" && auth != "AWS_IAM" {
+			log.Warn().
+				Str("route", route).
+				Str("auth", auth).
+				Msg("health check skips route, as only routes without authorization or with iam authorization can be requested")
+			continue
+		}
+
+		targets = append(targets, target{
+			name: method + " " + url,
+			call: func(ctx context.Context) (int32, string, error) {
+				return s.request(ctx, method, url, auth == "AWS_IAM")
+			},
+		})
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("health check requires a route without a jwt or custom authorizer, or a payload to invoke the function with")
+	}
+
+	return targets, nil
+}
+
+// request makes an http request, signing it for execute-api when the route uses iam authorization
+func (s *Step) request(ctx context.Context, method, url string, signed bool) (int32, string, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if signed {
+		config := s.health.AwsConfig()
+
+		credentials, err := config.Credentials.Retrieve(ctx)
+		if err != nil {
+			return 0, "", err
+		}
+
+		empty := sha256.Sum256(nil)
+		err = v4.NewSigner().SignHTTP(ctx, credentials, request, hex.EncodeToString(empty[:]), "execute-api", config.Region, time.Now())
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return 0, "", err
+	}
+
+	return int32(response.StatusCode), string(body), nil
+}
+
+// invoke calls function with the configured payload. Responses shaped like
+// an api gateway proxy response are checked by their statusCode and body.
+func (s *Step) invoke(ctx context.Context, function string) (int32, string, error) {
+	output, err := s.lambda.Client().Invoke(ctx, &lambda.InvokeInput{
+		FunctionName: aws.String(function),
+		Payload:      []byte(s.health.Payload()),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	if output.FunctionError != nil {
+		return output.StatusCode, string(output.Payload), fmt.Errorf("%s: %s", aws.ToString(output.FunctionError), output.Payload)
+	}
+
+	status, body := proxyResponse(output.StatusCode, output.Payload)
+	return status, body, nil
+}
+
+//
+// Helpers
+//
+
+// proxyResponse returns the statusCode and body of an api gateway proxy response,
+// or the given status and the raw payload when it is not one
+func proxyResponse(status int32, payload []byte) (int32, string) {
+	var response struct {
+		StatusCode *int32  `json:"statusCode"`
+		Body       *string `json:"body"`
+	}
+
+	if err := json.Unmarshal(payload, &response); err != nil || response.StatusCode == nil {
+		return status, string(payload)
+	}
+
+	return *response.StatusCode, aws.ToString(response.Body)
+}
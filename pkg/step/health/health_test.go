@@ -0,0 +1,189 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHealth struct {
+	status  int32
+	body    string
+	payload string
+	timeout int32
+}
+
+func (f fakeHealth) Enabled() bool         { return true }
+func (f fakeHealth) Path() string          { return "/" }
+func (f fakeHealth) Payload() string       { return f.payload }
+func (f fakeHealth) Status() int32         { return f.status }
+func (f fakeHealth) Body() string          { return f.body }
+func (f fakeHealth) Timeout() int32        { return f.timeout }
+func (f fakeHealth) AwsConfig() aws.Config { return aws.Config{} }
+
+type fakeLambda struct{}
+
+func (f fakeLambda) Client() *lambda.Client { return nil }
+func (f fakeLambda) FunctionName() string   { return "svc" }
+func (f fakeLambda) FunctionArn() string    { return "arn:aws:lambda:us-east-1:123456789012:function:svc" }
+func (f fakeLambda) TargetArn() string      { return f.FunctionArn() + ":live" }
+
+type fakeApiGateway struct {
+	client   *apigatewayv2.Client
+	routes   []string
+	authType []string
+}
+
+func (f fakeApiGateway) Client() *apigatewayv2.Client { return f.client }
+func (f fakeApiGateway) ApiId() string                { return "abc123" }
+func (f fakeApiGateway) Route() []string              { return f.routes }
+func (f fakeApiGateway) AuthType() []string           { return f.authType }
+
+type fakeReverter struct {
+	reverted bool
+}
+
+func (f *fakeReverter) Revert(ctx context.Context) (bool, error) {
+	f.reverted = true
+	return true, nil
+}
+
+func step(health HealthConfig) *Step {
+	s := Derive(health, nil, nil)
+	s.interval = 10 * time.Millisecond
+	return s
+}
+
+func constant(status int32, body string, err error) target {
+	return target{name: "fake", call: func(ctx context.Context) (int32, string, error) {
+		return status, body, err
+	}}
+}
+
+func TestStep_CheckPasses(t *testing.T) {
+	s := step(fakeHealth{status: 200, body: "ok"})
+
+	require.NoError(t, s.check(context.Background(), []target{constant(200, `{"status":"ok"}`, nil)}))
+	require.Len(t, s.summary.Checks, 1)
+	assert.True(t, s.summary.Checks[0].Passed)
+}
+
+func TestStep_CheckRetries(t *testing.T) {
+	s := step(fakeHealth{status: 200, timeout: 1})
+
+	calls := 0
+	flaky := target{name: "flaky", call: func(ctx context.Context) (int32, string, error) {
+		calls++
+		if calls < 3 {
+			return 502, "", nil
+		}
+		return 200, "", nil
+	}}
+
+	require.NoError(t, s.check(context.Background(), []target{flaky}))
+	assert.Equal(t, 3, calls)
+}
+
+func TestStep_CheckFails(t *testing.T) {
+	s := step(fakeHealth{status: 200, body: "ok"})
+
+	err := s.check(context.Background(), []target{constant(200, "degraded", nil)})
+	assert.ErrorContains(t, err, `body does not contain "ok"`)
+
+	err = s.check(context.Background(), []target{constant(500, "ok", nil)})
+	assert.ErrorContains(t, err, "status 500, expected 200")
+
+	err = s.check(context.Background(), []target{constant(0, "", errors.New("connection refused"))})
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestStep_RunReverts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	s := step(fakeHealth{status: 200})
+	reverter := &fakeReverter{}
+
+	request := target{name: server.URL, call: func(ctx context.Context) (int32, string, error) {
+		return s.request(ctx, http.MethodGet, server.URL, false)
+	}}
+
+	// without a reverter the failure is only reported
+	assert.ErrorContains(t, s.run(context.Background(), []target{request}), "status 500")
+	assert.False(t, s.summary.Reverted)
+
+	s.RevertWith(reverter)
+	assert.ErrorContains(t, s.run(context.Background(), []target{request}), "status 500")
+	assert.True(t, reverter.reverted)
+	assert.True(t, s.summary.Reverted)
+
+	reverter.reverted = false
+	assert.NoError(t, s.run(context.Background(), []target{constant(200, "", nil)}))
+	assert.False(t, reverter.reverted)
+}
+
+func TestStep_Targets(t *testing.T) {
+	s := Derive(fakeHealth{payload: "{}"}, nil, fakeLambda{})
+
+	// deploys invoke the alias, while canaries invoke the version being shifted to
+	targets, err := s.targets(context.Background(), fakeLambda{}.TargetArn())
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "lambda:svc:live", targets[0].name)
+
+	targets, err = s.targets(context.Background(), fakeLambda{}.FunctionArn()+":3")
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "lambda:svc:3", targets[0].name)
+}
+
+func TestStep_TargetsSkipAuthorizers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"apiEndpoint":"https://abc123.execute-api.us-east-1.amazonaws.com"}`))
+	}))
+	defer server.Close()
+
+	client := apigatewayv2.New(apigatewayv2.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	apigateway := fakeApiGateway{
+		client:   client,
+		routes:   []string{"GET /health", "POST /orders", "ANY /users/{proxy+}"},
+		authType: []string{"NONE", "AWS_IAM", "JWT"},
+	}
+
+	targets, err := Derive(fakeHealth{}, apigateway, fakeLambda{}).targets(context.Background(), fakeLambda{}.TargetArn())
+	require.NoError(t, err)
+	require.Len(t, targets, 2, "the jwt route would always be refused")
+	assert.Equal(t, "GET https://abc123.execute-api.us-east-1.amazonaws.com/health/", targets[0].name)
+	assert.Equal(t, "POST https://abc123.execute-api.us-east-1.amazonaws.com/orders/", targets[1].name)
+
+	apigateway.routes = []string{"ANY /users/{proxy+}"}
+	apigateway.authType = []string{"CUSTOM"}
+	_, err = Derive(fakeHealth{}, apigateway, fakeLambda{}).targets(context.Background(), fakeLambda{}.TargetArn())
+	assert.ErrorContains(t, err, "requires a route without a jwt or custom authorizer")
+}
+
+func TestProxyResponse(t *testing.T) {
+	status, body := proxyResponse(200, []byte(`{"statusCode":503,"body":"down"}`))
+	assert.Equal(t, int32(503), status)
+	assert.Equal(t, "down", body)
+
+	status, body = proxyResponse(200, []byte(`"pong"`))
+	assert.Equal(t, int32(200), status)
+	assert.Equal(t, `"pong"`, body)
+}
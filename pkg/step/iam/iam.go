@@ -1,8 +1,16 @@
 package iam
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"net/url"
+
+	"github.com/bkeane/monad/pkg/inventory"
+	"github.com/bkeane/monad/pkg/plan"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
@@ -27,25 +35,37 @@ type IamConfig interface {
 }
 
 type Resource struct {
-	Type string // "role" or "policy"
-	Name string
+	Type string `json:"type" yaml:"type"` // "role" or "policy"
+	Name string `json:"name" yaml:"name"`
 }
 
 type Attachment struct {
-	Role     string
-	Policy   string
-	Boundary string
+	Role     string `json:"role" yaml:"role"`
+	Policy   string `json:"policy" yaml:"policy"`
+	Boundary string `json:"boundary,omitempty" yaml:"boundary,omitempty"`
 }
 
 type Summary struct {
-	ResourcesCreated  []Resource
-	ResourcesDeleted  []Resource
-	AttachmentsCreated []Attachment
-	AttachmentsDeleted []Attachment
+	ResourcesCreated   []Resource   `json:"resources_created,omitempty" yaml:"resources_created,omitempty"`
+	ResourcesUnchanged []Resource   `json:"resources_unchanged,omitempty" yaml:"resources_unchanged,omitempty"`
+	ResourcesDeleted   []Resource   `json:"resources_deleted,omitempty" yaml:"resources_deleted,omitempty"`
+	AttachmentsCreated []Attachment `json:"attachments_created,omitempty" yaml:"attachments_created,omitempty"`
+	AttachmentsDeleted []Attachment `json:"attachments_deleted,omitempty" yaml:"attachments_deleted,omitempty"`
+}
+
+// Snapshot records the role and policy as they were before a mount
+type Snapshot struct {
+	RoleExists     bool
+	RoleDocument   string
+	Boundary       string
+	PolicyExists   bool
+	PolicyDocument string
 }
 
 type Step struct {
-	iam IamConfig
+	iam      IamConfig
+	snapshot *Snapshot
+	summary  Summary
 }
 
 func Derive(iam IamConfig) *Step {
@@ -56,6 +76,7 @@ func Derive(iam IamConfig) *Step {
 
 func (c *Step) Mount(ctx context.Context) error {
 	summary, err := c.mount(ctx)
+	c.summary = summary
 	if err != nil {
 		return err
 	}
@@ -81,8 +102,42 @@ func (c *Step) Mount(ctx context.Context) error {
 	return nil
 }
 
+// Reconcile mounts the role and policy only when their documents, boundary or attachment
+// differ from the config. Unchanged resources are left in place apart from their tags.
+func (c *Step) Reconcile(ctx context.Context) error {
+	p, err := c.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	attached, err := c.Attached(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !p.Empty() || !attached {
+		return c.Mount(ctx)
+	}
+
+	summary, err := c.keep(ctx)
+	c.summary = summary
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range summary.ResourcesUnchanged {
+		log.Info().
+			Str("action", "keep").
+			Str(resource.Type, resource.Name).
+			Msg("iam")
+	}
+
+	return nil
+}
+
 func (c *Step) Unmount(ctx context.Context) error {
 	summary, err := c.unmount(ctx)
+	c.summary = summary
 	if err != nil {
 		return err
 	}
@@ -108,6 +163,163 @@ func (c *Step) Unmount(ctx context.Context) error {
 	return nil
 }
 
+// Summary returns the work done by the last mount or unmount
+func (c *Step) Summary() any {
+	return c.summary
+}
+
+// Outputs returns the identifiers of the function role along with a digest of its policy
+func (c *Step) Outputs() map[string]string {
+	return map[string]string{
+		"role_name":     c.iam.RoleName(),
+		"role_arn":      c.iam.RoleArn(),
+		"policy_digest": digest(c.iam.PolicyDocument()),
+	}
+}
+
+// Snapshot captures the role and policy so that Restore can return to them
+func (c *Step) Snapshot(ctx context.Context) error {
+	var snapshot Snapshot
+
+	role, err := c.GetRole(ctx)
+	if err != nil {
+		return err
+	}
+
+	if role != nil {
+		snapshot.RoleExists = true
+		snapshot.RoleDocument, err = decode(role.AssumeRolePolicyDocument)
+		if err != nil {
+			return err
+		}
+
+		if role.PermissionsBoundary != nil && role.PermissionsBoundary.PermissionsBoundaryArn != nil {
+			snapshot.Boundary = *role.PermissionsBoundary.PermissionsBoundaryArn
+		}
+	}
+
+	document, err := c.GetPolicyDocument(ctx)
+	if err != nil {
+		return err
+	}
+
+	if document != "" {
+		snapshot.PolicyExists = true
+		snapshot.PolicyDocument = document
+	}
+
+	c.snapshot = &snapshot
+	return nil
+}
+
+// Restore returns the role and policy to their snapshot, deleting what did not exist
+func (c *Step) Restore(ctx context.Context) error {
+	if c.snapshot == nil {
+		return nil
+	}
+
+	if !c.snapshot.RoleExists && !c.snapshot.PolicyExists {
+		return c.Unmount(ctx)
+	}
+
+	if err := c.RestoreRole(ctx, c.snapshot); err != nil {
+		return err
+	}
+
+	if err := c.RestorePolicy(ctx, c.snapshot); err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("action", "restore").
+		Str("role", c.iam.RoleName()).
+		Str("policy", c.iam.PolicyName()).
+		Msg("iam")
+
+	return nil
+}
+
+// Exists reports whether the role has been created
+func (c *Step) Exists(ctx context.Context) (bool, error) {
+	role, err := c.GetRole(ctx)
+	return role != nil, err
+}
+
+// Plan reports how mounting would change the role and policy
+func (c *Step) Plan(ctx context.Context) (*plan.Plan, error) {
+	p := plan.New("iam")
+
+	role, err := c.GetRole(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		p.Create("role", c.iam.RoleName())
+		p.Document("role", c.iam.RoleName(), "", c.iam.RoleDocument())
+	} else {
+		document, err := decode(role.AssumeRolePolicyDocument)
+		if err != nil {
+			return nil, err
+		}
+
+		boundary := ""
+		if role.PermissionsBoundary != nil {
+			boundary = aws.ToString(role.PermissionsBoundary.PermissionsBoundaryArn)
+		}
+
+		p.Document("role", c.iam.RoleName(), document, c.iam.RoleDocument())
+		p.Field("role", c.iam.RoleName(), "boundary", boundary, c.iam.BoundaryPolicyArn())
+	}
+
+	document, err := c.GetPolicyDocument(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if document == "" {
+		p.Create("policy", c.iam.PolicyName())
+	}
+
+	p.Document("policy", c.iam.PolicyName(), document, c.iam.PolicyDocument())
+
+	return p, nil
+}
+
+// Describe lists the deployed role along with its boundary and policy
+func (c *Step) Describe(ctx context.Context) (*inventory.Inventory, error) {
+	i := inventory.New("iam")
+
+	role, err := c.GetRole(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if role != nil {
+		boundary := ""
+		if role.PermissionsBoundary != nil {
+			boundary = aws.ToString(role.PermissionsBoundary.PermissionsBoundaryArn)
+		}
+
+		i.Add("role", c.iam.RoleName()).
+			Set("arn", aws.ToString(role.Arn)).
+			Set("boundary", boundary)
+	}
+
+	document, err := c.GetPolicyDocument(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if document != "" {
+		i.Add("policy", c.iam.PolicyName()).
+			Set("arn", c.iam.PolicyArn()).
+			Set("document", plan.Normalize(document))
+	}
+
+	return i, nil
+}
+
 // Internal methods that return summaries of work done
 func (c *Step) mount(ctx context.Context) (Summary, error) {
 	var summary Summary
@@ -148,6 +360,37 @@ func (c *Step) mount(ctx context.Context) (Summary, error) {
 	return summary, nil
 }
 
+// keep tags the unchanged role and policy, as tags such as the deployed sha change with every commit
+func (c *Step) keep(ctx context.Context) (Summary, error) {
+	var summary Summary
+
+	_, err := c.iam.Client().TagPolicy(ctx, &iam.TagPolicyInput{
+		PolicyArn: aws.String(c.iam.PolicyArn()),
+		Tags:      c.iam.Tags(),
+	})
+	if err != nil {
+		return summary, err
+	}
+	summary.ResourcesUnchanged = append(summary.ResourcesUnchanged, Resource{
+		Type: "policy",
+		Name: c.iam.PolicyName(),
+	})
+
+	_, err = c.iam.Client().TagRole(ctx, &iam.TagRoleInput{
+		RoleName: aws.String(c.iam.RoleName()),
+		Tags:     c.iam.Tags(),
+	})
+	if err != nil {
+		return summary, err
+	}
+	summary.ResourcesUnchanged = append(summary.ResourcesUnchanged, Resource{
+		Type: "role",
+		Name: c.iam.RoleName(),
+	})
+
+	return summary, nil
+}
+
 func (c *Step) unmount(ctx context.Context) (Summary, error) {
 	var summary Summary
 
@@ -355,6 +598,78 @@ func (c *Step) AttachRolePolicy(ctx context.Context) error {
 	return nil
 }
 
+// RESTORE OPERATIONS
+
+func (c *Step) RestoreRole(ctx context.Context, snapshot *Snapshot) error {
+	var apiErr smithy.APIError
+
+	if !snapshot.RoleExists {
+		if err := c.DetachRolePolicy(ctx); err != nil {
+			return err
+		}
+
+		return c.DeleteRole(ctx)
+	}
+
+	update := &iam.UpdateAssumeRolePolicyInput{
+		RoleName:       aws.String(c.iam.RoleName()),
+		PolicyDocument: aws.String(snapshot.RoleDocument),
+	}
+
+	if _, err := c.iam.Client().UpdateAssumeRolePolicy(ctx, update); err != nil {
+		return err
+	}
+
+	if snapshot.Boundary != "" {
+		boundary := &iam.PutRolePermissionsBoundaryInput{
+			RoleName:            aws.String(c.iam.RoleName()),
+			PermissionsBoundary: aws.String(snapshot.Boundary),
+		}
+
+		_, err := c.iam.Client().PutRolePermissionsBoundary(ctx, boundary)
+		return err
+	}
+
+	boundary := &iam.DeleteRolePermissionsBoundaryInput{
+		RoleName: aws.String(c.iam.RoleName()),
+	}
+
+	_, err := c.iam.Client().DeleteRolePermissionsBoundary(ctx, boundary)
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchEntity":
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return err
+}
+
+func (c *Step) RestorePolicy(ctx context.Context, snapshot *Snapshot) error {
+	if !snapshot.PolicyExists {
+		if err := c.DetachRolePolicy(ctx); err != nil {
+			return err
+		}
+
+		return c.DeletePolicy(ctx)
+	}
+
+	if err := c.GCPolicyVersions(ctx, c.iam.PolicyArn()); err != nil {
+		return err
+	}
+
+	update := &iam.CreatePolicyVersionInput{
+		PolicyArn:      aws.String(c.iam.PolicyArn()),
+		PolicyDocument: aws.String(snapshot.PolicyDocument),
+		SetAsDefault:   true,
+	}
+
+	_, err := c.iam.Client().CreatePolicyVersion(ctx, update)
+	return err
+}
+
 // DELETE OPERATIONS
 
 func (c *Step) DetachRolePolicy(ctx context.Context) error {
@@ -438,8 +753,123 @@ func (c *Step) DeletePolicy(ctx context.Context) error {
 	return nil
 }
 
+// GET OPERATIONS
+
+func (c *Step) GetRole(ctx context.Context) (*types.Role, error) {
+	var apiErr smithy.APIError
+
+	read := &iam.GetRoleInput{
+		RoleName: aws.String(c.iam.RoleName()),
+	}
+
+	output, err := c.iam.Client().GetRole(ctx, read)
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchEntity":
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Role, nil
+}
+
+// Attached reports whether the policy is attached to the role and the eni role exists
+func (c *Step) Attached(ctx context.Context) (bool, error) {
+	var apiErr smithy.APIError
+
+	_, err := c.iam.Client().GetRole(ctx, &iam.GetRoleInput{
+		RoleName: aws.String(c.iam.EniRoleName()),
+	})
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchEntity" {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	paginator := iam.NewListAttachedRolePoliciesPaginator(c.iam.Client(), &iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(c.iam.RoleName()),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchEntity" {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		for _, policy := range page.AttachedPolicies {
+			if aws.ToString(policy.PolicyArn) == c.iam.PolicyArn() {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// GetPolicyDocument returns the default version of the policy document, or empty if the policy does not exist
+func (c *Step) GetPolicyDocument(ctx context.Context) (string, error) {
+	var apiErr smithy.APIError
+
+	policy, err := c.iam.Client().GetPolicy(ctx, &iam.GetPolicyInput{
+		PolicyArn: aws.String(c.iam.PolicyArn()),
+	})
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchEntity":
+			return "", nil
+		default:
+			return "", err
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	version, err := c.iam.Client().GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
+		PolicyArn: aws.String(c.iam.PolicyArn()),
+		VersionId: policy.Policy.DefaultVersionId,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return decode(version.PolicyVersion.Document)
+}
+
 // Util
 
+// digest returns the sha256 of a policy document, ignoring insignificant whitespace
+func digest(document string) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(document)); err != nil {
+		compact.Reset()
+		compact.WriteString(document)
+	}
+
+	sum := sha256.Sum256(compact.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// decode unescapes the url encoded documents returned by the IAM API
+func decode(document *string) (string, error) {
+	if document == nil {
+		return "", nil
+	}
+
+	return url.QueryUnescape(*document)
+}
+
 func (c *Step) GCPolicyVersions(ctx context.Context, policyArn string) error {
 	var apiErr smithy.APIError
 
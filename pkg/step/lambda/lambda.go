@@ -4,8 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bkeane/monad/internal/registryv2"
+	"github.com/bkeane/monad/pkg/inventory"
+	"github.com/bkeane/monad/pkg/plan"
 	"github.com/bkeane/monad/pkg/registry"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -26,6 +32,10 @@ type LambdaConfig interface {
 	Retries() int32
 	Env() map[string]string
 	Tags() map[string]string
+	Alias() string
+	Canary() []int32
+	CanaryWait() int32
+	TargetArn() string
 }
 
 type IamConfig interface {
@@ -42,17 +52,35 @@ type CloudWatchConfig interface {
 	Name() string
 }
 
+// Gate checks a version while it serves a canary share of the alias traffic,
+// halting the shift to it when the check fails.
+type Gate interface {
+	Probe(ctx context.Context, version string) error
+}
+
 
 type Function struct {
-	Name    string
-	Memory  int32
-	Disk    int32
-	Timeout int32
+	Name    string `json:"name" yaml:"name"`
+	Image   string `json:"image,omitempty" yaml:"image,omitempty"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	Alias   string `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Memory  int32  `json:"memory" yaml:"memory"`
+	Disk    int32  `json:"disk" yaml:"disk"`
+	Timeout int32  `json:"timeout" yaml:"timeout"`
 }
 
 type Summary struct {
-	FunctionsCreated []Function
-	FunctionsDeleted []Function
+	FunctionsCreated   []Function `json:"functions_created,omitempty" yaml:"functions_created,omitempty"`
+	FunctionsUnchanged []Function `json:"functions_unchanged,omitempty" yaml:"functions_unchanged,omitempty"`
+	FunctionsDeleted   []Function `json:"functions_deleted,omitempty" yaml:"functions_deleted,omitempty"`
+}
+
+// Snapshot records the function as it was before a mount
+type Snapshot struct {
+	Exists        bool
+	ImageUri      string
+	Configuration *types.FunctionConfiguration
+	AliasVersion  string
 }
 
 type Step struct {
@@ -61,6 +89,10 @@ type Step struct {
 	iam        IamConfig
 	vpc        VpcConfig
 	cloudwatch CloudWatchConfig
+	snapshot   *Snapshot
+	capture    bool
+	gate       Gate
+	summary    Summary
 }
 
 func Derive(lambda LambdaConfig, ecr registry.ImageRegistry, iam IamConfig, vpc VpcConfig, cloudwatch CloudWatchConfig) *Step {
@@ -73,8 +105,20 @@ func Derive(lambda LambdaConfig, ecr registry.ImageRegistry, iam IamConfig, vpc
 	}
 }
 
+// GateWith sets the check made at each canary percentage before more traffic is shifted
+func (c *Step) GateWith(gate Gate) {
+	c.gate = gate
+}
+
 func (c *Step) Mount(ctx context.Context) error {
+	if c.capture {
+		if err := c.Snapshot(ctx); err != nil {
+			return err
+		}
+	}
+
 	summary, err := c.mount(ctx)
+	c.summary = summary
 	if err != nil {
 		return err
 	}
@@ -93,8 +137,43 @@ func (c *Step) Mount(ctx context.Context) error {
 	return nil
 }
 
+// Reconcile mounts the function only when its image or configuration differ from the config.
+// An unchanged function is left in place apart from its tags, async retries and alias.
+func (c *Step) Reconcile(ctx context.Context) error {
+	p, err := c.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !p.Empty() {
+		return c.Mount(ctx)
+	}
+
+	if c.capture {
+		if err := c.Snapshot(ctx); err != nil {
+			return err
+		}
+	}
+
+	summary, err := c.keep(ctx)
+	c.summary = summary
+	if err != nil {
+		return err
+	}
+
+	for _, function := range summary.FunctionsUnchanged {
+		log.Info().
+			Str("action", "keep").
+			Str("name", function.Name).
+			Msg("lambda")
+	}
+
+	return nil
+}
+
 func (c *Step) Unmount(ctx context.Context) error {
 	summary, err := c.unmount(ctx)
+	c.summary = summary
 	if err != nil {
 		return err
 	}
@@ -110,11 +189,255 @@ func (c *Step) Unmount(ctx context.Context) error {
 	return nil
 }
 
+// Snapshot captures the deployed function so that Restore can return to it
+func (c *Step) Snapshot(ctx context.Context) error {
+	output, err := c.GetFunction(ctx)
+	if err != nil {
+		return err
+	}
+
+	if output == nil {
+		c.snapshot = &Snapshot{Exists: false}
+		return nil
+	}
+
+	c.snapshot = &Snapshot{
+		Exists:        true,
+		Configuration: output.Configuration,
+	}
+
+	if output.Code != nil && output.Code.ImageUri != nil {
+		c.snapshot.ImageUri = *output.Code.ImageUri
+	}
+
+	alias, err := c.GetAlias(ctx)
+	if err != nil {
+		return err
+	}
+
+	if alias != nil {
+		c.snapshot.AliasVersion = aws.ToString(alias.FunctionVersion)
+	}
+
+	return nil
+}
+
+// Restore returns the function to its snapshot, deleting it if it did not exist
+func (c *Step) Restore(ctx context.Context) error {
+	if c.snapshot == nil {
+		return nil
+	}
+
+	if !c.snapshot.Exists {
+		return c.Unmount(ctx)
+	}
+
+	if err := c.RestoreFunction(ctx, c.snapshot); err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("action", "restore").
+		Str("name", c.lambda.FunctionName()).
+		Str("image", c.snapshot.ImageUri).
+		Msg("lambda")
+
+	return nil
+}
+
+// Capture snapshots the function at every mount so that it can be reverted after the saga completes
+func (c *Step) Capture() {
+	c.capture = true
+}
+
+// Revert returns the function to its snapshot when it existed before the mount.
+// Unlike Restore, a function created by the mount is left in place.
+func (c *Step) Revert(ctx context.Context) (bool, error) {
+	if c.snapshot == nil || !c.snapshot.Exists {
+		return false, nil
+	}
+
+	return true, c.Restore(ctx)
+}
+
+// Summary returns the work done by the last mount or unmount
+func (c *Step) Summary() any {
+	return c.summary
+}
+
+// Outputs returns the identifiers of the deployed function
+func (c *Step) Outputs() map[string]string {
+	outputs := map[string]string{
+		"function_name": c.lambda.FunctionName(),
+		"function_arn":  c.lambda.FunctionArn(),
+	}
+
+	for _, function := range slices.Concat(c.summary.FunctionsCreated, c.summary.FunctionsUnchanged) {
+		if function.Image != "" {
+			outputs["image"] = function.Image
+		}
+		if function.Version != "" {
+			outputs["version"] = function.Version
+		}
+	}
+
+	if c.lambda.Alias() != "" {
+		outputs["alias_arn"] = c.lambda.TargetArn()
+	}
+
+	return outputs
+}
+
+// Exists reports whether the function has been deployed
+func (c *Step) Exists(ctx context.Context) (bool, error) {
+	function, err := c.GetFunction(ctx)
+	return function != nil, err
+}
+
+// Plan reports how mounting would change the deployed function
+func (c *Step) Plan(ctx context.Context) (*plan.Plan, error) {
+	p := plan.New("lambda")
+	name := c.lambda.FunctionName()
+
+	image, err := c.GetImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	function, err := c.GetFunction(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if function == nil {
+		p.Create("function", name)
+		p.Field("function", name, "image", "", image.Uri)
+		p.Map("env", name, map[string]string{}, c.lambda.Env())
+		return p, nil
+	}
+
+	config := function.Configuration
+
+	currentImage := ""
+	if function.Code != nil {
+		currentImage = aws.ToString(function.Code.ImageUri)
+	}
+
+	currentEnv := map[string]string{}
+	if config.Environment != nil && config.Environment.Variables != nil {
+		currentEnv = config.Environment.Variables
+	}
+
+	var currentSecurityGroups, currentSubnets []string
+	if config.VpcConfig != nil {
+		currentSecurityGroups = config.VpcConfig.SecurityGroupIds
+		currentSubnets = config.VpcConfig.SubnetIds
+	}
+
+	currentDisk := int32(0)
+	if config.EphemeralStorage != nil {
+		currentDisk = aws.ToInt32(config.EphemeralStorage.Size)
+	}
+
+	currentLogGroup := ""
+	if config.LoggingConfig != nil {
+		currentLogGroup = aws.ToString(config.LoggingConfig.LogGroup)
+	}
+
+	p.Field("function", name, "image", currentImage, image.Uri)
+	p.Field("function", name, "role", aws.ToString(config.Role), c.iam.RoleArn())
+	p.Field("function", name, "memory", itoa(aws.ToInt32(config.MemorySize)), itoa(c.lambda.MemorySize()))
+	p.Field("function", name, "timeout", itoa(aws.ToInt32(config.Timeout)), itoa(c.lambda.Timeout()))
+	p.Field("function", name, "disk", itoa(currentDisk), itoa(c.lambda.EphemeralStorage()))
+	p.Field("function", name, "log_group", currentLogGroup, c.cloudwatch.Name())
+
+	if c.lambda.Alias() != "" {
+		alias, err := c.GetAlias(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		currentAlias := ""
+		if alias != nil {
+			currentAlias = aws.ToString(alias.Name)
+		}
+		p.Field("function", name, "alias", currentAlias, c.lambda.Alias())
+	}
+	p.Field("function", name, "security_groups", join(currentSecurityGroups), join(c.vpc.SecurityGroupIds()))
+	p.Field("function", name, "subnets", join(currentSubnets), join(c.vpc.SubnetIds()))
+	p.Map("env", name, currentEnv, c.lambda.Env())
+
+	return p, nil
+}
+
+// Describe lists the deployed function along with its image, sizing and alias
+func (c *Step) Describe(ctx context.Context) (*inventory.Inventory, error) {
+	i := inventory.New("lambda")
+
+	function, err := c.GetFunction(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if function == nil {
+		return i, nil
+	}
+
+	config := function.Configuration
+
+	image, digest := "", ""
+	if function.Code != nil {
+		image = aws.ToString(function.Code.ImageUri)
+		if _, resolved, found := strings.Cut(aws.ToString(function.Code.ResolvedImageUri), "@"); found {
+			digest = resolved
+		}
+	}
+
+	if digest == "" && aws.ToString(config.CodeSha256) != "" {
+		digest = "sha256:" + aws.ToString(config.CodeSha256)
+	}
+
+	var architectures []string
+	for _, architecture := range config.Architectures {
+		architectures = append(architectures, string(architecture))
+	}
+
+	disk := int32(0)
+	if config.EphemeralStorage != nil {
+		disk = aws.ToInt32(config.EphemeralStorage.Size)
+	}
+
+	i.Add("function", c.lambda.FunctionName()).
+		Set("arn", aws.ToString(config.FunctionArn)).
+		Set("image", image).
+		Set("digest", digest).
+		Set("architecture", join(architectures)).
+		Set("memory", itoa(aws.ToInt32(config.MemorySize))+" MB").
+		Set("timeout", itoa(aws.ToInt32(config.Timeout))+" s").
+		Set("disk", itoa(disk)+" MB").
+		Set("role", aws.ToString(config.Role)).
+		Set("modified", aws.ToString(config.LastModified))
+
+	alias, err := c.GetAlias(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if alias != nil {
+		i.Add("alias", aws.ToString(alias.Name)).
+			Set("arn", aws.ToString(alias.AliasArn)).
+			Set("version", aws.ToString(alias.FunctionVersion))
+	}
+
+	return i, nil
+}
+
 // Internal methods that return summaries of work done
 func (c *Step) mount(ctx context.Context) (Summary, error) {
 	var summary Summary
 
-	if _, err := c.PutFunction(ctx); err != nil {
+	output, err := c.PutFunction(ctx)
+	if err != nil {
 		return summary, err
 	}
 
@@ -125,11 +448,89 @@ func (c *Step) mount(ctx context.Context) (Summary, error) {
 		Disk:    c.lambda.EphemeralStorage(),
 		Timeout: c.lambda.Timeout(),
 	}
+
+	if output.Code != nil && output.Code.ImageUri != nil {
+		function.Image = *output.Code.ImageUri
+	}
+
+	if c.lambda.Alias() != "" {
+		version, err := c.PublishVersion(ctx)
+		if err != nil {
+			return summary, err
+		}
+
+		if err := c.PutAlias(ctx, version); err != nil {
+			return summary, err
+		}
+
+		function.Version = version
+		function.Alias = c.lambda.Alias()
+	}
+
 	summary.FunctionsCreated = append(summary.FunctionsCreated, function)
 
 	return summary, nil
 }
 
+// keep brings the tags, async retries and alias of an otherwise unchanged function in line with the config
+func (c *Step) keep(ctx context.Context) (Summary, error) {
+	var summary Summary
+
+	output, err := c.GetFunction(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	if output == nil {
+		return summary, fmt.Errorf("function %s does not exist", c.lambda.FunctionName())
+	}
+
+	function := Function{
+		Name:    c.lambda.FunctionName(),
+		Memory:  c.lambda.MemorySize(),
+		Disk:    c.lambda.EphemeralStorage(),
+		Timeout: c.lambda.Timeout(),
+	}
+
+	if output.Code != nil {
+		function.Image = aws.ToString(output.Code.ImageUri)
+	}
+
+	if !contains(output.Tags, c.lambda.Tags()) {
+		_, err := c.lambda.Client().TagResource(ctx, &lambda.TagResourceInput{
+			Resource: aws.String(c.lambda.FunctionArn()),
+			Tags:     c.lambda.Tags(),
+		})
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	if err := c.PutRetries(ctx); err != nil {
+		return summary, err
+	}
+
+	if c.lambda.Alias() != "" {
+		// publishing an unchanged function returns its latest version, which the
+		// alias already points at unless a canary was interrupted
+		version, err := c.PublishVersion(ctx)
+		if err != nil {
+			return summary, err
+		}
+
+		if err := c.PutAlias(ctx, version); err != nil {
+			return summary, err
+		}
+
+		function.Version = version
+		function.Alias = c.lambda.Alias()
+	}
+
+	summary.FunctionsUnchanged = append(summary.FunctionsUnchanged, function)
+
+	return summary, nil
+}
+
 func (c *Step) unmount(ctx context.Context) (Summary, error) {
 	var summary Summary
 
@@ -154,6 +555,27 @@ func (c *Step) GetImage(ctx context.Context) (registryv2.ImagePointer, error) {
 	return c.registry.GetImage(ctx)
 }
 
+// GetFunction returns the deployed function, or nil if it does not exist
+func (c *Step) GetFunction(ctx context.Context) (*lambda.GetFunctionOutput, error) {
+	var apiErr smithy.APIError
+
+	read := &lambda.GetFunctionInput{
+		FunctionName: aws.String(c.lambda.FunctionName()),
+	}
+
+	output, err := c.lambda.Client().GetFunction(ctx, read)
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ResourceNotFoundException":
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return output, err
+}
+
 // PUT Operations
 func (c *Step) PutFunction(ctx context.Context) (*lambda.GetFunctionOutput, error) {
 	var apiErr smithy.APIError
@@ -295,6 +717,209 @@ func (c *Step) PutFunction(ctx context.Context) (*lambda.GetFunctionOutput, erro
 	return c.lambda.Client().GetFunction(ctx, read)
 }
 
+// PutRetries sets the async invoke retries when they differ from the config
+func (c *Step) PutRetries(ctx context.Context) error {
+	var apiErr smithy.APIError
+
+	current, err := c.lambda.Client().GetFunctionEventInvokeConfig(ctx, &lambda.GetFunctionEventInvokeConfigInput{
+		FunctionName: aws.String(c.lambda.FunctionName()),
+	})
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ResourceNotFoundException":
+			break
+		default:
+			return err
+		}
+	}
+
+	if current != nil && aws.ToInt32(current.MaximumRetryAttempts) == c.lambda.Retries() {
+		return nil
+	}
+
+	_, err = c.lambda.Client().PutFunctionEventInvokeConfig(ctx, &lambda.PutFunctionEventInvokeConfigInput{
+		FunctionName:         aws.String(c.lambda.FunctionName()),
+		MaximumRetryAttempts: aws.Int32(c.lambda.Retries()),
+	})
+
+	return err
+}
+
+func (c *Step) RestoreFunction(ctx context.Context, snapshot *Snapshot) error {
+	previous := snapshot.Configuration
+	if previous == nil {
+		return fmt.Errorf("snapshot of %s has no configuration", c.lambda.FunctionName())
+	}
+
+	config := &lambda.UpdateFunctionConfigurationInput{
+		FunctionName:     aws.String(c.lambda.FunctionName()),
+		Role:             previous.Role,
+		MemorySize:       previous.MemorySize,
+		Timeout:          previous.Timeout,
+		EphemeralStorage: previous.EphemeralStorage,
+		VpcConfig: &types.VpcConfig{
+			SecurityGroupIds: []string{},
+			SubnetIds:        []string{},
+		},
+		Environment: &types.Environment{
+			Variables: map[string]string{},
+		},
+	}
+
+	if previous.VpcConfig != nil {
+		config.VpcConfig.SecurityGroupIds = append(config.VpcConfig.SecurityGroupIds, previous.VpcConfig.SecurityGroupIds...)
+		config.VpcConfig.SubnetIds = append(config.VpcConfig.SubnetIds, previous.VpcConfig.SubnetIds...)
+	}
+
+	if previous.Environment != nil && previous.Environment.Variables != nil {
+		config.Environment.Variables = previous.Environment.Variables
+	}
+
+	if previous.LoggingConfig != nil && previous.LoggingConfig.LogGroup != nil {
+		config.LoggingConfig = &types.LoggingConfig{
+			LogGroup: previous.LoggingConfig.LogGroup,
+		}
+	}
+
+	code := &lambda.UpdateFunctionCodeInput{
+		FunctionName:  aws.String(c.lambda.FunctionName()),
+		ImageUri:      aws.String(snapshot.ImageUri),
+		Architectures: previous.Architectures,
+	}
+
+	if _, err := c.lambda.Client().UpdateFunctionCode(ctx, code, RetryUpdate); err != nil {
+		return err
+	}
+
+	if _, err := c.lambda.Client().UpdateFunctionConfiguration(ctx, config, RetryUpdate); err != nil {
+		return err
+	}
+
+	if snapshot.AliasVersion != "" {
+		if err := c.shift(ctx, snapshot.AliasVersion, "", 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PublishVersion publishes the deployed code and configuration, returning the version.
+// Publishing an unchanged function returns its latest version. Versions are described by
+// the sha deployed, which rollbacks read when the journal does not hold the deploy.
+func (c *Step) PublishVersion(ctx context.Context) (string, error) {
+	output, err := c.lambda.Client().PublishVersion(ctx, &lambda.PublishVersionInput{
+		FunctionName: aws.String(c.lambda.FunctionName()),
+		Description:  aws.String(c.lambda.Tags()["Sha"]),
+	}, RetryUpdate)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(output.Version), nil
+}
+
+// PutAlias points the alias at version, shifting traffic through the canary percentages when the alias already exists.
+// A version failing the gate at any percentage is shifted out of the alias again.
+func (c *Step) PutAlias(ctx context.Context, version string) error {
+	alias, err := c.GetAlias(ctx)
+	if err != nil {
+		return err
+	}
+
+	if alias == nil {
+		_, err := c.lambda.Client().CreateAlias(ctx, &lambda.CreateAliasInput{
+			FunctionName:    aws.String(c.lambda.FunctionName()),
+			Name:            aws.String(c.lambda.Alias()),
+			FunctionVersion: aws.String(version),
+		}, RetryUpdate)
+		return err
+	}
+
+	current := aws.ToString(alias.FunctionVersion)
+	if current == version {
+		return nil
+	}
+
+	wait := time.Duration(c.lambda.CanaryWait()) * time.Second
+	for _, percent := range c.lambda.Canary() {
+		if err := c.shift(ctx, current, version, percent); err != nil {
+			return err
+		}
+
+		log.Info().
+			Str("action", "shift").
+			Str("alias", c.lambda.Alias()).
+			Str("version", version).
+			Int32("percent", percent).
+			Msg("lambda")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if c.gate == nil {
+			continue
+		}
+
+		if err := c.gate.Probe(ctx, version); err != nil {
+			failed := fmt.Errorf("canary of version %s failed at %d%%: %w", version, percent, err)
+			if err := c.shift(ctx, current, "", 0); err != nil {
+				return errors.Join(failed, fmt.Errorf("shift back to version %s failed: %w", current, err))
+			}
+			return failed
+		}
+	}
+
+	return c.shift(ctx, version, "", 0)
+}
+
+// shift points the alias at version, routing percent of its traffic to canary when one is given
+func (c *Step) shift(ctx context.Context, version, canary string, percent int32) error {
+	routing := &types.AliasRoutingConfiguration{
+		AdditionalVersionWeights: map[string]float64{},
+	}
+
+	if canary != "" {
+		routing.AdditionalVersionWeights[canary] = float64(percent) / 100
+	}
+
+	_, err := c.lambda.Client().UpdateAlias(ctx, &lambda.UpdateAliasInput{
+		FunctionName:    aws.String(c.lambda.FunctionName()),
+		Name:            aws.String(c.lambda.Alias()),
+		FunctionVersion: aws.String(version),
+		RoutingConfig:   routing,
+	}, RetryUpdate)
+
+	return err
+}
+
+// GetAlias returns the configured alias, or nil when there is none or it does not exist
+func (c *Step) GetAlias(ctx context.Context) (*lambda.GetAliasOutput, error) {
+	var apiErr smithy.APIError
+
+	if c.lambda.Alias() == "" {
+		return nil, nil
+	}
+
+	output, err := c.lambda.Client().GetAlias(ctx, &lambda.GetAliasInput{
+		FunctionName: aws.String(c.lambda.FunctionName()),
+		Name:         aws.String(c.lambda.Alias()),
+	})
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ResourceNotFoundException":
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return output, err
+}
+
 // DELETE Operations
 func (c *Step) DeleteFunction(ctx context.Context) (*lambda.DeleteFunctionOutput, error) {
 	var apiErr smithy.APIError
@@ -318,6 +943,26 @@ func (c *Step) DeleteFunction(ctx context.Context) (*lambda.DeleteFunctionOutput
 
 // Util
 
+func itoa(value int32) string {
+	return strconv.Itoa(int(value))
+}
+
+func join(values []string) string {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// contains reports whether every tag of want is set to the same value in have
+func contains(have, want map[string]string) bool {
+	for key, value := range want {
+		if current, ok := have[key]; !ok || current != value {
+			return false
+		}
+	}
+	return true
+}
+
 func RetryCreate(options *lambda.Options) {
 	options.Retryer = retry.AddWithErrorCodes(options.Retryer,
 		(*types.InvalidParameterValueException)(nil).ErrorCode(),
@@ -331,3 +976,35 @@ func RetryUpdate(options *lambda.Options) {
 	)
 	options.Retryer = retry.AddWithMaxAttempts(options.Retryer, 15)
 }
+
+// RetryPolicy retries function policy edits which conflict with those made by concurrent
+// steps, but not the addition of a statement whose id already exists
+func RetryPolicy(options *lambda.Options) {
+	retryer := retry.AddWithMaxAttempts(options.Retryer, 10).(aws.RetryerV2)
+	options.Retryer = &policyRetryer{RetryerV2: retryer}
+}
+
+type policyRetryer struct {
+	aws.RetryerV2
+}
+
+func (r *policyRetryer) IsErrorRetryable(err error) bool {
+	var conflict *types.ResourceConflictException
+	var precondition *types.PreconditionFailedException
+
+	switch {
+	case StatementExists(err):
+		return false
+	case errors.As(err, &conflict), errors.As(err, &precondition):
+		return true
+	default:
+		return r.RetryerV2.IsErrorRetryable(err)
+	}
+}
+
+// StatementExists reports whether err is the conflict of adding a policy statement whose id
+// is already taken, as opposed to one with a concurrent edit of the policy
+func StatementExists(err error) bool {
+	var conflict *types.ResourceConflictException
+	return errors.As(err, &conflict) && strings.Contains(conflict.ErrorMessage(), "already exists")
+}
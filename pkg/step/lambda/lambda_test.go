@@ -0,0 +1,31 @@
+package lambda
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	options := lambda.Options{Retryer: retry.NewStandard()}
+	RetryPolicy(&options)
+
+	exists := &types.ResourceConflictException{Message: aws.String("The statement id (svc) provided already exists. Please provide a new statement id, or remove the existing statement.")}
+	concurrent := &types.ResourceConflictException{Message: aws.String("The resource you requested is currently in use by a pending update.")}
+	precondition := &types.PreconditionFailedException{Message: aws.String("The RevisionId provided does not match the latest RevisionId.")}
+
+	assert.Equal(t, 10, options.Retryer.MaxAttempts())
+	assert.False(t, options.Retryer.IsErrorRetryable(fmt.Errorf("add permission: %w", exists)))
+	assert.True(t, options.Retryer.IsErrorRetryable(concurrent))
+	assert.True(t, options.Retryer.IsErrorRetryable(precondition))
+	assert.False(t, options.Retryer.IsErrorRetryable(errors.New("access denied")))
+
+	assert.True(t, StatementExists(exists))
+	assert.False(t, StatementExists(concurrent))
+}
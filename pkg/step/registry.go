@@ -0,0 +1,199 @@
+package step
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/bkeane/monad/pkg/config"
+	"github.com/bkeane/monad/pkg/registry"
+	"github.com/bkeane/monad/pkg/step/apigateway"
+	"github.com/bkeane/monad/pkg/step/cloudwatch"
+	"github.com/bkeane/monad/pkg/step/eventbridge"
+	"github.com/bkeane/monad/pkg/step/health"
+	"github.com/bkeane/monad/pkg/step/iam"
+	"github.com/bkeane/monad/pkg/step/lambda"
+)
+
+//
+// Definition
+//
+
+// Definition describes a step: its unique name, the steps that must mount
+// before it, and how it is derived from config.
+type Definition struct {
+	Name         string
+	Dependencies []string
+	Derive       func(ctx context.Context, config *config.Config) (Step, error)
+}
+
+//
+// Registry
+//
+
+var (
+	mu          sync.RWMutex
+	definitions = []Definition{
+		{
+			Name:   "iam",
+			Derive: deriveIam,
+		},
+		{
+			Name:   "cloudwatch",
+			Derive: deriveCloudWatch,
+		},
+		{
+			Name:         "lambda",
+			Dependencies: []string{"iam", "cloudwatch"},
+			Derive:       deriveLambda,
+		},
+		{
+			Name:         "apigateway",
+			Dependencies: []string{"lambda"},
+			Derive:       deriveApiGateway,
+		},
+		{
+			Name:         "eventbridge",
+			Dependencies: []string{"lambda"},
+			Derive:       deriveEventBridge,
+		},
+		{
+			Name:         "health",
+			Dependencies: []string{"apigateway"},
+			Derive:       deriveHealth,
+		},
+	}
+)
+
+// Register adds a step to every saga derived afterwards.
+// It is intended to be called from an init function before the CLI runs.
+func Register(definition Definition) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if definition.Name == "" {
+		return fmt.Errorf("step name is required")
+	}
+
+	if definition.Derive == nil {
+		return fmt.Errorf("step %s requires a derive function", definition.Name)
+	}
+
+	for _, registered := range definitions {
+		if registered.Name == definition.Name {
+			return fmt.Errorf("step %s is already registered", definition.Name)
+		}
+	}
+
+	definition.Dependencies = slices.Clone(definition.Dependencies)
+	definitions = append(definitions, definition)
+	return nil
+}
+
+// Definitions returns the registered steps in registration order
+func Definitions() []Definition {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return slices.Clone(definitions)
+}
+
+//
+// Builtins
+//
+
+func deriveIam(ctx context.Context, config *config.Config) (Step, error) {
+	iamConfig, err := config.Iam(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return iam.Derive(iamConfig), nil
+}
+
+func deriveCloudWatch(ctx context.Context, config *config.Config) (Step, error) {
+	cloudwatchConfig, err := config.CloudWatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return cloudwatch.Derive(cloudwatchConfig), nil
+}
+
+func deriveLambda(ctx context.Context, config *config.Config) (Step, error) {
+	ecrConfig, err := config.Ecr(ctx)
+	if err != nil {
+		return nil, err
+	}
+	registryClient := registry.Derive(ecrConfig)
+
+	iamConfig, err := config.Iam(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudwatchConfig, err := config.CloudWatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lambdaConfig, err := config.Lambda(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vpcConfig, err := config.Vpc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return lambda.Derive(lambdaConfig, registryClient, iamConfig, vpcConfig, cloudwatchConfig), nil
+}
+
+func deriveApiGateway(ctx context.Context, config *config.Config) (Step, error) {
+	apigatewayConfig, err := config.ApiGateway(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lambdaConfig, err := config.Lambda(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return apigateway.Derive(apigatewayConfig, lambdaConfig), nil
+}
+
+func deriveEventBridge(ctx context.Context, config *config.Config) (Step, error) {
+	eventbridgeConfig, err := config.EventBridge(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lambdaConfig, err := config.Lambda(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return eventbridge.Derive(eventbridgeConfig, lambdaConfig), nil
+}
+
+func deriveHealth(ctx context.Context, config *config.Config) (Step, error) {
+	healthConfig, err := config.Health(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apigatewayConfig, err := config.ApiGateway(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lambdaConfig, err := config.Lambda(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return health.Derive(healthConfig, apigatewayConfig, lambdaConfig), nil
+}
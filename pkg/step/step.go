@@ -2,81 +2,106 @@ package step
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/bkeane/monad/pkg/config"
-	"github.com/bkeane/monad/pkg/registry"
+	"github.com/bkeane/monad/pkg/inventory"
+	"github.com/bkeane/monad/pkg/plan"
 	"github.com/bkeane/monad/pkg/step/apigateway"
 	"github.com/bkeane/monad/pkg/step/cloudwatch"
 	"github.com/bkeane/monad/pkg/step/eventbridge"
+	"github.com/bkeane/monad/pkg/step/health"
 	"github.com/bkeane/monad/pkg/step/iam"
 	"github.com/bkeane/monad/pkg/step/lambda"
-
-	v "github.com/go-ozzo/ozzo-validation/v4"
 )
 
+//
+// Interfaces
+//
+
+// Step is a unit of work mounted and unmounted by the saga
+type Step interface {
+	Mount(ctx context.Context) error
+	Unmount(ctx context.Context) error
+}
+
+// Planner is implemented by steps that can report the changes a mount would
+// make while only reading from AWS.
+type Planner interface {
+	Plan(ctx context.Context) (*plan.Plan, error)
+}
+
+// Describer is implemented by steps that can list the resources they have
+// deployed while only reading from AWS.
+type Describer interface {
+	Describe(ctx context.Context) (*inventory.Inventory, error)
+}
+
+// Restorer is implemented by steps that can capture their resources before a
+// mount and return them to that state should the saga fail.
+type Restorer interface {
+	Snapshot(ctx context.Context) error
+	Restore(ctx context.Context) error
+}
+
+// Reconciler is implemented by steps that can bring existing resources in line
+// with the config, writing only what differs instead of replacing everything.
+type Reconciler interface {
+	Reconcile(ctx context.Context) error
+}
+
+// Prober is implemented by steps that can report whether their resources
+// have been mounted.
+type Prober interface {
+	Exists(ctx context.Context) (bool, error)
+}
+
+// Reporter is implemented by steps that can describe the work done by their
+// last mount or unmount along with the identifiers of what they manage.
+type Reporter interface {
+	Summary() any
+	Outputs() map[string]string
+}
+
 //
 // Steps
 //
 
 type Steps struct {
-	iam         *iam.Step
-	cloudwatch  *cloudwatch.Step
-	lambda      *lambda.Step
-	apigateway  *apigateway.Step
-	eventbridge *eventbridge.Step
+	definitions []Definition
+	steps       map[string]Step
 }
 
 //
 // Derive
 //
 
+// Derive builds every registered step from the given config
 func Derive(ctx context.Context, config *config.Config) (*Steps, error) {
-	ecrConfig, err := config.Ecr(ctx)
-	if err != nil {
-		return nil, err
-	}
-	registryClient := registry.Derive(ecrConfig)
-
-	iamConfig, err := config.Iam(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	cloudwatchConfig, err := config.CloudWatch(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	lambdaConfig, err := config.Lambda(ctx)
-	if err != nil {
-		return nil, err
+	steps := &Steps{
+		definitions: Definitions(),
+		steps:       map[string]Step{},
 	}
 
-	vpcConfig, err := config.Vpc(ctx)
-	if err != nil {
-		return nil, err
-	}
+	for _, definition := range steps.definitions {
+		step, err := definition.Derive(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive %s step: %w", definition.Name, err)
+		}
 
-	apigatewayConfig, err := config.ApiGateway(ctx)
-	if err != nil {
-		return nil, err
+		steps.steps[definition.Name] = step
 	}
 
-	eventbridgeConfig, err := config.EventBridge(ctx)
-	if err != nil {
+	if err := steps.Validate(); err != nil {
 		return nil, err
 	}
-	
-	steps := &Steps{
-		iam:         iam.Derive(iamConfig),
-		cloudwatch:  cloudwatch.Derive(cloudwatchConfig),
-		lambda:      lambda.Derive(lambdaConfig, registryClient, iamConfig, vpcConfig, cloudwatchConfig),
-		apigateway:  apigateway.Derive(apigatewayConfig, lambdaConfig),
-		eventbridge: eventbridge.Derive(eventbridgeConfig, lambdaConfig),
-	}
 
-	if err := steps.Validate(); err != nil {
-		return nil, err
+	// a failed health check reverts the function, so the function is captured as it mounts,
+	// and each canary percentage is checked before more traffic is shifted to the new version
+	if check, function := steps.Health(), steps.Lambda(); check != nil && function != nil && check.Enabled() {
+		function.Capture()
+		function.GateWith(check)
+		check.RevertWith(function)
 	}
 
 	return steps, nil
@@ -87,41 +112,81 @@ func Derive(ctx context.Context, config *config.Config) (*Steps, error) {
 //
 
 func (s *Steps) Validate() error {
-	return v.ValidateStruct(s,
-		v.Field(&s.iam),
-		v.Field(&s.cloudwatch),
-		v.Field(&s.lambda),
-		v.Field(&s.apigateway),
-		v.Field(&s.eventbridge),
-	)
+	for _, definition := range s.definitions {
+		if s.steps[definition.Name] == nil {
+			return fmt.Errorf("%s step is required", definition.Name)
+		}
+
+		for _, dependency := range definition.Dependencies {
+			if _, ok := s.steps[dependency]; !ok {
+				return fmt.Errorf("%s step depends on unknown step %s", definition.Name, dependency)
+			}
+		}
+	}
+
+	return nil
 }
 
 //
 // Accessors
 //
 
+// Names returns the step names in registration order
+func (s *Steps) Names() []string {
+	var names []string
+	for _, definition := range s.definitions {
+		names = append(names, definition.Name)
+	}
+	return names
+}
+
+// Step returns the named step instance, or nil if it is not registered
+func (s *Steps) Step(name string) Step {
+	return s.steps[name]
+}
+
+// Dependencies returns the names of the steps the named step depends upon
+func (s *Steps) Dependencies(name string) []string {
+	for _, definition := range s.definitions {
+		if definition.Name == name {
+			return definition.Dependencies
+		}
+	}
+	return nil
+}
+
 // IAM returns the IAM step instance
 func (s *Steps) IAM() *iam.Step {
-	return s.iam
+	step, _ := s.steps["iam"].(*iam.Step)
+	return step
 }
 
 // CloudWatch returns the CloudWatch step instance
 func (s *Steps) CloudWatch() *cloudwatch.Step {
-	return s.cloudwatch
+	step, _ := s.steps["cloudwatch"].(*cloudwatch.Step)
+	return step
 }
 
 // Lambda returns the Lambda step instance
 func (s *Steps) Lambda() *lambda.Step {
-	return s.lambda
+	step, _ := s.steps["lambda"].(*lambda.Step)
+	return step
 }
 
 // ApiGateway returns the API Gateway step instance
 func (s *Steps) ApiGateway() *apigateway.Step {
-	return s.apigateway
+	step, _ := s.steps["apigateway"].(*apigateway.Step)
+	return step
 }
 
 // EventBridge returns the EventBridge step instance
 func (s *Steps) EventBridge() *eventbridge.Step {
-	return s.eventbridge
+	step, _ := s.steps["eventbridge"].(*eventbridge.Step)
+	return step
 }
 
+// Health returns the health check step instance
+func (s *Steps) Health() *health.Step {
+	step, _ := s.steps["health"].(*health.Step)
+	return step
+}
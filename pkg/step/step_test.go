@@ -0,0 +1,71 @@
+package step
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bkeane/monad/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type noop struct{}
+
+func (noop) Mount(ctx context.Context) error   { return nil }
+func (noop) Unmount(ctx context.Context) error { return nil }
+
+func deriveNoop(ctx context.Context, config *config.Config) (Step, error) {
+	return noop{}, nil
+}
+
+func TestDefinitions_Builtins(t *testing.T) {
+	var names []string
+	for _, definition := range Definitions() {
+		names = append(names, definition.Name)
+	}
+
+	assert.Subset(t, names, []string{"iam", "cloudwatch", "lambda", "apigateway", "eventbridge", "health"})
+}
+
+func TestRegister(t *testing.T) {
+	t.Cleanup(reset())
+
+	err := Register(Definition{Name: "sqs", Dependencies: []string{"lambda"}, Derive: deriveNoop})
+	assert.NoError(t, err)
+
+	registered := Definitions()
+	last := registered[len(registered)-1]
+	assert.Equal(t, "sqs", last.Name)
+	assert.Equal(t, []string{"lambda"}, last.Dependencies)
+}
+
+func TestRegister_Invalid(t *testing.T) {
+	t.Cleanup(reset())
+
+	assert.Error(t, Register(Definition{Derive: deriveNoop}), "name is required")
+	assert.Error(t, Register(Definition{Name: "sqs"}), "derive is required")
+	assert.Error(t, Register(Definition{Name: "lambda", Derive: deriveNoop}), "names are unique")
+}
+
+func TestSteps_Validate(t *testing.T) {
+	steps := &Steps{
+		definitions: []Definition{
+			{Name: "lambda"},
+			{Name: "sqs", Dependencies: []string{"missing"}},
+		},
+		steps: map[string]Step{"lambda": noop{}, "sqs": noop{}},
+	}
+	assert.ErrorContains(t, steps.Validate(), "unknown step missing")
+
+	steps.steps["sqs"] = nil
+	assert.ErrorContains(t, steps.Validate(), "sqs step is required")
+}
+
+// reset restores the registry to its current definitions
+func reset() func() {
+	saved := Definitions()
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		definitions = saved
+	}
+}
@@ -0,0 +1,440 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bkeane/monad/internal/dag"
+	"github.com/bkeane/monad/pkg/flag"
+	"github.com/bkeane/monad/pkg/report"
+
+	"github.com/caarlos0/env/v11"
+	v "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Files are the config files marking a directory holding a Dockerfile as a service
+var Files = []string{"monad.yaml", "monad.toml"}
+
+// skipped are directories never searched for services
+var skipped = []string{"node_modules", "vendor"}
+
+// reserved are env vars which must not reach the service processes, either because
+// they select services themselves or because every service sets its own.
+var reserved = []string{"MONAD_ALL", "MONAD_SERVICES", "MONAD_CONCURRENCY", "MONAD_CHDIR", "MONAD_OUTPUT", "MONAD_SERVICE"}
+
+// Runner runs the action of a single service and returns its report, if any
+type Runner func(ctx context.Context, action string, service Service) (*report.Report, error)
+
+//
+// Service
+//
+
+// Service is a directory holding a Dockerfile and a config file
+type Service struct {
+	Name      string
+	Dir       string   // slash separated, relative to the working directory
+	DependsOn []string // names of the services deployed before this one
+}
+
+//
+// Workspace
+//
+
+type Workspace struct {
+	WorkspaceAll         bool     `env:"MONAD_ALL" flag:"--all" usage:"Run for every service below the working directory"`
+	WorkspaceServices    []string `env:"MONAD_SERVICES" flag:"--services" usage:"Run for the services whose name or directory match" hint:"glob"`
+	WorkspaceConcurrency int32    `env:"MONAD_CONCURRENCY" flag:"--concurrency" usage:"Services run at once (default 4)" hint:"count"`
+	root                 string
+	run                  Runner
+}
+
+//
+// Derive
+//
+
+func Derive(ctx context.Context) (*Workspace, error) {
+	var err error
+	var workspace Workspace
+
+	if err = env.Parse(&workspace); err != nil {
+		return nil, err
+	}
+
+	if workspace.WorkspaceConcurrency == 0 {
+		workspace.WorkspaceConcurrency = 4
+	}
+
+	workspace.root, err = os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	workspace.run = workspace.exec
+
+	if err = workspace.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+//
+// Validations
+//
+
+func (w *Workspace) Validate() error {
+	return v.ValidateStruct(w,
+		v.Field(&w.WorkspaceConcurrency, v.Min(int32(1))),
+		v.Field(&w.WorkspaceServices, v.Each(v.By(pattern))),
+	)
+}
+
+func pattern(value interface{}) error {
+	if _, err := path.Match(value.(string), ""); err != nil {
+		return fmt.Errorf("invalid glob: %w", err)
+	}
+	return nil
+}
+
+//
+// Accessors
+//
+
+// Selected reports whether services were selected with --all or --services
+func (w *Workspace) Selected() bool {
+	return w.WorkspaceAll || len(w.WorkspaceServices) > 0
+}
+
+// Services returns the selected services below the working directory in lexical order
+func (w *Workspace) Services() ([]Service, error) {
+	discovered, err := Discover(w.root)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.selected(discovered), nil
+}
+
+// selected returns the services selected with --all or --services
+func (w *Workspace) selected(discovered []Service) []Service {
+	if w.WorkspaceAll {
+		return discovered
+	}
+
+	var services []Service
+	for _, service := range discovered {
+		if w.match(service) {
+			services = append(services, service)
+		}
+	}
+
+	return services
+}
+
+// Names returns the names of the selected services
+func (w *Workspace) Names() ([]string, error) {
+	services, err := w.Services()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(services))
+	for i, service := range services {
+		names[i] = service.Name
+	}
+
+	return names, nil
+}
+
+// match reports whether the name or directory of the service matches any --services glob
+func (w *Workspace) match(service Service) bool {
+	for _, pattern := range w.WorkspaceServices {
+		if matched, _ := path.Match(pattern, service.Name); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, service.Dir); matched {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Discovery
+//
+
+// Discover returns every directory below root holding a Dockerfile and a config file.
+// Services are named after their directory unless their config file sets a service,
+// and depend on the services named by depends_on in their config file.
+// Hidden directories and those in skipped are not searched.
+func Discover(root string) ([]Service, error) {
+	var services []Service
+
+	err := filepath.WalkDir(root, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+
+		if dir != root && (strings.HasPrefix(entry.Name(), ".") || slices.Contains(skipped, entry.Name())) {
+			return filepath.SkipDir
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "Dockerfile")); err != nil {
+			return nil
+		}
+
+		file, document, err := flag.File(dir, Files...)
+		if err != nil {
+			return err
+		}
+
+		if file == "" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+
+		service := Service{
+			Name:      filepath.Base(dir),
+			Dir:       filepath.ToSlash(rel),
+			DependsOn: document.DependsOn,
+		}
+
+		if name := document.Values["service"]; name != "" {
+			service.Name = name
+		}
+
+		services = append(services, service)
+		return nil
+	})
+
+	return services, err
+}
+
+//
+// Run
+//
+
+// Graph returns the dependency graph of the services. Services sharing a name,
+// depending on unknown services or on each other in a cycle are reported as errors.
+func Graph(services []Service) (*dag.Graph, error) {
+	graph := dag.New()
+	byName := map[string]Service{}
+
+	for _, service := range services {
+		if existing, ok := byName[service.Name]; ok {
+			return nil, fmt.Errorf("service %s is defined in both %s and %s", service.Name, existing.Dir, service.Dir)
+		}
+
+		if err := graph.Add(service.Name, service.DependsOn...); err != nil {
+			return nil, err
+		}
+
+		byName[service.Name] = service
+	}
+
+	if _, err := graph.Sort(); err != nil {
+		return nil, fmt.Errorf("invalid depends_on: %w", err)
+	}
+
+	return graph, nil
+}
+
+// Run runs the action, e.g. deploy or destroy, for every selected service with bounded
+// concurrency and returns the combined reports. Services are deployed after the services
+// they depend on and destroyed before them. Dependencies which are not selected are not
+// waited for. Services whose dependencies failed are skipped. Errors are joined and returned.
+func (w *Workspace) Run(ctx context.Context, action string) (*report.Batch, error) {
+	discovered, err := Discover(w.root)
+	if err != nil {
+		return nil, err
+	}
+
+	graph, err := Graph(discovered)
+	if err != nil {
+		return nil, err
+	}
+
+	services := w.selected(discovered)
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no services found below %s", w.root)
+	}
+
+	byName := map[string]Service{}
+	order := make([]string, 0, len(services))
+	for _, service := range services {
+		byName[service.Name] = service
+		order = append(order, service.Name)
+	}
+
+	graph = graph.Subset(order)
+	if action == "destroy" {
+		graph = graph.Reverse()
+	}
+
+	batch := report.NewBatch(action)
+	ran := map[string]bool{}
+	var mu sync.Mutex
+
+	err = graph.Walk(ctx, int(w.WorkspaceConcurrency), func(ctx context.Context, name string) error {
+		service := byName[name]
+		started := time.Now()
+
+		log.Info().
+			Str("service", service.Name).
+			Str("dir", service.Dir).
+			Msg(action)
+
+		serviceReport, err := w.run(ctx, action, service)
+
+		mu.Lock()
+		defer mu.Unlock()
+		batch.Add(report.Service{Name: service.Name, Dir: service.Dir}, started, serviceReport, err)
+		ran[name] = true
+
+		return err
+	})
+
+	for _, name := range order {
+		if !ran[name] {
+			batch.Skip(report.Service{Name: name, Dir: byName[name].Dir})
+		}
+	}
+
+	batch.Sort(order)
+	batch.Finish(err)
+
+	for _, service := range batch.Services {
+		event := log.Info()
+		if service.Status == report.Failed {
+			event = log.Error().Str("error", service.Error)
+		}
+
+		event.
+			Str("service", service.Name).
+			Str("status", string(service.Status)).
+			Float64("duration", service.Duration).
+			Msg(action)
+	}
+
+	return batch, err
+}
+
+// exec runs the action of the service as a child process of the running binary in the
+// directory of the service. Flags given to this process reach the child through their
+// env vars, while config file values are left for the child to read from its own file.
+func (w *Workspace) exec(ctx context.Context, action string, service Service) (*report.Report, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(w.root, filepath.FromSlash(service.Dir))
+
+	var stdout bytes.Buffer
+	stderr := &prefixer{prefix: service.Name + " | ", out: os.Stderr}
+
+	cmd := exec.CommandContext(ctx, executable, action)
+	cmd.Dir = dir
+	cmd.Env = append(environ(), "MONAD_CHDIR="+dir, "MONAD_OUTPUT=json")
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	stderr.Flush()
+
+	var serviceReport *report.Report
+	if stdout.Len() > 0 {
+		serviceReport = &report.Report{}
+		if decodeErr := json.Unmarshal(stdout.Bytes(), serviceReport); decodeErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("failed to decode report of %s: %w", service.Name, decodeErr))
+		}
+	}
+
+	if err != nil && serviceReport != nil && serviceReport.Error != "" {
+		return serviceReport, errors.New(serviceReport.Error)
+	}
+
+	if err != nil {
+		return serviceReport, fmt.Errorf("failed, see its log: %w", err)
+	}
+
+	return serviceReport, nil
+}
+
+// environ returns the environment of a service process
+func environ() []string {
+	var environ []string
+	for _, variable := range flag.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if !slices.Contains(reserved, name) {
+			environ = append(environ, variable)
+		}
+	}
+	return environ
+}
+
+//
+// Helpers
+//
+
+// output serializes the lines written by concurrently running services
+var output sync.Mutex
+
+// prefixer writes whole lines to out, each prefixed by prefix
+type prefixer struct {
+	prefix string
+	out    io.Writer
+	buf    []byte
+}
+
+func (p *prefixer) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		p.line(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+
+	return len(b), nil
+}
+
+// Flush writes the remainder of an unterminated line
+func (p *prefixer) Flush() {
+	if len(p.buf) > 0 {
+		p.line(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixer) line(line []byte) {
+	output.Lock()
+	defer output.Unlock()
+	fmt.Fprintf(p.out, "%s%s", p.prefix, line)
+}
@@ -0,0 +1,282 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bkeane/monad/pkg/report"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return root
+}
+
+func monorepo(t *testing.T) string {
+	return tree(t, map[string]string{
+		"services/api/Dockerfile":         "FROM scratch",
+		"services/api/monad.yaml":         "memory: 256\n",
+		"services/web/Dockerfile":         "FROM scratch",
+		"services/web/monad.toml":         "service = \"frontend\"\n",
+		"services/lib/Dockerfile":         "FROM scratch",
+		".github/action/Dockerfile":       "FROM scratch",
+		".github/action/monad.yaml":       "",
+		"node_modules/pkg/Dockerfile":     "FROM scratch",
+		"node_modules/pkg/monad.yaml":     "",
+		"services/worker/Dockerfile":      "FROM scratch",
+		"services/worker/monad.yaml":      "timeout: 60\n",
+		"services/worker/docs/readme.txt": "",
+	})
+}
+
+func TestDiscover(t *testing.T) {
+	services, err := Discover(monorepo(t))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Service{
+		{Name: "api", Dir: "services/api"},
+		{Name: "frontend", Dir: "services/web"},
+		{Name: "worker", Dir: "services/worker"},
+	}, services)
+}
+
+func TestServices_Glob(t *testing.T) {
+	workspace := &Workspace{root: monorepo(t)}
+	assert.False(t, workspace.Selected())
+
+	workspace.WorkspaceServices = []string{"services/a*", "frontend"}
+	assert.True(t, workspace.Selected())
+
+	names, err := workspace.Names()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "frontend"}, names)
+
+	workspace.WorkspaceAll = true
+	names, err = workspace.Names()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "frontend", "worker"}, names)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, (&Workspace{WorkspaceConcurrency: 1, WorkspaceServices: []string{"services/*"}}).Validate())
+	assert.Error(t, (&Workspace{WorkspaceConcurrency: -1}).Validate())
+	assert.Error(t, (&Workspace{WorkspaceConcurrency: 1, WorkspaceServices: []string{"services/["}}).Validate())
+}
+
+func TestRun(t *testing.T) {
+	var running, peak atomic.Int32
+
+	workspace := &Workspace{
+		WorkspaceAll:         true,
+		WorkspaceConcurrency: 2,
+		root:                 monorepo(t),
+		run: func(ctx context.Context, action string, service Service) (*report.Report, error) {
+			current := running.Add(1)
+			defer running.Add(-1)
+
+			for {
+				previous := peak.Load()
+				if current <= previous || peak.CompareAndSwap(previous, current) {
+					break
+				}
+			}
+
+			time.Sleep(20 * time.Millisecond)
+
+			if service.Name == "frontend" {
+				return report.New(action), errors.New("apigateway: route conflict")
+			}
+			return report.New(action), nil
+		},
+	}
+
+	batch, err := workspace.Run(context.Background(), "deploy")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "frontend: apigateway: route conflict")
+	assert.LessOrEqual(t, peak.Load(), int32(2))
+
+	assert.Equal(t, "deploy", batch.Action)
+	assert.Equal(t, report.Failed, batch.Status)
+	require.Len(t, batch.Services, 3)
+	assert.Equal(t, "api", batch.Services[0].Name)
+	assert.Equal(t, report.Succeeded, batch.Services[0].Status)
+	assert.Equal(t, "services/web", batch.Services[1].Dir)
+	assert.Equal(t, report.Failed, batch.Services[1].Status)
+	assert.Equal(t, report.Succeeded, batch.Services[2].Status)
+	assert.NotNil(t, batch.Services[2].Report)
+}
+
+func TestRun_DuplicateNames(t *testing.T) {
+	workspace := &Workspace{
+		WorkspaceAll:         true,
+		WorkspaceConcurrency: 1,
+		root: tree(t, map[string]string{
+			"a/api/Dockerfile": "FROM scratch",
+			"a/api/monad.yaml": "",
+			"b/api/Dockerfile": "FROM scratch",
+			"b/api/monad.yaml": "",
+		}),
+	}
+
+	_, err := workspace.Run(context.Background(), "deploy")
+	assert.EqualError(t, err, "service api is defined in both a/api and b/api")
+}
+
+func TestRun_NoServices(t *testing.T) {
+	workspace := &Workspace{WorkspaceAll: true, WorkspaceConcurrency: 1, root: t.TempDir()}
+
+	_, err := workspace.Run(context.Background(), "deploy")
+	assert.ErrorContains(t, err, "no services found")
+}
+
+func dependent(t *testing.T) string {
+	return tree(t, map[string]string{
+		"bus/Dockerfile":        "FROM scratch",
+		"bus/monad.yaml":        "bus: events\n",
+		"auth/Dockerfile":       "FROM scratch",
+		"auth/monad.yaml":       "",
+		"api/Dockerfile":        "FROM scratch",
+		"api/monad.yaml":        "depends_on: [auth, bus]\n",
+		"consumer/Dockerfile":   "FROM scratch",
+		"consumer/monad.toml":   "depends_on = [\"bus\"]\n",
+		"standalone/Dockerfile": "FROM scratch",
+		"standalone/monad.yaml": "",
+	})
+}
+
+// recorder runs services in the order their dependencies allow, failing those named
+type recorder struct {
+	mu    sync.Mutex
+	order []string
+	fail  []string
+}
+
+func (r *recorder) run(ctx context.Context, action string, service Service) (*report.Report, error) {
+	r.mu.Lock()
+	r.order = append(r.order, service.Name)
+	r.mu.Unlock()
+
+	for _, name := range r.fail {
+		if name == service.Name {
+			return nil, errors.New("boom")
+		}
+	}
+	return report.New(action), nil
+}
+
+func (r *recorder) before(a, b string) bool {
+	return slices.Index(r.order, a) < slices.Index(r.order, b)
+}
+
+func TestDiscover_DependsOn(t *testing.T) {
+	services, err := Discover(dependent(t))
+	require.NoError(t, err)
+	require.Len(t, services, 5)
+
+	assert.Equal(t, Service{Name: "api", Dir: "api", DependsOn: []string{"auth", "bus"}}, services[0])
+	assert.Equal(t, Service{Name: "consumer", Dir: "consumer", DependsOn: []string{"bus"}}, services[3])
+}
+
+func TestGraph(t *testing.T) {
+	_, err := Graph([]Service{
+		{Name: "api", Dir: "api", DependsOn: []string{"auth"}},
+		{Name: "auth", Dir: "auth", DependsOn: []string{"api"}},
+	})
+	assert.EqualError(t, err, "invalid depends_on: dependency cycle: api -> auth -> api")
+
+	_, err = Graph([]Service{{Name: "api", Dir: "api", DependsOn: []string{"bus"}}})
+	assert.EqualError(t, err, "invalid depends_on: api depends on unknown bus")
+}
+
+func TestRun_DependsOn(t *testing.T) {
+	root := dependent(t)
+
+	deploy := &recorder{}
+	workspace := &Workspace{WorkspaceAll: true, WorkspaceConcurrency: 5, root: root, run: deploy.run}
+	_, err := workspace.Run(context.Background(), "deploy")
+	require.NoError(t, err)
+	require.Len(t, deploy.order, 5)
+	assert.True(t, deploy.before("auth", "api"))
+	assert.True(t, deploy.before("bus", "api"))
+	assert.True(t, deploy.before("bus", "consumer"))
+
+	destroy := &recorder{}
+	workspace.run = destroy.run
+	_, err = workspace.Run(context.Background(), "destroy")
+	require.NoError(t, err)
+	require.Len(t, destroy.order, 5)
+	assert.True(t, destroy.before("api", "auth"))
+	assert.True(t, destroy.before("api", "bus"))
+	assert.True(t, destroy.before("consumer", "bus"))
+}
+
+func TestRun_DependencyFailed(t *testing.T) {
+	failing := &recorder{fail: []string{"bus"}}
+	workspace := &Workspace{WorkspaceAll: true, WorkspaceConcurrency: 5, root: dependent(t), run: failing.run}
+
+	batch, err := workspace.Run(context.Background(), "deploy")
+	assert.EqualError(t, err, "bus: boom")
+	assert.ElementsMatch(t, []string{"auth", "bus", "standalone"}, failing.order)
+
+	statuses := map[string]report.Status{}
+	for _, service := range batch.Services {
+		statuses[service.Name] = service.Status
+	}
+	assert.Equal(t, map[string]report.Status{
+		"api":        report.Skipped,
+		"auth":       report.Succeeded,
+		"bus":        report.Failed,
+		"consumer":   report.Skipped,
+		"standalone": report.Succeeded,
+	}, statuses)
+}
+
+func TestRun_UnselectedDependency(t *testing.T) {
+	selected := &recorder{}
+	workspace := &Workspace{WorkspaceServices: []string{"api"}, WorkspaceConcurrency: 1, root: dependent(t), run: selected.run}
+
+	_, err := workspace.Run(context.Background(), "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api"}, selected.order)
+}
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("MONAD_ALL", "true")
+	t.Setenv("MONAD_OUTPUT", "markdown")
+	t.Setenv("MONAD_MEMORY", "512")
+
+	environ := environ()
+	assert.Contains(t, environ, "MONAD_MEMORY=512")
+	assert.NotContains(t, environ, "MONAD_ALL=true")
+	assert.NotContains(t, environ, "MONAD_OUTPUT=markdown")
+}
+
+func TestPrefixer(t *testing.T) {
+	var out bytes.Buffer
+	p := &prefixer{prefix: "api | ", out: &out}
+
+	_, err := p.Write([]byte("first\nsec"))
+	require.NoError(t, err)
+	_, err = p.Write([]byte("ond\nthird"))
+	require.NoError(t, err)
+	p.Flush()
+
+	assert.Equal(t, "api | first\napi | second\napi | third\n", out.String())
+}